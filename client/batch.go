@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"tuplespaceCD/pkg/client"
+)
+
+// Exit codes of batch mode
+const (
+	exitFailures = 1 // At least one request failed
+	exitUsage    = 2 // The input could not be read
+)
+
+// A request of the batch, numbered by its line in the input.
+type batchJob struct {
+	line int
+	req  client.Request
+	err  error // Why the line could not be parsed
+}
+
+// batchResult is printed as one JSON line per request.
+type batchResult struct {
+	Line     int              `json:"line"`
+	OK       bool             `json:"ok"`
+	Request  client.Request   `json:"request"`
+	Response *client.Response `json:"response,omitempty"`
+	Error    string           `json:"error,omitempty"`
+}
+
+// readBatch parses the input, one request per line: either a JSON object in the shape of
+// `Request` or a command as typed in the interactive client. Empty lines and lines starting
+// with '#' are skipped.
+func readBatch(input io.Reader) ([]batchJob, error) {
+	var jobs []batchJob
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		job := batchJob{line: line}
+		if strings.HasPrefix(text, "{") {
+			job.err = json.Unmarshal([]byte(text), &job.req)
+			if job.req.RequestID == "" {
+				job.req.RequestID = client.NewRequestID()
+			}
+		} else {
+			job.req, job.err = parseCommand(text)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, scanner.Err()
+}
+
+// redacted returns the request without its secrets, to be printed with its result.
+func redacted(req client.Request) client.Request {
+	req.Password = ""
+	req.Token = ""
+	req.AuthToken = ""
+	if req.Requisition == "passwd" {
+		req.RequisitionData = ""
+	}
+	return req
+}
+
+// runBatch sends the requests of the input file ("-" for stdin), `concurrency` at a time, and
+// prints their results as JSON lines. Returns the exit code of the client.
+func runBatch(c *client.Client, path string, concurrency int) int {
+	input := os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error opening batch file:", err)
+			return exitUsage
+		}
+		defer file.Close()
+		input = file
+	}
+
+	jobs, err := readBatch(input)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading batch file:", err)
+		return exitUsage
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	queue := make(chan batchJob)
+	var mutex sync.Mutex
+	encoder := json.NewEncoder(os.Stdout)
+	failures := 0
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				result := batchResult{Line: job.line, Request: job.req}
+				if job.err != nil {
+					result.Error = job.err.Error()
+				} else {
+					// The client sends the request again if the connection drops; its request id
+					// makes sure it is not applied twice.
+					resp, err := c.Do(context.Background(), job.req)
+					if err != nil {
+						result.Error = err.Error()
+					} else {
+						result.Response = &resp
+						result.OK = !resp.Failed
+					}
+				}
+				result.Request = redacted(result.Request)
+
+				mutex.Lock()
+				if !result.OK {
+					failures++
+				}
+				encoder.Encode(result)
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for _, job := range jobs {
+		queue <- job
+	}
+	close(queue)
+	wg.Wait()
+
+	if failures > 0 {
+		return exitFailures
+	}
+	return 0
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"tuplespaceCD/pkg/client"
+)
+
+func TestParseCommand(t *testing.T) {
+	req, err := parseCommand("alice pw transfer bob 5")
+	if err != nil {
+		t.Fatalf("parseCommand: %v", err)
+	}
+	if req.BankAccount != "alice" || req.Password != "pw" || req.Requisition != "transfer" || req.RequisitionData != "bob 5" || req.RequestID == "" {
+		t.Errorf("got %+v", req)
+	}
+
+	if req, err := parseCommand("scan abc"); err != nil || req.Op != "scan" || req.Cursor != "abc" || req.Limit != scanPageSize {
+		t.Errorf("scan: got %+v, %v", req, err)
+	}
+	if _, err := parseCommand("alice pw"); err == nil {
+		t.Error("parsing a command without requisition succeeded")
+	}
+}
+
+func TestReadBatch(t *testing.T) {
+	input := strings.Join([]string{
+		"# create the accounts",
+		`{"BankAccount": "alice", "Password": "pw", "Requisition": "create", "RequisitionData": "10"}`,
+		"",
+		"alice pw balance",
+		`{"BankAccount": "bob", "RequestID": "fixed"}`,
+		`{"BankAccount": `,
+		"alice",
+	}, "\n")
+
+	jobs, err := readBatch(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("readBatch: %v", err)
+	}
+
+	want := []struct {
+		line    int
+		account string
+		err     bool
+	}{{2, "alice", false}, {4, "alice", false}, {5, "bob", false}, {6, "", true}, {7, "", true}}
+	if len(jobs) != len(want) {
+		t.Fatalf("got %d jobs, want %d", len(jobs), len(want))
+	}
+	for i, job := range jobs {
+		if job.line != want[i].line || job.req.BankAccount != want[i].account || (job.err != nil) != want[i].err {
+			t.Errorf("job %d: got line %d, %+v, %v", i, job.line, job.req, job.err)
+		}
+		if job.err == nil && job.req.RequestID == "" {
+			t.Errorf("job %d has no request id", i)
+		}
+	}
+	if jobs[2].req.RequestID != "fixed" {
+		t.Errorf("got request id %q, want the one given in the input", jobs[2].req.RequestID)
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	req := redacted(client.Request{BankAccount: "alice", Password: "pw", Token: "t", AuthToken: "a", Requisition: "passwd", RequisitionData: "new"})
+	if req.Password != "" || req.Token != "" || req.AuthToken != "" || req.RequisitionData != "" || req.BankAccount != "alice" {
+		t.Errorf("got %+v, want only the secrets cleared", req)
+	}
+	if req := redacted(client.Request{Requisition: "transfer", RequisitionData: "bob 5"}); req.RequisitionData != "bob 5" {
+		t.Errorf("transfer: got %+v, want the data kept", req)
+	}
+}
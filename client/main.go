@@ -3,28 +3,21 @@ package main
 
 import (
 	"bufio"
-	"encoding/json"
+	"context"
 	"flag"
 	"fmt"
 	"net"
 	"os"
-
+	"strconv"
 	"strings"
 	"time"
-)
 
-type Request struct {
-	BankAccount     string
-	Password        string
-	Requisition     string
-	RequisitionData string
-}
+	"tuplespaceCD/pkg/client"
+	ts "tuplespaceCD/pkg/tuplespace"
+)
 
-type JSONConnectionInfo struct {
-	MesType  string `json:"type"`
-	NodeAddr string `json:"addr"`
-	NodeID   string `json:"id"`
-}
+// Number of tuples listed per scan command
+const scanPageSize = 20
 
 func printCommands() {
 	fmt.Println("Commands:")
@@ -32,185 +25,218 @@ func printCommands() {
 	fmt.Println("  <bankAccount> <password> deposit <amount>")
 	fmt.Println("  <bankAccount> <password> withdraw <amount>")
 	fmt.Println("  <bankAccount> <password> delete")
-	fmt.Println("  <bankAccount> <password> balance")
+	fmt.Println("  <bankAccount> <password> balance [reconcile]")
+	fmt.Println("  <bankAccount> <password> transfer <to> <amount>")
+	fmt.Println("  <bankAccount> <password> statement [from] [to]")
+	fmt.Println("  <bankAccount> <password> passwd <newPassword>")
+	fmt.Println("  <bankAccount> <password> logout")
+	fmt.Println("  scan [template] [cursor]")
 }
 
-func exponentialBackoff(retries uint) time.Duration {
-	return time.Duration(1<<retries) * time.Millisecond
-}
-
-func tryConnect(address string, port uint16, retries int) (net.Conn, error) {
-	var conn net.Conn
-	var err error
-	for i := 0; i < retries; i++ {
-		conn, err = net.Dial("tcp", fmt.Sprintf("%s:%d", address, port))
-		if err == nil {
-			break
-		}
-		time.Sleep(500 * time.Millisecond)
-	}
+// Message of the bank when a session token is not valid anymore
+const sessionExpired = "Invalid or expired session"
 
-	return conn, err
+// A session opened by logging in to an account
+type session struct {
+	password string // Password the session was opened with
+	token    string
 }
 
-func findServer(startAddress string, startPort uint16, tries uint) (net.Conn, uint16, error) {
-	port := startPort
-	var conn net.Conn
-	var err error
-	for {
-		conn, err = tryConnect(startAddress, port, 3)
-		if err == nil {
-			break
-		}
-		port += 100
-	}
+// Requisitions that are sent with the password instead of a session token
+var passwordRequisitions = map[string]bool{"create": true, "login": true, "passwd": true}
 
-	// Write to the server to inform a request
-	info := JSONConnectionInfo{
-		MesType:  "request",
-		NodeAddr: "",
-		NodeID:   "",
-	}
-	b, err := json.Marshal(info)
+// login opens a session for the account and returns its token.
+func login(c *client.Client, bankAccount, password string) (string, error) {
+	resp, err := c.Do(context.Background(), client.Request{BankAccount: bankAccount, Password: password, Requisition: "login"})
 	if err != nil {
-		conn.Close()
-		return nil, 0, err
+		return "", err
 	}
-	conn.Write(b)
-
-	var response map[string]interface{}
-
-	err = json.NewDecoder(conn).Decode(&response)
-	if err != nil {
-		conn.Close()
-		return nil, 0, err
-	}
-
-	addr := response["addr"].(string)
-	isLeader := response["leader"].(bool)
-
-	if !isLeader {
-		conn.Close()
-
-		if addr == "" {
-			fmt.Printf("No leader found, timeout and try again\n")
-			time.Sleep(exponentialBackoff(tries))
-			return findServer(startAddress, startPort, tries+1)
-		}
-
-		fmt.Printf("Redirected to %s\n", addr)
-		// Strip port from address
-		addr = strings.Split(addr, ":")[0]
-
-		portToAsk := startPort
-		if addr == startAddress {
-			portToAsk = portToAsk + 100
-		}
-
-		return findServer(addr, portToAsk, tries)
+	if resp.Token == "" {
+		return "", fmt.Errorf("%s", resp.Message)
 	}
+	return resp.Token, nil
+}
 
-	// Read the new port from the server
-	var portBuf [2]byte
-	_, err = conn.Read(portBuf[:])
-	if err != nil {
-		fmt.Println("Error reading new port:", err)
-		conn.Close()
-		return nil, 0, err
+// parseCommand parses a command line of the form `<bankAccount> <password> <requisition> [data]`
+// or `scan [template] [cursor]` into a request.
+func parseCommand(line string) (client.Request, error) {
+	args := strings.Fields(line)
+	if len(args) > 0 && args[0] == "scan" {
+		return parseScan(args[1:])
 	}
-	conn.Close()
 
-	var newPort uint16 = uint16(portBuf[1])<<8 | uint16(portBuf[0])
-
-	// Connect to the server on the new port
-	var newConn net.Conn
-	newConn, err = tryConnect(startAddress, newPort, 3)
-	if err != nil {
-		return nil, 0, err
+	if len(args) < 3 {
+		return client.Request{}, fmt.Errorf("invalid command %q", line)
 	}
-
-	return newConn, newPort, nil
+	return client.Request{
+		BankAccount:     args[0],
+		Password:        args[1],
+		Requisition:     args[2],
+		RequisitionData: strings.Join(args[3:], " "),
+		RequestID:       client.NewRequestID(),
+	}, nil
 }
 
 func main() {
 	reader := bufio.NewReader(os.Stdin)
-	var serverPort uint16
 	// Get address and port from command line
 	var address string
 	var port uint
 
 	flag.StringVar(&address, "address", "localhost", "Server address")
 	flag.UintVar(&port, "port", 11000, "Server port")
+	seeds := flag.String("seeds", "", "Comma-separated host:port of the server ports of the nodes, instead of -address and -port")
+	batch := flag.String("batch", "", "Send the requests of this file (\"-\" for stdin) instead of running interactively")
+	concurrency := flag.Int("concurrency", 1, "Number of requests sent at the same time in batch mode")
+	retries := flag.Int("retries", client.DefaultRetries, "How often a request is resent when the connection drops or the leader changes")
+	retryTimeout := flag.Duration("retry-timeout", client.DefaultRetryTimeout, "How long after the first attempt a request may still be resent")
+	token := flag.String("token", os.Getenv("TSCTL_TOKEN"), "Token of the principal of tuple operations, defaults to $TSCTL_TOKEN")
 	flag.Parse()
 
-	serverPort = uint16(port)
+	endpoints := []string{net.JoinHostPort(address, strconv.Itoa(int(port)))}
+	if *seeds != "" {
+		endpoints = strings.Split(*seeds, ",")
+	}
+	c := client.New(endpoints...)
+	c.Retries = *retries
+	c.RetryTimeout = *retryTimeout
+	c.AuthToken = *token
+	c.OnRetry = func(req client.Request, attempt int, err error) {
+		fmt.Fprintf(os.Stderr, "Request %s failed (%v), resending it, attempt %d\n", req.RequestID, err, attempt+1)
+	}
+	defer c.Close()
 
-	// Connect to the server
-	conn, newPort, err := findServer(address, serverPort, 0)
-	if err != nil {
-		fmt.Println("Error connecting to server:", err)
-		return
+	if *batch != "" {
+		c.PoolSize = *concurrency
+		code := runBatch(c, *batch, *concurrency)
+		c.Close()
+		os.Exit(code)
 	}
 
-	fmt.Printf("Connected to %s:%d\n", address, newPort)
+	// The request that failed last, after the client gave up resending it. Repeating it reuses
+	// its id, so the server answers with the original result instead of applying it twice.
+	var pending *client.Request
+	// Open sessions by account
+	sessions := make(map[string]session)
 
 	for {
 		printCommands()
 		fmt.Print("Enter command: ")
 		cmd, _ := reader.ReadString('\n')
 		cmd = strings.TrimSpace(cmd)
-		args := strings.Split(cmd, " ")
 
-		if len(args) < 3 {
-			fmt.Println("Invalid command")
+		req, err := parseCommand(cmd)
+		if err != nil {
+			fmt.Println("Error:", err)
 			continue
 		}
-
-		bankAccount := args[0]
-		password := args[1]
-		requisition := args[2]
-		requisitionData := ""
-		if len(args) > 3 {
-			requisitionData = strings.Join(args[3:], " ")
+		if req.Op == "scan" {
+			if err := scan(c, req.Tuple, req.Cursor); err != nil {
+				fmt.Println("Error scanning:", err)
+			}
+			continue
+		}
+		bankAccount := req.BankAccount
+		password := req.Password
+		requisition := req.Requisition
+		// Log in once per account, then send the session token instead of the password.
+		if !passwordRequisitions[requisition] {
+			s, found := sessions[bankAccount]
+			if !found || s.password != password {
+				token, err := login(c, bankAccount, password)
+				if err != nil {
+					fmt.Println("Error logging in:", err)
+					continue
+				}
+				s = session{password: password, token: token}
+				sessions[bankAccount] = s
+			}
+			req.Password = ""
+			req.Token = s.token
 		}
 
-		req := Request{
-			BankAccount:     bankAccount,
-			Password:        password,
-			Requisition:     requisition,
-			RequisitionData: requisitionData,
+		if pending != nil && pending.BankAccount == req.BankAccount && pending.Password == req.Password &&
+			pending.Requisition == req.Requisition && pending.RequisitionData == req.RequisitionData {
+			req.RequestID = pending.RequestID
 		}
+		pending = nil
 
-		// Send the request
-		err = json.NewEncoder(conn).Encode(req)
+		resp, err := c.Do(context.Background(), req)
 		if err != nil {
 			fmt.Println("Error sending request:", err)
-			conn.Close()
+			fmt.Println("It may have been applied. Repeat it to find out, it will not be applied twice.")
+			pending = &req
 			continue
 		}
 
-		// Read the response
-		var resp map[string]interface{}
-		err = json.NewDecoder(conn).Decode(&resp)
-		if err != nil {
-			conn.Close()
-			if err.Error() == "EOF" {
-				fmt.Println("Reconnecting to server")
-				conn, newPort, err = findServer(address, serverPort, 0)
-				if err != nil {
-					fmt.Println("Error reconnecting to server:", err)
-					return
-				}
-				fmt.Printf("Connected to %s:%d. Repeat the request, please.\n", address, newPort)
-
-			}
-
-			continue
+		switch {
+		case resp.Message == sessionExpired:
+			delete(sessions, bankAccount)
+			fmt.Println("Session expired, repeat the request to log in again.")
+		case requisition == "logout" || requisition == "passwd" || requisition == "delete":
+			delete(sessions, bankAccount)
 		}
 
 		fmt.Println("Response:")
-		for k, v := range resp {
-			fmt.Printf("  %s: %v\n", k, v)
+		fmt.Printf("  BankAccount: %s\n", resp.BankAccount)
+		fmt.Printf("  Message: %s\n", resp.Message)
+		if resp.Failed {
+			fmt.Println("  Failed: true")
+		}
+		if resp.CorrelationID != "" {
+			fmt.Printf("  CorrelationID: %s\n", resp.CorrelationID)
+		}
+		if resp.Token != "" {
+			fmt.Printf("  Token: %s\n", resp.Token)
 		}
+		printEntries(resp.Entries)
+	}
+}
+
+// printEntries prints the ledger entries of a statement, one per line.
+func printEntries(entries []client.Entry) {
+	for _, entry := range entries {
+		fmt.Printf("  %s %-12s %s -> %s (%s)\n", entry.Time.UTC().Format(time.RFC3339Nano), entry.Type, entry.Amount, entry.Balance, entry.CorrelationID)
+	}
+}
+
+// parseScan parses the arguments of `scan [template] [cursor]`. The template is a tuple literal
+// such as `("job"|?int|_)`, see `ts.Parse`, and may contain spaces. Cursors are URL-safe
+// base64, so a last argument without any of the punctuation of tuple literals is the cursor.
+func parseScan(args []string) (client.Request, error) {
+	req := client.Request{Op: "scan", Limit: scanPageSize}
+	if n := len(args); n > 0 && !strings.ContainsAny(args[n-1], `()|,"`) {
+		req.Cursor = args[n-1]
+		args = args[:n-1]
+	}
+	if len(args) > 0 {
+		template, err := ts.Parse(strings.Join(args, " "))
+		if err != nil {
+			return client.Request{}, err
+		}
+		req.Tuple = template
+	}
+	return req, nil
+}
+
+// scan lists one page of the tuples matching the template, starting after the cursor. An empty
+// template matches every tuple.
+func scan(c *client.Client, template ts.Tuple, cursor string) error {
+	tuples, next, err := c.Scan(context.Background(), template, cursor, scanPageSize)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%d tuples\n", len(tuples))
+	for _, tuple := range tuples {
+		fmt.Printf("  %s\n", tuple)
+	}
+	switch {
+	case next == "":
+	case len(template.GetElements()) == 0:
+		fmt.Printf("More tuples: scan %s\n", next)
+	default:
+		fmt.Printf("More tuples: scan %s %s\n", template, next)
 	}
+	return nil
 }
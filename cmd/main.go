@@ -1,7 +1,11 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -10,19 +14,22 @@ import (
 	"os/signal"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
+	"tuplespaceCD/pkg/app"
+	"tuplespaceCD/pkg/bank"
+	"tuplespaceCD/pkg/rpc"
 	ts "tuplespaceCD/pkg/tuplespace"
 	"tuplespaceCD/store"
 
-	"github.com/micutio/goptional"
+	opt "github.com/micutio/goptional"
 )
 
 // Command line defaults
 const (
 	DefaultHTTPAddr = "localhost:11000"
 	DefaultRaftAddr = "localhost:12000"
+	DefaultApps     = "bank=2"
 )
 
 // Command line parameters
@@ -30,12 +37,16 @@ var httpAddr string // server address
 var raftAddr string
 var joinAddr string
 var nodeID string
+var apps string
+var adminToken string
 
 func init() {
 	flag.StringVar(&httpAddr, "haddr", DefaultHTTPAddr, "Set the HTTP bind address")
 	flag.StringVar(&raftAddr, "raddr", DefaultRaftAddr, "Set Raft bind address")
 	flag.StringVar(&joinAddr, "join", "", "Set join address, if any")
 	flag.StringVar(&nodeID, "id", "", "Node ID. If not set, same as Raft bind address")
+	flag.StringVar(&apps, "apps", DefaultApps, "Set the apps to run and their number of workers, e.g. bank=2")
+	flag.StringVar(&adminToken, "admin-token", os.Getenv("TS_ADMIN_TOKEN"), "Set the token of the admin principal added to a cluster without principals, defaults to $TS_ADMIN_TOKEN. Required to ever change the spaces, schemas or the policy, since clients without a token are no admins")
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: %s [options] <raft-data-path> \n", os.Args[0])
 		flag.PrintDefaults()
@@ -53,6 +64,11 @@ func main() {
 		nodeID = raftAddr
 	}
 
+	appConfig, err := app.ParseConfig(apps)
+	if err != nil {
+		log.Fatalf("failed to parse apps: %s", err.Error())
+	}
+
 	// Ensure Raft storage exists.
 	raftDir := flag.Arg(0)
 	if raftDir == "" {
@@ -71,9 +87,24 @@ func main() {
 
 	// If join was specified, make the join request.
 	if joinAddr != "" {
-		if err := join(joinAddr, raftAddr, nodeID); err != nil {
+		if err := join(joinAddr, raftAddr, nodeID, httpAddr); err != nil {
 			log.Fatalf("failed to join node at %s: %s", joinAddr, err.Error())
 		}
+	} else {
+		go announceServer(s, nodeID, httpAddr)
+	}
+
+	// Until the cluster has an admin, nobody may change the spaces, schemas or the policy. There
+	// is no other way to add the first one.
+	if adminToken != "" {
+		go bootstrapAdmin(s, adminToken)
+	} else {
+		log.Printf("no -admin-token set: unless another node sets one, the spaces, schemas and the policy cannot be changed")
+	}
+
+	// Workers are started on every node, but only the ones on the leader get to take requests.
+	if err := app.Start(s, appConfig, nil); err != nil {
+		log.Fatalf("failed to start apps: %s", err.Error())
 	}
 
 	// We're up and running!
@@ -87,11 +118,46 @@ func main() {
 	log.Println("hraftd exiting")
 }
 
-func join(joinAddr, raftAddr, nodeID string) error {
+// Name of the principal added with the admin token
+const adminPrincipal = "admin"
+
+// bootstrapAdmin adds the admin principal once this node leads, unless the cluster has
+// principals already.
+func bootstrapAdmin(space *store.Store, token string) {
+	for {
+		err := space.Bootstrap(adminPrincipal, token)
+		if err == nil {
+			return
+		}
+		if !errors.Is(err, store.ErrNotLeader) {
+			log.Printf("failed to add the admin principal: %s", err.Error())
+			return
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// announceServer records the server address of the first node once it leads. The nodes
+// joining later send theirs with the join request.
+func announceServer(space *store.Store, nodeID, serverAddr string) {
+	for space.ServerAddr(nodeID) != serverAddr {
+		err := space.SetServerAddr(nodeID, serverAddr)
+		if err != nil && !errors.Is(err, store.ErrNotLeader) {
+			log.Printf("failed to record the server address: %s", err.Error())
+			return
+		}
+		if err != nil {
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+func join(joinAddr, raftAddr, nodeID, serverAddr string) error {
 	info := JSONConnectionInfo{
-		MesType:  "join",
-		NodeAddr: raftAddr,
-		NodeID:   nodeID,
+		MesType:    "join",
+		NodeAddr:   raftAddr,
+		NodeID:     nodeID,
+		ServerAddr: serverAddr,
 	}
 
 	b, err := json.Marshal(info)
@@ -113,15 +179,11 @@ func join(joinAddr, raftAddr, nodeID string) error {
 	return nil
 }
 
-type basePortControl struct {
-	basePort uint16
-	mutex    sync.Mutex
-}
-
 type JSONConnectionInfo struct {
-	MesType  string `json:"type"`
-	NodeAddr string `json:"addr"`
-	NodeID   string `json:"id"`
+	MesType    string `json:"type"`
+	NodeAddr   string `json:"addr"`
+	NodeID     string `json:"id"`
+	ServerAddr string `json:"server,omitempty"` // Server address of a joining node
 }
 
 func startServer(space *store.Store, address string) {
@@ -148,10 +210,7 @@ func startServer(space *store.Store, address string) {
 		return
 	}
 
-	basePortControl := &basePortControl{basePort: basePort}
-
-	go worker(space)
-	go worker(space)
+	bankClient := rpc.NewClient(space, bank.Name)
 
 	for {
 		conn, err := listener.Accept()
@@ -181,7 +240,9 @@ func startServer(space *store.Store, address string) {
 		fmt.Printf("Received JSON: %v\n", info)
 
 		if info.MesType == "join" {
-			space.Join(info.NodeID, info.NodeAddr)
+			if err := space.Join(info.NodeID, info.NodeAddr, info.ServerAddr); err != nil {
+				fmt.Println("Error joining node:", err)
+			}
 			conn.Close()
 			continue
 		}
@@ -196,8 +257,9 @@ func startServer(space *store.Store, address string) {
 					"leader": true,
 				}
 			} else {
+				// Name the server of the leader, empty if it is not known
 				response = map[string]interface{}{
-					"addr":   space.GetLeaderAddr(),
+					"addr":   space.LeaderServerAddr(),
 					"leader": false,
 				}
 			}
@@ -215,144 +277,329 @@ func startServer(space *store.Store, address string) {
 			}
 		}
 
-		go handleClient(space, basePortControl, basePortControl.basePort)
+		clientListener, clientPort, err := listenClientPort(basePort)
+		if err != nil {
+			fmt.Println("Error starting listener on new port:", err)
+			conn.Close()
+			continue
+		}
+		go handleClient(space, bankClient, clientListener)
 
 		// Send the new port to the client
-		fmt.Printf("Sending new port to client: %d\n", basePort)
-		_, err = conn.Write([]byte{byte(basePort), byte(basePort >> 8)})
+		fmt.Printf("Sending new port to client: %d\n", clientPort)
+		_, err = conn.Write([]byte{byte(clientPort), byte(clientPort >> 8)})
 		if err != nil {
 			fmt.Println("Error sending new port to client:", err)
-			conn.Close()
-			continue
+			clientListener.Close()
 		}
 		conn.Close()
+	}
+}
 
-		basePortControl.mutex.Lock()
-		basePortControl.basePort++
-		basePortControl.mutex.Unlock()
+// Number of ports after the server port that are used for client connections. Nodes on the
+// same machine are spaced 100 ports apart.
+const clientPorts = 99
+
+// listenClientPort listens on the first free port from `basePort` on, for a single client.
+// The port is free again once the client is done.
+func listenClientPort(basePort uint16) (net.Listener, uint16, error) {
+	var err error
+	for port := basePort; port < basePort+clientPorts; port++ {
+		var listener net.Listener
+		listener, err = net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if err == nil {
+			fmt.Printf("Listening on port: %d\n", port)
+			return listener, port, nil
+		}
 	}
+	return nil, 0, err
 }
 
 type Request struct {
 	BankAccount     string
-	Password        string
+	Password        string `json:",omitempty"` // Only sent to create an account, log in or change the password
+	Token           string `json:",omitempty"` // Session token returned by login
 	Requisition     string
 	RequisitionData string
+	RequestID       string `json:",omitempty"` // Client-supplied id that makes retries safe
+
+	// Tuple-level operations are answered by the server itself instead of the bank worker.
+	Op      string        `json:",omitempty"` // "out", "in", "rd", "inp", "rdp", "count" or "scan"
+	Space   string        `json:",omitempty"` // Space of the operation, the default space if empty
+	Tuple   ts.Tuple      // Tuple or template of the operation
+	Lease   time.Duration `json:",omitempty"` // Lease of the tuple written by "out"
+	Timeout time.Duration `json:",omitempty"` // How long "in" and "rd" wait for a match, forever if zero
+	Cursor  string        `json:",omitempty"`
+	Limit   int           `json:",omitempty"`
+
+	AuthToken string `json:",omitempty"` // Token of the principal the tuple-level operation is made for
+	Principal string `json:",omitempty"` // Principal of an access control command
+	Rights    string `json:",omitempty"` // Comma-separated rights of a grant
+	Tag       string `json:",omitempty"` // Tag pattern of a grant
 }
 
 type Response struct {
-	BankAccount string
-	Message     string
+	BankAccount   string
+	Message       string
+	Failed        bool   `json:",omitempty"` // Set when the request was rejected or could not be answered
+	NotLeader     bool   `json:",omitempty"` // Set when the node lost leadership; the request can be sent again to the new leader
+	CorrelationID string `json:",omitempty"` // Id of the request that produced the response
+	Token         string `json:",omitempty"` // Session token of a login, or token of a new principal
+
+	Entries []bank.Entry `json:",omitempty"` // Ledger entries of a statement
+
+	Tuples []ts.Tuple `json:",omitempty"`
+	Count  int        `json:",omitempty"`
+	Cursor string     `json:",omitempty"`
 }
 
-func worker(space *store.Store) {
-	for {
-		query := ts.MakeTuple(ts.S("REQ"), ts.Any(), ts.Any(), ts.Any(), ts.Any())
-		req, err := space.Get(query)
-		if err != nil {
-			//fmt.Println("Error getting request:", err)
-			continue
-		}
-
-		if req.IsPresent() {
-			fmt.Printf("Worker got req: %v\n", req)
-			bankAccount := strings.Trim(req.Get().GetElements()[1].String(), `"`)
-			password := strings.Trim(req.Get().GetElements()[2].String(), `"`)
-			requisition := strings.Trim(req.Get().GetElements()[3].String(), `"`)
-			requisitionData := strings.Trim(req.Get().GetElements()[4].String(), `"`)
-
-			fmt.Printf("Processing request: %s %s %s %s\n", bankAccount, password, requisition, requisitionData)
+// Returns the response to a request that failed with the error.
+func failure(err error) Response {
+	return Response{Message: err.Error(), Failed: true, NotLeader: errors.Is(err, store.ErrNotLeader)}
+}
 
-			switch requisition {
-			case "create":
-				var err error
+// Page size of scans that do not set a limit
+const defaultScanLimit = 100
 
-				err = space.Write(ts.MakeTuple(ts.S(bankAccount), ts.S(password), ts.S(requisitionData)))
-				fmt.Printf("Wrote account. Error: %v\n", err)
-				err = space.Write(ts.MakeTuple(ts.S("RES"), ts.S(bankAccount), ts.S("Account created")))
-				fmt.Printf("Wrote response, Error: %v\n", err)
+// handleOp answers a tuple-level operation directly from the store.
+func handleOp(space *store.Store, req Request) Response {
+	principal, err := space.Authenticate(req.AuthToken)
+	if err != nil {
+		return failure(err)
+	}
+	// Administrative commands only carry the principal
+	admin := []store.Option{store.As(principal)}
 
-			case "delete":
-				tuple, err := space.Get(ts.MakeTuple(ts.S(bankAccount), ts.S(password), ts.Any()))
+	opts := append([]store.Option(nil), admin...)
+	if req.RequestID != "" {
+		opts = append(opts, store.RequestID(req.RequestID))
+	}
+	if req.Space != "" {
+		opts = append(opts, store.InSpace(req.Space))
+	}
 
-				if err != nil {
-					fmt.Println("Error getting tuple:", err)
-					continue
-				}
+	switch req.Op {
+	case "out":
+		if !req.Tuple.IsDefined() {
+			return Response{Message: fmt.Sprintf("cannot write %s, it has undefined fields", req.Tuple), Failed: true}
+		}
+		if err := space.Write(req.Tuple, req.Lease, opts...); err != nil {
+			return failure(err)
+		}
+		return Response{Message: "Written", Tuples: []ts.Tuple{req.Tuple}}
+	case "in", "rd", "inp", "rdp":
+		take := req.Op == "in" || req.Op == "inp"
+		blocking := req.Op == "in" || req.Op == "rd"
+		tuple, err := lookup(space, req.Tuple, take, blocking, req.Timeout, opts)
+		if err != nil {
+			return failure(err)
+		}
+		if !tuple.IsPresent() {
+			return Response{Message: "No matching tuple", Failed: true}
+		}
+		return Response{Message: "Found", Tuples: []ts.Tuple{tuple.Get()}}
+	case "count":
+		count, err := space.Count(req.Tuple, opts...)
+		if err != nil {
+			return failure(err)
+		}
+		return Response{Message: fmt.Sprintf("%d tuples", count), Count: count}
+	case "scan":
+		limit := req.Limit
+		if limit <= 0 {
+			limit = defaultScanLimit
+		}
+		tuples, cursor, err := space.Scan(req.Tuple, req.Cursor, limit, opts...)
+		if err != nil {
+			return failure(err)
+		}
+		return Response{
+			Message: fmt.Sprintf("%d tuples", len(tuples)),
+			Tuples:  tuples,
+			Cursor:  cursor,
+		}
+	case "schemas":
+		schemas := space.Schemas(admin...)
+		if len(schemas) == 0 {
+			return Response{Message: "No schemas"}
+		}
+		var resp Response
+		var lines []string
+		for _, schema := range schemas {
+			resp.Tuples = append(resp.Tuples, schema.Template())
+			lines = append(lines, fmt.Sprintf("%s version %d", schema, schema.Version))
+		}
+		resp.Message = strings.Join(lines, "\n")
+		return resp
+	case "schema":
+		schema, err := store.SchemaOf(req.Tuple)
+		if err != nil {
+			return Response{Message: err.Error(), Failed: true}
+		}
+		if schema, err = space.SetSchema(schema, admin...); err != nil {
+			return failure(err)
+		}
+		return Response{Message: fmt.Sprintf("Schema %s is at version %d", schema, schema.Version), Tuples: []ts.Tuple{schema.Template()}}
+	case "drop-schema":
+		elements := req.Tuple.GetElements()
+		if len(elements) != 1 || elements[0].GetType() != ts.STRING {
+			return Response{Message: fmt.Sprintf("%s does not name a tag", req.Tuple), Failed: true}
+		}
+		tag := elements[0].GetValue().(string)
+		dropped, err := space.DropSchema(tag, admin...)
+		if err != nil {
+			return failure(err)
+		}
+		if !dropped {
+			return Response{Message: fmt.Sprintf("No schema for %q", tag), Failed: true}
+		}
+		return Response{Message: fmt.Sprintf("Schema for %q dropped", tag)}
+	case "spaces":
+		spaces := space.Spaces(admin...)
+		return Response{Message: strings.Join(spaces, "\n"), Count: len(spaces)}
+	case "create-space":
+		created, err := space.CreateSpace(req.Space, admin...)
+		if err != nil {
+			return failure(err)
+		}
+		if !created {
+			return Response{Message: fmt.Sprintf("Space %q exists already", req.Space), Failed: true}
+		}
+		return Response{Message: fmt.Sprintf("Space %q created", req.Space)}
+	case "drop-space":
+		dropped, err := space.DropSpace(req.Space, admin...)
+		if err != nil {
+			return failure(err)
+		}
+		if !dropped {
+			return Response{Message: fmt.Sprintf("No space %q", req.Space), Failed: true}
+		}
+		return Response{Message: fmt.Sprintf("Space %q dropped", req.Space)}
+	case "policy":
+		principals, grants, err := space.Policy(admin...)
+		if err != nil {
+			return failure(err)
+		}
+		if len(principals) == 0 {
+			return Response{Message: "No principals, access is not controlled"}
+		}
+		lines := []string{"Principals: " + strings.Join(principals, ", ")}
+		for _, grant := range grants {
+			lines = append(lines, grant.String())
+		}
+		return Response{Message: strings.Join(lines, "\n")}
+	case "add-principal":
+		token := newToken()
+		if err := space.SetPrincipal(req.Principal, token, admin...); err != nil {
+			return failure(err)
+		}
+		return Response{Message: fmt.Sprintf("Principal %s has a new token", req.Principal), Token: token}
+	case "drop-principal":
+		dropped, err := space.DropPrincipal(req.Principal, admin...)
+		if err != nil {
+			return failure(err)
+		}
+		if !dropped {
+			return Response{Message: fmt.Sprintf("No principal %s", req.Principal), Failed: true}
+		}
+		return Response{Message: fmt.Sprintf("Principal %s dropped", req.Principal)}
+	case "grant", "revoke":
+		rights, err := store.ParseRights(req.Rights)
+		if err != nil {
+			return Response{Message: err.Error(), Failed: true}
+		}
+		grant := store.Grant{Principal: req.Principal, Rights: rights, Space: req.Space, Tag: req.Tag}
+		if req.Op == "grant" {
+			if err := space.Grant(grant, admin...); err != nil {
+				return failure(err)
+			}
+			return Response{Message: "Granted"}
+		}
+		revoked, err := space.Revoke(grant, admin...)
+		if err != nil {
+			return failure(err)
+		}
+		if !revoked {
+			return Response{Message: "Nothing to revoke", Failed: true}
+		}
+		return Response{Message: "Revoked"}
+	default:
+		return Response{Message: "Invalid operation!", Failed: true}
+	}
+}
 
-				if tuple.IsPresent() {
-					space.Write(ts.MakeTuple(ts.S("RES"), ts.S(bankAccount), ts.S("Account deleted")))
-				} else {
-					space.Write(ts.MakeTuple(ts.S("RES"), ts.S(bankAccount), ts.S("Account not found")))
-				}
+// lookup takes or reads a tuple matching the template. A blocking lookup waits until a match is
+// written, up to `timeout` unless it is zero; the space notifies it of every candidate.
+func lookup(space *store.Store, template ts.Tuple, take, blocking bool, timeout time.Duration, opts []store.Option) (opt.Maybe[ts.Tuple], error) {
+	find := space.Read
+	if take {
+		find = space.Get
+	}
+	if !blocking {
+		return find(template, opts...)
+	}
 
-			case "deposit":
-				tuple, err := space.Get(ts.MakeTuple(ts.S(bankAccount), ts.S(password), ts.Any()))
-				if err != nil {
-					fmt.Println("Error getting tuple:", err)
-					continue
-				}
+	wake := make(chan struct{}, 1)
+	registration, err := space.Notify(template, ts.Forever, func(event ts.Event) {
+		if event.Kind != ts.WRITTEN {
+			return
+		}
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}, opts...)
+	if err != nil {
+		return opt.NewNothing[ts.Tuple](), err
+	}
+	defer registration.Cancel()
 
-				if tuple.IsPresent() {
-					moneyStr := tuple.Get().GetElements()[2].String()
-					money, _ := strconv.Atoi(moneyStr)
-					depositAmount, _ := strconv.Atoi(requisitionData)
-					space.Write(ts.MakeTuple(ts.S(bankAccount), ts.S(password), ts.I(money+depositAmount)))
-					space.Write(ts.MakeTuple(ts.S("RES"), ts.S(bankAccount), ts.S("Deposit successful")))
-				} else {
-					space.Write(ts.MakeTuple(ts.S("RES"), ts.S(bankAccount), ts.S("Account not found")))
-				}
+	var expired <-chan time.Time
+	if timeout > 0 {
+		expired = time.After(timeout)
+	}
+	for {
+		tuple, err := find(template, opts...)
+		if err != nil || tuple.IsPresent() {
+			return tuple, err
+		}
 
-			case "withdraw":
-				tuple, err := space.Get(ts.MakeTuple(ts.S(bankAccount), ts.S(password), ts.Any()))
-				if err != nil {
-					fmt.Println("Error getting tuple:", err)
-					continue
-				}
+		select {
+		case <-wake:
+		case <-expired:
+			return tuple, nil
+		case <-time.After(lookupPollInterval):
+		}
+	}
+}
 
-				if tuple.IsPresent() {
-					moneyStr := tuple.Get().GetElements()[2].String()
-					money, _ := strconv.Atoi(moneyStr)
-					withdrawAmount, _ := strconv.Atoi(requisitionData)
-					if money >= withdrawAmount {
-						space.Write(ts.MakeTuple(ts.S(bankAccount), ts.S(password), ts.I(money-withdrawAmount)))
-						space.Write(ts.MakeTuple(ts.S("RES"), ts.S(bankAccount), ts.S("Withdrawal successful")))
-					} else {
-						space.Write(ts.MakeTuple(ts.S(bankAccount), ts.S(password), ts.I(money)))
-						space.Write(ts.MakeTuple(ts.S("RES"), ts.S(bankAccount), ts.S("Insufficient funds")))
-					}
-				} else {
-					space.Write(ts.MakeTuple(ts.S("RES"), ts.S(bankAccount), ts.S("Account not found")))
-				}
-			case "balance":
-				tuple, err := space.Read(ts.MakeTuple(ts.S(bankAccount), ts.S(password), ts.Any()))
-				if err != nil {
-					fmt.Println("Error getting tuple:", err)
-					continue
-				}
+// Blocking lookups look again after this interval, in case a notification was missed
+const lookupPollInterval = 1 * time.Second
 
-				if tuple.IsPresent() {
-					moneyStr := tuple.Get().GetElements()[2].String()
-					space.Write(ts.MakeTuple(ts.S("RES"), ts.S(bankAccount), ts.S("Balance: "+moneyStr)))
-				} else {
-					space.Write(ts.MakeTuple(ts.S("RES"), ts.S(bankAccount), ts.S("Account not found")))
-				}
-			default:
-				space.Write(ts.MakeTuple(ts.S("RES"), ts.S(bankAccount), ts.S("Invalid operation!")))
-			}
+// How long a client waits for the bank to answer, even if a worker took its request. Resending
+// the request with the same id keeps waiting for the same reply.
+const bankCallTimeout = 30 * time.Second
 
-		}
-		time.Sleep(1 * time.Second) // Sleep for a second
+// newCorrelationID returns a unique id that ties a request tuple to its response tuple.
+// Requests with a client-supplied id derive it from that id instead, so that a retried request
+// waits for the response of the original one.
+func newCorrelationID(req Request) string {
+	if req.RequestID != "" {
+		return "req-" + req.RequestID
 	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
 }
 
-func handleClient(space *store.Store, basePortCtl *basePortControl, basePort uint16) {
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", basePort))
-	fmt.Printf("Listening on port: %d\n", basePort)
-	if err != nil {
-		fmt.Println("Error starting listener on new port:", err)
-		return
-	}
+// newToken returns a random token that authenticates a principal.
+func newToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func handleClient(space *store.Store, bankClient *rpc.Client, listener net.Listener) {
 	defer listener.Close()
 
 	conn, err := listener.Accept()
@@ -362,12 +609,6 @@ func handleClient(space *store.Store, basePortCtl *basePortControl, basePort uin
 	}
 	defer conn.Close()
 
-	defer func() {
-		basePortCtl.mutex.Lock()
-		basePortCtl.basePort--
-		basePortCtl.mutex.Unlock()
-	}()
-
 	for {
 		var req Request
 		err = json.NewDecoder(conn).Decode(&req)
@@ -376,31 +617,64 @@ func handleClient(space *store.Store, basePortCtl *basePortControl, basePort uin
 			break
 		}
 
-		fmt.Printf("Received request: %v\n", req)
-
-		// Write the request to the tuple space
-		tuple := ts.MakeTuple(ts.S("REQ"), ts.S(req.BankAccount), ts.S(req.Password), ts.S(req.Requisition), ts.S(req.RequisitionData))
-		fmt.Printf("Writing tuple: %v\n", tuple)
-
-		space.Write(tuple)
-
-		var resp goptional.Maybe[ts.Tuple]
-		var respData Response
+		// Keep secrets out of the log
+		logged := req
+		if logged.Password != "" {
+			logged.Password = "***"
+		}
+		if logged.Token != "" {
+			logged.Token = "***"
+		}
+		if logged.AuthToken != "" {
+			logged.AuthToken = "***"
+		}
+		if logged.Requisition == "passwd" {
+			logged.RequisitionData = "***"
+		}
+		fmt.Printf("Received request: %v\n", logged)
 
-		for {
-			resp, err := space.Get(ts.MakeTuple(ts.S("RES"), ts.S(req.BankAccount), ts.Any()))
+		if req.Op != "" {
+			err = json.NewEncoder(conn).Encode(handleOp(space, req))
 			if err != nil {
-				fmt.Println("Error getting response:", err)
+				fmt.Println("Error sending response:", err)
 			}
+			continue
+		}
 
-			if resp.IsPresent() {
-				fmt.Printf("Got response: %s\n", resp)
-				respData = Response{
-					BankAccount: resp.Get().GetElements()[1].String(),
-					Message:     resp.Get().GetElements()[2].String(),
-				}
-				break
-			}
+		// Call the bank with the id its response will carry. A retried request, i.e. with an id
+		// the store has seen before, is not sent again and gets the reply of the original one.
+		correlationID := newCorrelationID(req)
+		opts := []rpc.CallOption{rpc.WithCorrelationID(correlationID)}
+		if req.RequestID != "" {
+			opts = append(opts, rpc.WithRequestID(req.RequestID))
+		}
+
+		bankReq := bank.Request{
+			BankAccount:     req.BankAccount,
+			Password:        req.Password,
+			Token:           req.Token,
+			Requisition:     req.Requisition,
+			RequisitionData: req.RequisitionData,
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), bankCallTimeout)
+		bankResp, err := rpc.Call[bank.Request, bank.Response](ctx, bankClient, req.Requisition, bankReq, opts...)
+		cancel()
+		notLeader := errors.Is(err, store.ErrNotLeader)
+		var remoteErr *rpc.RemoteError
+		if errors.As(err, &remoteErr) {
+			bankResp = bank.Response{BankAccount: req.BankAccount, Message: "Invalid operation!", Failed: true}
+		} else if err != nil {
+			fmt.Println("Error calling bank:", err)
+			bankResp = bank.Response{BankAccount: req.BankAccount, Message: err.Error(), Failed: true}
+		}
+		respData := Response{
+			BankAccount:   bankResp.BankAccount,
+			Message:       bankResp.Message,
+			Failed:        bankResp.Failed,
+			NotLeader:     notLeader,
+			Token:         bankResp.Token,
+			CorrelationID: correlationID,
+			Entries:       bankResp.Entries,
 		}
 
 		responseData, err := json.Marshal(respData)
@@ -414,6 +688,6 @@ func handleClient(space *store.Store, basePortCtl *basePortControl, basePort uin
 			fmt.Println("Error sending response:", err)
 		}
 
-		fmt.Printf("Sent response: %s\n", resp)
+		fmt.Printf("Sent response: %v\n", respData)
 	}
 }
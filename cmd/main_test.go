@@ -0,0 +1,228 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	ts "tuplespaceCD/pkg/tuplespace"
+	"tuplespaceCD/store"
+)
+
+func newTestStore(t *testing.T) *store.Store {
+	t.Helper()
+	s := store.New()
+	s.RaftDir = t.TempDir()
+	s.RaftBind = "127.0.0.1:0"
+	if err := s.Open(true, "node0"); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for i := 0; !s.IsLeader(); i++ {
+		if i == 100 {
+			t.Fatal("no leader")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return s
+}
+
+func TestNewCorrelationID(t *testing.T) {
+	if a, b := newCorrelationID(Request{}), newCorrelationID(Request{}); a == b || a == "" {
+		t.Errorf("generated ids %q and %q, want two different ones", a, b)
+	}
+	retried := Request{RequestID: "r1"}
+	if a, b := newCorrelationID(retried), newCorrelationID(retried); a != b {
+		t.Errorf("a retried request got %q, then %q, want the same id", a, b)
+	}
+}
+
+func TestListenClientPort(t *testing.T) {
+	// Start the range at a port that is known to be taken
+	taken, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer taken.Close()
+	base := uint16(taken.Addr().(*net.TCPAddr).Port)
+
+	listener, port, err := listenClientPort(base)
+	if err != nil {
+		t.Skipf("no free port after %d: %v", base, err)
+	}
+	defer listener.Close()
+	if port <= base || port >= base+clientPorts {
+		t.Errorf("got port %d, want one after the taken port %d", port, base)
+	}
+}
+
+func TestHandleOp(t *testing.T) {
+	space := newTestStore(t)
+	job := ts.MakeTuple(ts.S("job"), ts.I(1))
+	anyJob := ts.MakeTuple(ts.S("job"), ts.Any())
+
+	steps := []struct {
+		req    Request
+		want   string // Printed tuples of the response, or its message if it failed
+		failed bool
+	}{
+		{Request{Op: "out", Tuple: anyJob}, `cannot write ("job"|_), it has undefined fields`, true},
+		{Request{Op: "out", Tuple: job, RequestID: "out-1"}, `("job"|1)`, false},
+		{Request{Op: "out", Tuple: job, RequestID: "out-1"}, `("job"|1)`, false}, // Written once
+		{Request{Op: "count", Tuple: anyJob}, "1 tuples", false},
+		{Request{Op: "rdp", Tuple: anyJob}, `("job"|1)`, false},
+		{Request{Op: "inp", Tuple: anyJob}, `("job"|1)`, false},
+		{Request{Op: "inp", Tuple: anyJob}, "No matching tuple", true},
+		{Request{Op: "rd", Tuple: anyJob, Timeout: 50 * time.Millisecond}, "No matching tuple", true},
+		{Request{Op: "move", Tuple: anyJob}, "Invalid operation!", true},
+	}
+	for i, step := range steps {
+		resp := handleOp(space, step.req)
+		got := resp.Message
+		if !resp.Failed && resp.Tuples != nil {
+			got = resp.Tuples[0].String()
+		}
+		if got != step.want || resp.Failed != step.failed {
+			t.Errorf("step %d, %s %s: got %q (failed %v), want %q (failed %v)", i, step.req.Op, step.req.Tuple, got, resp.Failed, step.want, step.failed)
+		}
+	}
+}
+
+func TestBlockingLookupWaitsForAMatch(t *testing.T) {
+	space := newTestStore(t)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		space.Write(ts.MakeTuple(ts.S("job"), ts.I(2)), ts.Forever)
+	}()
+
+	start := time.Now()
+	resp := handleOp(space, Request{Op: "in", Tuple: ts.MakeTuple(ts.S("job"), ts.Any())})
+	if resp.Failed || len(resp.Tuples) != 1 || resp.Tuples[0].String() != `("job"|2)` {
+		t.Fatalf("in: got %+v", resp)
+	}
+	if waited := time.Since(start); waited > lookupPollInterval {
+		t.Errorf("in waited %s, want it woken by the write", waited)
+	}
+	if n, _ := space.Count(ts.MakeTuple(ts.S("job"), ts.Any())); n != 0 {
+		t.Error("in did not take the tuple")
+	}
+}
+
+func TestFailureOnFollower(t *testing.T) {
+	space := store.New()
+	space.RaftDir = t.TempDir()
+	space.RaftBind = "127.0.0.1:0"
+	if err := space.Open(false, "node0"); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	resp := handleOp(space, Request{Op: "count", Tuple: ts.MakeTuple(ts.Any())})
+	if !resp.Failed || !resp.NotLeader {
+		t.Errorf("got %+v, want a failure that tells the client to find the new leader", resp)
+	}
+	if resp := failure(errors.New("boom")); resp.NotLeader {
+		t.Error("an ordinary failure asks the client to find the new leader")
+	}
+}
+
+func TestSpaceOps(t *testing.T) {
+	space := newTestStore(t)
+	if err := space.Bootstrap("admin", "admin-token"); err != nil {
+		t.Fatalf("Bootstrap: %v", err)
+	}
+	if err := space.Grant(store.Grant{Principal: store.Everyone, Rights: []store.Right{store.RightOut, store.RightRd}}, store.As("admin")); err != nil {
+		t.Fatalf("Grant: %v", err)
+	}
+	job := ts.MakeTuple(ts.S("job"), ts.I(1))
+	anyJob := ts.MakeTuple(ts.S("job"), ts.Any())
+
+	steps := []struct {
+		req    Request
+		want   string
+		failed bool
+	}{
+		{Request{Op: "out", Space: "jobs", Tuple: job}, `no such space: "jobs"`, true},
+		{Request{Op: "create-space", Space: "jobs", AuthToken: "admin-token"}, `Space "jobs" created`, false},
+		{Request{Op: "create-space", Space: "jobs", AuthToken: "admin-token"}, `Space "jobs" exists already`, true},
+		{Request{Op: "spaces"}, "default\njobs", false},
+		{Request{Op: "out", Space: "jobs", Tuple: job}, `("job"|1)`, false},
+		{Request{Op: "count", Tuple: anyJob}, "0 tuples", false},
+		{Request{Op: "count", Space: "jobs", Tuple: anyJob}, "1 tuples", false},
+		{Request{Op: "drop-space", Space: "jobs", AuthToken: "admin-token"}, `Space "jobs" dropped`, false},
+		{Request{Op: "drop-space", Space: "jobs", AuthToken: "admin-token"}, `No space "jobs"`, true},
+	}
+	for i, step := range steps {
+		resp := handleOp(space, step.req)
+		got := resp.Message
+		if !resp.Failed && resp.Tuples != nil {
+			got = resp.Tuples[0].String()
+		}
+		if got != step.want || resp.Failed != step.failed {
+			t.Errorf("step %d, %s %q: got %q (failed %v), want %q (failed %v)", i, step.req.Op, step.req.Space, got, resp.Failed, step.want, step.failed)
+		}
+	}
+}
+
+func TestAccessControlOps(t *testing.T) {
+	space := newTestStore(t)
+	job := ts.MakeTuple(ts.S("job"), ts.I(1))
+
+	if resp := handleOp(space, Request{Op: "add-principal", Principal: "root"}); !resp.Failed {
+		t.Errorf("add-principal by anonymous: got %+v, want it refused", resp)
+	}
+	root := "root-token"
+	if err := space.Bootstrap("root", root); err != nil {
+		t.Fatalf("Bootstrap: %v", err)
+	}
+	resp := handleOp(space, Request{Op: "add-principal", Principal: "alice", AuthToken: root})
+	if resp.Failed || resp.Token == "" {
+		t.Fatalf("add-principal: got %+v, want a token", resp)
+	}
+	alice := resp.Token
+
+	steps := []struct {
+		req    Request
+		failed bool
+	}{
+		{Request{Op: "out", Tuple: job}, true},
+		{Request{Op: "out", Tuple: job, AuthToken: "forged"}, true},
+		{Request{Op: "out", Tuple: job, AuthToken: alice}, true},
+		{Request{Op: "grant", Principal: "alice", Rights: "out,rd", Tag: "job", AuthToken: alice}, true},
+		{Request{Op: "grant", Principal: "alice", Rights: "out,rd", Tag: "job", AuthToken: root}, false},
+		{Request{Op: "out", Tuple: job, AuthToken: alice}, false},
+		{Request{Op: "rdp", Tuple: job, AuthToken: alice}, false},
+		{Request{Op: "inp", Tuple: job, AuthToken: alice}, true},
+		{Request{Op: "create-space", Space: "jobs", AuthToken: alice}, true},
+		{Request{Op: "create-space", Space: "jobs", AuthToken: root}, false},
+		{Request{Op: "policy"}, true},
+		{Request{Op: "policy", AuthToken: root}, false},
+	}
+	for i, step := range steps {
+		if resp := handleOp(space, step.req); resp.Failed != step.failed {
+			t.Errorf("step %d, %s: got %q (failed %v), want failed %v", i, step.req.Op, resp.Message, resp.Failed, step.failed)
+		}
+	}
+
+	// Listings only show what the principal has rights on
+	for _, template := range []ts.Tuple{ts.MakeTuple(ts.S("job"), ts.Formal(ts.INT)), ts.MakeTuple(ts.S("task"), ts.Formal(ts.INT))} {
+		if resp := handleOp(space, Request{Op: "schema", Tuple: template, AuthToken: root}); resp.Failed {
+			t.Fatalf("schema: got %q", resp.Message)
+		}
+	}
+	listings := []struct {
+		req  Request
+		want string
+	}{
+		{Request{Op: "spaces", AuthToken: root}, "default\njobs"},
+		{Request{Op: "spaces", AuthToken: alice}, "default\njobs"},
+		{Request{Op: "spaces"}, ""},
+		{Request{Op: "schemas", AuthToken: root}, `("job"|?int) version 1` + "\n" + `("task"|?int) version 1`},
+		{Request{Op: "schemas", AuthToken: alice}, `("job"|?int) version 1`},
+		{Request{Op: "schemas"}, "No schemas"},
+	}
+	for _, listing := range listings {
+		if resp := handleOp(space, listing.req); resp.Message != listing.want {
+			t.Errorf("%s with token %q: got %q, want %q", listing.req.Op, listing.req.AuthToken, resp.Message, listing.want)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+// tsctl puts and queries tuples of the space from the command line.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"tuplespaceCD/pkg/client"
+	ts "tuplespaceCD/pkg/tuplespace"
+)
+
+// Exit codes
+const (
+	exitFailed = 1 // The operation failed, e.g. no tuple matched
+	exitUsage  = 2
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [options] <command> [arguments]\n", os.Args[0])
+	fmt.Fprintln(os.Stderr, "Commands:")
+	fmt.Fprintln(os.Stderr, "  out <tuple>        write the tuple")
+	fmt.Fprintln(os.Stderr, "  in <template>      take a matching tuple, waiting for one")
+	fmt.Fprintln(os.Stderr, "  rd <template>      read a matching tuple, waiting for one")
+	fmt.Fprintln(os.Stderr, "  inp <template>     take a matching tuple if there is one")
+	fmt.Fprintln(os.Stderr, "  rdp <template>     read a matching tuple if there is one")
+	fmt.Fprintln(os.Stderr, "  count <template>   count the matching tuples")
+	fmt.Fprintln(os.Stderr, "  scan [template]    list the matching tuples, a page at a time")
+	fmt.Fprintln(os.Stderr, "  schemas            list the schemas")
+	fmt.Fprintln(os.Stderr, "  schema <template>  set the schema of the tag in the first field, e.g. (\"job\", ?int, _)")
+	fmt.Fprintln(os.Stderr, "  drop-schema <tag>  remove the schema of the tag")
+	fmt.Fprintln(os.Stderr, "  spaces             list the spaces")
+	fmt.Fprintln(os.Stderr, "  create-space <name>")
+	fmt.Fprintln(os.Stderr, "  drop-space <name>  remove the space and its tuples")
+	fmt.Fprintln(os.Stderr, "  policy             list the principals and their grants")
+	fmt.Fprintln(os.Stderr, "  add-principal <name>")
+	fmt.Fprintln(os.Stderr, "                     add a principal, or replace its token, and print the token")
+	fmt.Fprintln(os.Stderr, "  drop-principal <name>")
+	fmt.Fprintln(os.Stderr, "  grant <principal> <rights> [space] [tag pattern]")
+	fmt.Fprintln(os.Stderr, "                     grant rights out, in, rd or admin, e.g. out,rd, on the space and tags, all by default")
+	fmt.Fprintln(os.Stderr, "  revoke <principal> <rights> [space] [tag pattern]")
+	fmt.Fprintln(os.Stderr, `Tuples are written as ("job", 3, 2.5, _), where _ matches any field and ?int, ?float,`)
+	fmt.Fprintln(os.Stderr, `?string or ?tuple match any field of that type. Tuple commands operate on -space.`)
+	fmt.Fprintln(os.Stderr, `Admin commands need the -token of an admin, at first the -admin-token of the nodes. Once`)
+	fmt.Fprintln(os.Stderr, `a principal exists, tuple commands need the token of a principal with the rights for them.`)
+	fmt.Fprintln(os.Stderr, "Options:")
+	flag.PrintDefaults()
+}
+
+func main() {
+	var address string
+	var port uint
+	var asJSON bool
+	var req client.Request
+
+	flag.StringVar(&address, "address", "localhost", "Address of any node of the cluster")
+	flag.UintVar(&port, "port", 11000, "Server port of that node")
+	seeds := flag.String("seeds", "", "Comma-separated host:port of the server ports of the nodes, instead of -address and -port")
+	flag.BoolVar(&asJSON, "json", false, "Print the response as JSON")
+	flag.StringVar(&req.Space, "space", "", "Space of the tuple commands, the default space if empty")
+	flag.StringVar(&req.AuthToken, "token", os.Getenv("TSCTL_TOKEN"), "Token of the principal, defaults to $TSCTL_TOKEN")
+	flag.DurationVar(&req.Lease, "lease", 0, "Lease of the tuple written by out, forever if zero")
+	flag.DurationVar(&req.Timeout, "timeout", 0, "How long in and rd wait for a match, forever if zero")
+	flag.StringVar(&req.Cursor, "cursor", "", "Cursor returned by the previous page of a scan")
+	flag.IntVar(&req.Limit, "limit", 20, "Number of tuples per page of a scan")
+	retries := flag.Int("retries", client.DefaultRetries, "How often the request is resent when the connection drops or the leader changes")
+	retryTimeout := flag.Duration("retry-timeout", client.DefaultRetryTimeout, "How long after the first attempt the request may still be resent")
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) < 1 {
+		usage()
+		os.Exit(exitUsage)
+	}
+	req.Op = args[0]
+	switch req.Op {
+	case "out", "in", "rd", "inp", "rdp", "count", "schema":
+		needArgs(args, 1, 1)
+		req.Tuple = parseTuple(args[1])
+	case "scan":
+		needArgs(args, 0, 1)
+		if len(args) == 2 {
+			req.Tuple = parseTuple(args[1])
+		}
+	case "drop-schema":
+		needArgs(args, 1, 1)
+		req.Tuple = ts.MakeTuple(ts.S(args[1]))
+	case "create-space", "drop-space":
+		needArgs(args, 1, 1)
+		req.Space = args[1]
+	case "add-principal", "drop-principal":
+		needArgs(args, 1, 1)
+		req.Principal = args[1]
+	case "grant", "revoke":
+		needArgs(args, 2, 4)
+		req.Principal, req.Rights = args[1], args[2]
+		if len(args) > 3 {
+			req.Space = args[3]
+		}
+		if len(args) > 4 {
+			req.Tag = args[4]
+		}
+	case "schemas", "spaces", "policy":
+		needArgs(args, 0, 0)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n", req.Op)
+		os.Exit(exitUsage)
+	}
+	// Lets the server recognize the request if it is repeated
+	req.RequestID = client.NewRequestID()
+
+	endpoints := []string{net.JoinHostPort(address, strconv.Itoa(int(port)))}
+	if *seeds != "" {
+		endpoints = strings.Split(*seeds, ",")
+	}
+	c := client.New(endpoints...)
+	c.Retries = *retries
+	c.RetryTimeout = *retryTimeout
+	defer c.Close()
+
+	resp, err := c.Do(context.Background(), req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error sending request:", err)
+		os.Exit(exitFailed)
+	}
+
+	if asJSON {
+		json.NewEncoder(os.Stdout).Encode(resp)
+	} else {
+		printText(req.Op, resp)
+	}
+	if resp.Failed {
+		os.Exit(exitFailed)
+	}
+}
+
+// needArgs exits with the usage unless the command has between min and max arguments.
+func needArgs(args []string, min, max int) {
+	if len(args)-1 < min || len(args)-1 > max {
+		usage()
+		os.Exit(exitUsage)
+	}
+}
+
+func parseTuple(text string) ts.Tuple {
+	tuple, err := ts.Parse(text)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid tuple: %s\n", err)
+		os.Exit(exitUsage)
+	}
+	return tuple
+}
+
+func printText(op string, resp client.Response) {
+	if resp.Failed {
+		fmt.Fprintln(os.Stderr, resp.Message)
+		return
+	}
+
+	switch op {
+	case "count":
+		fmt.Println(resp.Count)
+	case "add-principal":
+		fmt.Println(resp.Token)
+	case "schemas", "schema", "drop-schema", "spaces", "create-space", "drop-space",
+		"policy", "drop-principal", "grant", "revoke":
+		fmt.Println(resp.Message)
+	default:
+		for _, tuple := range resp.Tuples {
+			fmt.Println(tuple)
+		}
+		if resp.Cursor != "" {
+			fmt.Fprintf(os.Stderr, "More tuples: -cursor %s\n", resp.Cursor)
+		}
+	}
+}
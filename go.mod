@@ -6,6 +6,7 @@ require (
 	github.com/hashicorp/raft v1.7.0
 	github.com/micutio/goptional v0.0.0-20220828164448-97a639612bb1
 	github.com/tidwall/btree v1.7.0
+	golang.org/x/crypto v0.14.0
 )
 
 require (
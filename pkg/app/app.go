@@ -0,0 +1,130 @@
+// Package app hosts pluggable applications on a node. Applications register a factory under
+// their name, usually from an `init` function, and the node starts the ones it is configured to
+// run, each answering the rpc service of the same name with its own pool of workers.
+package app
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"tuplespaceCD/pkg/rpc"
+	"tuplespaceCD/store"
+)
+
+// App is an application served through the rpc framework.
+type App interface {
+	// Register adds the handlers of the application's operations to the server.
+	Register(srv *rpc.Server)
+}
+
+// Initializer is implemented by applications that prepare the space before their workers take
+// requests, e.g. to convert data written by an older version.
+type Initializer interface {
+	// Init is called once the node leads, until it succeeds. It must be safe to call again
+	// on another node after a failover, since every node runs it.
+	Init() error
+}
+
+// How long to wait before initializing an application again on a node that does not lead
+const initRetryInterval = 1 * time.Second
+
+// Factory creates an application on top of the replicated store.
+type Factory func(space *store.Store) App
+
+var factories = make(map[string]Factory)
+
+// Register makes an application available under the given name.
+// It panics if the name is already taken.
+func Register(name string, factory Factory) {
+	if _, found := factories[name]; found {
+		panic(fmt.Sprintf("app: %s registered twice", name))
+	}
+	factories[name] = factory
+}
+
+// Names lists the registered applications in alphabetical order.
+func Names() []string {
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Config maps the name of each application to run to its number of workers.
+type Config map[string]int
+
+// ParseConfig parses a configuration such as "bank=2,echo=1". An application listed without a
+// worker count gets a single worker.
+func ParseConfig(s string) (Config, error) {
+	config := make(Config)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, count, hasCount := strings.Cut(entry, "=")
+		workers := 1
+		if hasCount {
+			var err error
+			workers, err = strconv.Atoi(count)
+			if err != nil || workers < 1 {
+				return nil, fmt.Errorf("invalid number of workers for %s: %q", name, count)
+			}
+		}
+		config[name] = workers
+	}
+	return config, nil
+}
+
+// Start runs the configured applications until `stop` is closed. Workers are started on every
+// node, but only the ones on the leader get to take requests. Applications implementing
+// `Initializer` start theirs once they are initialized.
+func Start(space *store.Store, config Config, stop <-chan struct{}) error {
+	for name := range config {
+		if _, found := factories[name]; !found {
+			return fmt.Errorf("unknown app %q, available apps: %s", name, strings.Join(Names(), ", "))
+		}
+	}
+
+	for name, workers := range config {
+		srv := rpc.NewServer(space, name)
+		application := factories[name](space)
+		application.Register(srv)
+		go func(name string, workers int) {
+			if err := initialize(application, stop); err != nil {
+				fmt.Printf("Failed to initialize %s, not serving it: %s\n", name, err)
+				return
+			}
+			for i := 0; i < workers; i++ {
+				go srv.Serve(stop)
+			}
+		}(name, workers)
+	}
+	return nil
+}
+
+// initialize calls `Init` of the application, if it has one, until the node leads.
+func initialize(application App, stop <-chan struct{}) error {
+	initializer, ok := application.(Initializer)
+	if !ok {
+		return nil
+	}
+	for {
+		err := initializer.Init()
+		if !errors.Is(err, store.ErrNotLeader) {
+			return err
+		}
+		select {
+		case <-stop:
+			return err
+		case <-time.After(initRetryInterval):
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package app
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"tuplespaceCD/pkg/rpc"
+	"tuplespaceCD/store"
+)
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		config string
+		want   Config
+		err    bool
+	}{
+		{"", Config{}, false},
+		{"bank", Config{"bank": 1}, false},
+		{"bank=2", Config{"bank": 2}, false},
+		{"bank=2, echo=1", Config{"bank": 2, "echo": 1}, false},
+		{"bank=2,,", Config{"bank": 2}, false},
+		{"bank=0", nil, true},
+		{"bank=-1", nil, true},
+		{"bank=two", nil, true},
+	}
+
+	for _, test := range tests {
+		got, err := ParseConfig(test.config)
+		if (err != nil) != test.err {
+			t.Errorf("ParseConfig(%q): got error %v, want error %v", test.config, err, test.err)
+			continue
+		}
+		if !test.err && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseConfig(%q): got %v, want %v", test.config, got, test.want)
+		}
+	}
+}
+
+type testApp struct{}
+
+func (testApp) Register(srv *rpc.Server) {}
+
+func TestStartUnknownApp(t *testing.T) {
+	Register("test", func(*store.Store) App { return testApp{} })
+
+	if err := Start(nil, Config{"test": 1, "unknown": 1}, nil); err == nil {
+		t.Error("starting an unknown app succeeded")
+	}
+
+	found := false
+	for _, name := range Names() {
+		found = found || name == "test"
+	}
+	if !found {
+		t.Errorf("registered app missing from %v", Names())
+	}
+}
+
+type initApp struct {
+	testApp
+	errs  []error
+	calls int
+}
+
+func (a *initApp) Init() error {
+	a.calls++
+	return a.errs[a.calls-1]
+}
+
+func TestInitialize(t *testing.T) {
+	failed := errors.New("failed")
+	tests := []struct {
+		errs  []error
+		calls int
+		err   error
+	}{
+		{[]error{nil}, 1, nil},
+		{[]error{store.ErrNotLeader, nil}, 2, nil},
+		{[]error{failed, nil}, 1, failed},
+	}
+
+	for _, test := range tests {
+		application := &initApp{errs: test.errs}
+		if err := initialize(application, nil); !errors.Is(err, test.err) || application.calls != test.calls {
+			t.Errorf("errors %v: got %v after %d calls, want %v after %d", test.errs, err, application.calls, test.err, test.calls)
+		}
+	}
+
+	stop := make(chan struct{})
+	close(stop)
+	application := &initApp{errs: []error{store.ErrNotLeader}}
+	if err := initialize(application, stop); !errors.Is(err, store.ErrNotLeader) {
+		t.Errorf("stopped: got %v, want ErrNotLeader", err)
+	}
+	if err := initialize(testApp{}, nil); err != nil {
+		t.Errorf("no Init: got %v", err)
+	}
+}
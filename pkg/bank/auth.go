@@ -0,0 +1,243 @@
+package bank
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	ts "tuplespaceCD/pkg/tuplespace"
+	"tuplespaceCD/store"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Accounts store the bcrypt hash of their password in place of the password. Clients log in
+// with the password once and then send the token of their session, kept as a
+// ("SESSION", hash of the token, account) tuple that expires unless it is used. Failed logins
+// are counted in a ("LOCKOUT", account, failures) tuple, and the account is locked once there
+// are `maxFailures` within `lockoutPeriod`.
+const (
+	sessionTag    = "SESSION"
+	sessionLease  = 30 * time.Minute
+	lockoutTag    = "LOCKOUT"
+	maxFailures   = 5
+	lockoutPeriod = 15 * time.Minute
+)
+
+// Messages of requests that failed to authenticate
+const (
+	msgNotFound       = "Account not found"
+	msgBadCredentials = "Invalid credentials"
+	msgLocked         = "Account locked, try again later"
+	msgBadSession     = "Invalid or expired session"
+)
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// newCredential returns the stored form of a password.
+func newCredential(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// checkPassword tells whether the password matches the stored credential, and whether the
+// credential is of an older form that should be replaced. Accounts created before passwords
+// were hashed still hold them in plain text, and later ones until bcrypt hold
+//
+//	"sha256$<iterations>$<salt>$<hash>"
+func checkPassword(credential, password string) (matches, outdated bool) {
+	if strings.HasPrefix(credential, "$2") {
+		return bcrypt.CompareHashAndPassword([]byte(credential), []byte(password)) == nil, false
+	}
+
+	parts := strings.Split(credential, "$")
+	if len(parts) != 4 || parts[0] != "sha256" {
+		return subtle.ConstantTimeCompare([]byte(credential), []byte(password)) == 1, true
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return false, true
+	}
+	sum := sha256.Sum256([]byte(parts[2] + password))
+	for i := 1; i < iterations; i++ {
+		sum = sha256.Sum256(append([]byte(parts[2]), sum[:]...))
+	}
+	return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(parts[3])) == 1, true
+}
+
+// Sessions are looked up by the hash of their token, so the space never holds a token that
+// could be used to act on an account.
+func sessionTuple(token, account string) ts.Tuple {
+	sum := sha256.Sum256([]byte(token))
+	return ts.MakeTuple(ts.S(sessionTag), ts.S(hex.EncodeToString(sum[:])), ts.S(account))
+}
+
+func accountTemplate(account string, credential ts.Elem) ts.Tuple {
+	return ts.MakeTuple(ts.S(account), credential, ts.Any())
+}
+
+func sessionTemplate(account string) ts.Template3[string, string, string] {
+	return ts.NewTemplate3(ts.Is(sessionTag), ts.AnyOf[string](), ts.Is(account))
+}
+
+func lockoutTemplate(account string) ts.Template3[string, string, int] {
+	return ts.NewTemplate3(ts.Is(lockoutTag), ts.Is(account), ts.AnyOf[int]())
+}
+
+// authenticate checks the session token of the request or, if it has none, its password.
+// It returns the credential field of the account, or why the request was denied.
+func (b *Bank) authenticate(req Request) (ts.Elem, string, error) {
+	if req.Token != "" {
+		renewed, err := b.space.Renew(sessionTuple(req.Token, req.BankAccount), sessionLease)
+		if err != nil {
+			return ts.Elem{}, "", err
+		}
+		if !renewed {
+			return ts.Elem{}, msgBadSession, nil
+		}
+
+		account, err := b.space.Read(accountTemplate(req.BankAccount, ts.Any()))
+		if err != nil {
+			return ts.Elem{}, "", err
+		}
+		if !account.IsPresent() {
+			return ts.Elem{}, msgNotFound, nil
+		}
+		return account.Get().GetElements()[1], "", nil
+	}
+	return b.checkCredentials(req.BankAccount, req.Password)
+}
+
+// checkCredentials checks the password of the account, counting failures towards a lockout.
+func (b *Bank) checkCredentials(account, password string) (ts.Elem, string, error) {
+	lockouts := lockoutTemplate(account)
+	lockout, err := b.space.Read(lockouts.Tuple())
+	if err != nil {
+		return ts.Elem{}, "", err
+	}
+	if lockout.IsPresent() {
+		_, _, failures, err := lockouts.Extract(lockout.Get())
+		if err != nil {
+			return ts.Elem{}, "", err
+		}
+		if failures >= maxFailures {
+			return ts.Elem{}, msgLocked, nil
+		}
+	}
+
+	tuple, err := b.space.Read(accountTemplate(account, ts.Any()))
+	if err != nil {
+		return ts.Elem{}, "", err
+	}
+	if !tuple.IsPresent() {
+		return ts.Elem{}, msgNotFound, nil
+	}
+
+	credential := tuple.Get().GetElements()[1]
+	stored, _ := credential.GetValue().(string)
+	if matches, outdated := checkPassword(stored, password); matches {
+		if lockout.IsPresent() {
+			if _, err := b.space.Get(lockouts.Tuple()); err != nil {
+				return ts.Elem{}, "", err
+			}
+		}
+		if outdated {
+			return b.upgradeCredential(account, credential, password)
+		}
+		return credential, "", nil
+	}
+
+	// Each failure restarts the lockout period.
+	counted, err := b.space.UpdateFields(lockouts.Tuple(), lockoutPeriod, store.AddField(2, ts.I(1)))
+	if err != nil {
+		return ts.Elem{}, "", err
+	}
+	if !counted.IsPresent() {
+		if err := b.space.Write(ts.MakeTuple(ts.S(lockoutTag), ts.S(account), ts.I(1)), lockoutPeriod); err != nil {
+			return ts.Elem{}, "", err
+		}
+	}
+	return ts.Elem{}, msgBadCredentials, nil
+}
+
+// upgradeCredential replaces an outdated credential of the account with the bcrypt hash of its
+// password, once the password was checked. Returns the credential the account has afterwards.
+func (b *Bank) upgradeCredential(account string, credential ts.Elem, password string) (ts.Elem, string, error) {
+	upgraded, err := newCredential(password)
+	if err != nil {
+		return ts.Elem{}, "", err
+	}
+	updated, err := b.space.UpdateFields(accountTemplate(account, credential), ts.Forever, store.SetField(1, ts.S(upgraded)))
+	if err != nil {
+		return ts.Elem{}, "", err
+	}
+	if !updated.IsPresent() {
+		// Changed concurrently, e.g. by another upgrade
+		return credential, "", nil
+	}
+	return updated.Get().GetElements()[1], "", nil
+}
+
+// login checks the password and opens a session, whose token is returned in the response.
+func (b *Bank) login(req Request) (Response, error) {
+	_, denied, err := b.checkCredentials(req.BankAccount, req.Password)
+	if err != nil || denied != "" {
+		return rejected(req, denied), err
+	}
+
+	token := randomHex(32)
+	if err := b.space.Write(sessionTuple(token, req.BankAccount), sessionLease); err != nil {
+		return Response{}, err
+	}
+	return Response{BankAccount: req.BankAccount, Message: "Logged in", Token: token}, nil
+}
+
+// logout closes the session of the request.
+func (b *Bank) logout(req Request) (Response, error) {
+	found, err := b.space.Cancel(sessionTuple(req.Token, req.BankAccount))
+	if err != nil {
+		return Response{}, err
+	}
+	if !found {
+		return rejected(req, msgBadSession), nil
+	}
+	return Response{BankAccount: req.BankAccount, Message: "Logged out"}, nil
+}
+
+// passwd changes the password of the account to the requisition data. It always needs the
+// current password, even with a session, and closes all sessions of the account.
+func (b *Bank) passwd(req Request) (Response, error) {
+	if req.RequisitionData == "" {
+		return rejected(req, "Usage: passwd <new password>"), nil
+	}
+
+	credential, denied, err := b.checkCredentials(req.BankAccount, req.Password)
+	if err != nil || denied != "" {
+		return rejected(req, denied), err
+	}
+
+	replacement, err := newCredential(req.RequisitionData)
+	if err != nil {
+		return rejected(req, "Invalid password: "+err.Error()), nil
+	}
+	updated, err := b.space.UpdateFields(accountTemplate(req.BankAccount, credential), ts.Forever, store.SetField(1, ts.S(replacement)))
+	if err != nil {
+		return Response{}, err
+	}
+	if !updated.IsPresent() {
+		return rejected(req, "Password changed concurrently"), nil
+	}
+
+	if _, err := b.space.GetAll(sessionTemplate(req.BankAccount).Tuple(), 0); err != nil {
+		return Response{}, err
+	}
+	return Response{BankAccount: req.BankAccount, Message: "Password changed"}, nil
+}
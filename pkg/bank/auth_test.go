@@ -0,0 +1,187 @@
+package bank
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	ts "tuplespaceCD/pkg/tuplespace"
+)
+
+func TestCheckPassword(t *testing.T) {
+	credential, err := newCredential("secret")
+	if err != nil {
+		t.Fatalf("newCredential: %v", err)
+	}
+	other, _ := newCredential("secret")
+	if strings.Contains(credential, "secret") || credential == other {
+		t.Errorf("credential %q holds the password or no salt", credential)
+	}
+	sum := sha256.Sum256([]byte("salt" + "secret"))
+	salted := "sha256$1$salt$" + hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		credential string
+		password   string
+		matches    bool
+		outdated   bool
+	}{
+		{credential, "secret", true, false},
+		{credential, "Secret", false, false},
+		{credential, "", false, false},
+		{salted, "secret", true, true},
+		{salted, "other", false, true},
+		{"plain", "plain", true, true}, // Stored before passwords were hashed
+		{"plain", "other", false, true},
+		{"sha256$x$salt$hash", "secret", false, true},
+		{"sha256$0$salt$hash", "secret", false, true},
+	}
+	for _, test := range tests {
+		matches, outdated := checkPassword(test.credential, test.password)
+		if matches != test.matches || outdated != test.outdated {
+			t.Errorf("checkPassword(%q, %q): got %v, %v, want %v, %v", test.credential, test.password, matches, outdated, test.matches, test.outdated)
+		}
+	}
+}
+
+func TestOutdatedCredentialsAreUpgraded(t *testing.T) {
+	space := newTestSpace(t)
+	client := serveBank(t, space)
+	if err := space.Write(ts.MakeTuple(ts.S("alice"), ts.S("pw"), ts.I(100)), ts.Forever); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	if resp := call(t, client, Request{BankAccount: "alice", Password: "pw", Requisition: "balance"}); resp.Message != "Balance: 1.00" {
+		t.Fatalf("balance with the plain text password: got %q", resp.Message)
+	}
+	account, err := space.Read(accountTemplate("alice", ts.Any()))
+	if err != nil || !account.IsPresent() {
+		t.Fatalf("Read: got %v, %v", account, err)
+	}
+	stored := account.Get().GetElements()[1].GetValue().(string)
+	if _, outdated := checkPassword(stored, "pw"); outdated || stored == "pw" {
+		t.Errorf("credential %q was not upgraded", stored)
+	}
+	if resp := call(t, client, Request{BankAccount: "alice", Password: "pw", Requisition: "balance"}); resp.Message != "Balance: 1.00" {
+		t.Errorf("balance after the upgrade: got %q", resp.Message)
+	}
+}
+
+func TestSessionTuplesHoldNoToken(t *testing.T) {
+	space := newTestSpace(t)
+	client := serveBank(t, space)
+	call(t, client, Request{BankAccount: "alice", Password: "pw", Requisition: "create"})
+	login := call(t, client, Request{BankAccount: "alice", Password: "pw", Requisition: "login"})
+
+	sessions, err := space.ReadAll(sessionTemplate("alice").Tuple(), 0)
+	if err != nil || len(sessions) != 1 {
+		t.Fatalf("ReadAll: got %v, %v, want one session", sessions, err)
+	}
+	if strings.Contains(sessions[0].String(), login.Token) {
+		t.Errorf("session %s holds the token", sessions[0])
+	}
+}
+
+func TestRedact(t *testing.T) {
+	req := Request{BankAccount: "alice", Password: "pw", Token: "t", Requisition: "passwd", RequisitionData: "new"}
+	req.Redact()
+	if req.Password != "" || req.Token != "" || req.RequisitionData != "" || req.BankAccount != "alice" {
+		t.Errorf("got %+v, want only the secrets cleared", req)
+	}
+	deposit := Request{Password: "pw", Requisition: "deposit", RequisitionData: "5"}
+	deposit.Redact()
+	if deposit.RequisitionData != "5" {
+		t.Errorf("the amount of a deposit was cleared")
+	}
+}
+
+func TestSessions(t *testing.T) {
+	client := newTestBank(t)
+
+	call(t, client, Request{BankAccount: "alice", Password: "pw", Requisition: "create", RequisitionData: "1"})
+	login := call(t, client, Request{BankAccount: "alice", Password: "pw", Requisition: "login"})
+	if login.Token == "" {
+		t.Fatalf("login: got %q without a token", login.Message)
+	}
+	if other := call(t, client, Request{BankAccount: "alice", Password: "wrong", Requisition: "login"}); other.Token != "" || other.Message != msgBadCredentials {
+		t.Errorf("login with a wrong password: got %q", other.Message)
+	}
+
+	tests := []struct {
+		name        string
+		account     string
+		token       string
+		requisition string
+		message     string
+	}{
+		{"with token", "alice", login.Token, "balance", "Balance: 1.00"},
+		{"with unknown token", "alice", "nope", "balance", msgBadSession},
+		{"with the token of another account", "bob", login.Token, "balance", msgBadSession},
+		{"logout", "alice", login.Token, "logout", "Logged out"},
+		{"after logout", "alice", login.Token, "balance", msgBadSession},
+		{"logout twice", "alice", login.Token, "logout", msgBadSession},
+	}
+	for _, test := range tests {
+		resp := call(t, client, Request{BankAccount: test.account, Token: test.token, Requisition: test.requisition})
+		if resp.Message != test.message {
+			t.Errorf("%s: got %q, want %q", test.name, resp.Message, test.message)
+		}
+	}
+}
+
+func TestLockout(t *testing.T) {
+	client := newTestBank(t)
+	call(t, client, Request{BankAccount: "alice", Password: "pw", Requisition: "create"})
+
+	// A successful login clears earlier failures
+	for i := 0; i < maxFailures-1; i++ {
+		call(t, client, Request{BankAccount: "alice", Password: "wrong", Requisition: "balance"})
+	}
+	if resp := call(t, client, Request{BankAccount: "alice", Password: "pw", Requisition: "balance"}); resp.Message != "Balance: 0.00" {
+		t.Fatalf("balance: got %q", resp.Message)
+	}
+
+	for i := 0; i < maxFailures; i++ {
+		if resp := call(t, client, Request{BankAccount: "alice", Password: "wrong", Requisition: "balance"}); resp.Message != msgBadCredentials {
+			t.Errorf("failure %d: got %q", i+1, resp.Message)
+		}
+	}
+	if resp := call(t, client, Request{BankAccount: "alice", Password: "pw", Requisition: "login"}); resp.Message != msgLocked {
+		t.Errorf("login after %d failures: got %q, want the account locked", maxFailures, resp.Message)
+	}
+}
+
+func TestPasswd(t *testing.T) {
+	client := newTestBank(t)
+	call(t, client, Request{BankAccount: "alice", Password: "old", Requisition: "create"})
+	login := call(t, client, Request{BankAccount: "alice", Password: "old", Requisition: "login"})
+
+	tests := []struct {
+		name     string
+		password string
+		token    string
+		data     string
+		message  string
+	}{
+		{"without new password", "old", "", "", "Usage: passwd <new password>"},
+		{"with a session only", "", login.Token, "new", msgBadCredentials},
+		{"with the old password", "old", "", "new", "Password changed"},
+	}
+	for _, test := range tests {
+		resp := call(t, client, Request{BankAccount: "alice", Password: test.password, Token: test.token, Requisition: "passwd", RequisitionData: test.data})
+		if resp.Message != test.message {
+			t.Errorf("%s: got %q, want %q", test.name, resp.Message, test.message)
+		}
+	}
+
+	if resp := call(t, client, Request{BankAccount: "alice", Token: login.Token, Requisition: "balance"}); resp.Message != msgBadSession {
+		t.Errorf("session after passwd: got %q, want it closed", resp.Message)
+	}
+	if resp := call(t, client, Request{BankAccount: "alice", Password: "old", Requisition: "balance"}); resp.Message != msgBadCredentials {
+		t.Errorf("old password: got %q", resp.Message)
+	}
+	if resp := call(t, client, Request{BankAccount: "alice", Password: "new", Requisition: "balance"}); resp.Message != "Balance: 0.00" {
+		t.Errorf("new password: got %q", resp.Message)
+	}
+}
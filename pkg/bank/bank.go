@@ -0,0 +1,346 @@
+// Package bank is the bank application: accounts holding money, stored as
+//
+//	(account, credential, balance)
+//
+// tuples, the balance being an INT of cents (see `Amount`), next to their ledger, and served
+// under the "bank" rpc service.
+package bank
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"tuplespaceCD/pkg/app"
+	"tuplespaceCD/pkg/rpc"
+	ts "tuplespaceCD/pkg/tuplespace"
+	"tuplespaceCD/store"
+)
+
+// Name of the application and of its rpc service
+const Name = "bank"
+
+func init() {
+	app.Register(Name, New)
+}
+
+// Request is a requisition on an account.
+type Request struct {
+	BankAccount     string
+	Password        string // Only needed to create an account, log in or change the password
+	Token           string `json:",omitempty"` // Session token returned by login
+	Requisition     string
+	RequisitionData string
+}
+
+// Redact clears the password and token of the request, and the new password of a passwd, so
+// that failed requests are dead-lettered without them.
+func (req *Request) Redact() {
+	req.Password = ""
+	req.Token = ""
+	if req.Requisition == "passwd" {
+		req.RequisitionData = ""
+	}
+}
+
+// Response is the outcome of a requisition.
+type Response struct {
+	BankAccount string
+	Message     string
+	Failed      bool    `json:",omitempty"` // Set when the requisition was rejected; the message says why
+	Token       string  `json:",omitempty"` // Session token of a login
+	Entries     []Entry `json:",omitempty"` // Ledger entries of a statement
+}
+
+// Bank answers the requisitions of the bank service.
+type Bank struct {
+	space *store.Store
+}
+
+// New returns the bank application on top of the store.
+func New(space *store.Store) app.App {
+	return &Bank{space: space}
+}
+
+// Register adds the handlers of the bank's requisitions to the server.
+func (b *Bank) Register(srv *rpc.Server) {
+	rpc.HandleCall(srv, "create", b.create)
+	rpc.HandleCall(srv, "delete", b.delete)
+	rpc.HandleCall(srv, "deposit", b.deposit)
+	rpc.HandleCall(srv, "withdraw", b.withdraw)
+	rpc.Handle(srv, "balance", b.balance)
+	rpc.HandleCall(srv, "transfer", b.transfer)
+	rpc.Handle(srv, "statement", b.statement)
+	rpc.Handle(srv, "login", b.login)
+	rpc.Handle(srv, "logout", b.logout)
+	rpc.Handle(srv, "passwd", b.passwd)
+}
+
+func (b *Bank) create(call rpc.CallInfo, req Request) (Response, error) {
+	if req.Password == "" {
+		return rejected(req, "A password is required"), nil
+	}
+	if req.BankAccount == sessionTag || req.BankAccount == lockoutTag {
+		return rejected(req, "Reserved account name"), nil
+	}
+	var initial Amount
+	if req.RequisitionData != "" {
+		var err error
+		initial, err = ParseAmount(req.RequisitionData)
+		if err != nil || initial < 0 {
+			return rejected(req, invalidAmount(req.RequisitionData, err)), nil
+		}
+	}
+	credential, err := newCredential(req.Password)
+	if err != nil {
+		return rejected(req, "Invalid password: "+err.Error()), nil
+	}
+
+	// The account is only written if there is none of the name, in the same transaction, so
+	// concurrent requests cannot both create it
+	resp := Response{BankAccount: req.BankAccount, Message: "Account created"}
+	_, err = call.Transact(b.space, resp,
+		store.AbsentOp(accountTemplate(req.BankAccount, ts.Any())),
+		store.WriteOp(ts.MakeTuple(ts.S(req.BankAccount), ts.S(credential), initial.Elem()), ts.Forever),
+		entryOp(req.BankAccount, "open", initial, initial, call.CorrelationID),
+	)
+	if errors.Is(err, store.ErrTxAborted) {
+		return rejected(req, "Account already exists"), nil
+	}
+	if err != nil {
+		return Response{}, err
+	}
+	return resp, nil
+}
+
+func (b *Bank) delete(call rpc.CallInfo, req Request) (Response, error) {
+	credential, denied, err := b.authenticate(req)
+	if err != nil || denied != "" {
+		return rejected(req, denied), err
+	}
+
+	for {
+		tuple, err := b.space.Read(accountTemplate(req.BankAccount, credential))
+		if err != nil {
+			return Response{}, err
+		}
+		if !tuple.IsPresent() {
+			return rejected(req, msgNotFound), nil
+		}
+
+		balance, err := amountOf(tuple.Get().GetElements()[2])
+		if err != nil {
+			return Response{}, err
+		}
+
+		resp := Response{BankAccount: req.BankAccount, Message: "Account deleted"}
+		_, err = call.Transact(b.space, resp,
+			store.GetOp(tuple.Get()),
+			entryOp(req.BankAccount, "close", -balance, 0, call.CorrelationID),
+		)
+		if err == nil {
+			if _, err := b.space.GetAll(sessionTemplate(req.BankAccount).Tuple(), 0); err != nil {
+				return Response{}, err
+			}
+			return resp, nil
+		}
+		if !errors.Is(err, store.ErrTxAborted) {
+			return Response{}, err
+		}
+		fmt.Printf("Account %s changed concurrently, retrying: %v\n", req.BankAccount, err)
+	}
+}
+
+func (b *Bank) deposit(call rpc.CallInfo, req Request) (Response, error) {
+	amount, err := parsePositive(req.RequisitionData)
+	if err != nil {
+		return rejected(req, invalidAmount(req.RequisitionData, err)), nil
+	}
+
+	return b.updateAccount(call, req, "deposit", func(balance Amount) (Amount, string) {
+		updated, err := balance.Add(amount)
+		if err != nil {
+			return balance, "Deposit rejected: balance would exceed the maximum"
+		}
+		return updated, "Deposit successful"
+	})
+}
+
+func (b *Bank) withdraw(call rpc.CallInfo, req Request) (Response, error) {
+	amount, err := parsePositive(req.RequisitionData)
+	if err != nil {
+		return rejected(req, invalidAmount(req.RequisitionData, err)), nil
+	}
+
+	return b.updateAccount(call, req, "withdraw", func(balance Amount) (Amount, string) {
+		if balance < amount {
+			return balance, "Insufficient funds"
+		}
+		return balance - amount, "Withdrawal successful"
+	})
+}
+
+// balance reports the balance of the account. With "reconcile" as requisition data, it is also
+// checked against the account's ledger.
+func (b *Bank) balance(req Request) (Response, error) {
+	credential, denied, err := b.authenticate(req)
+	if err != nil || denied != "" {
+		return rejected(req, denied), err
+	}
+
+	tuple, err := b.space.Read(accountTemplate(req.BankAccount, credential))
+	if err != nil {
+		return Response{}, err
+	}
+	if !tuple.IsPresent() {
+		return rejected(req, msgNotFound), nil
+	}
+
+	balance, err := amountOf(tuple.Get().GetElements()[2])
+	if err != nil {
+		return Response{}, err
+	}
+	message := "Balance: " + balance.String()
+	if req.RequisitionData == "reconcile" {
+		report, err := b.reconcile(req.BankAccount, balance)
+		if err != nil {
+			return Response{}, err
+		}
+		message += ", " + report
+	}
+	return Response{BankAccount: req.BankAccount, Message: message}, nil
+}
+
+// transfer moves money to another account, given as "<to> <amount>". Both accounts are swapped
+// in the same transaction, which aborts and is retried if either changed after it was read.
+func (b *Bank) transfer(call rpc.CallInfo, req Request) (Response, error) {
+	args := strings.Fields(req.RequisitionData)
+	if len(args) != 2 {
+		return rejected(req, "Usage: transfer <to> <amount>"), nil
+	}
+	to := args[0]
+	amount, err := parsePositive(args[1])
+	if err != nil {
+		return rejected(req, invalidAmount(args[1], err)), nil
+	}
+	if to == req.BankAccount {
+		return rejected(req, "Cannot transfer to the same account"), nil
+	}
+
+	credential, denied, err := b.authenticate(req)
+	if err != nil || denied != "" {
+		return rejected(req, denied), err
+	}
+
+	for {
+		from, err := b.space.Read(accountTemplate(req.BankAccount, credential))
+		if err != nil {
+			return Response{}, err
+		}
+		if !from.IsPresent() {
+			return rejected(req, msgNotFound), nil
+		}
+
+		dest, err := b.space.Read(accountTemplate(to, ts.Any()))
+		if err != nil {
+			return Response{}, err
+		}
+		if !dest.IsPresent() {
+			return rejected(req, "Destination account not found"), nil
+		}
+
+		fromBalance, err := amountOf(from.Get().GetElements()[2])
+		if err != nil {
+			return Response{}, err
+		}
+		if fromBalance < amount {
+			return rejected(req, "Insufficient funds"), nil
+		}
+		destBalance, err := amountOf(dest.Get().GetElements()[2])
+		if err != nil {
+			return Response{}, err
+		}
+		destBalance, err = destBalance.Add(amount)
+		if err != nil {
+			return rejected(req, "Transfer rejected: destination balance would exceed the maximum"), nil
+		}
+		fromBalance -= amount
+		destCredential := dest.Get().GetElements()[1]
+
+		resp := Response{BankAccount: req.BankAccount, Message: "Transfer successful"}
+		_, err = call.Transact(b.space, resp,
+			store.GetOp(from.Get()),
+			store.GetOp(dest.Get()),
+			store.WriteOp(ts.MakeTuple(ts.S(req.BankAccount), credential, fromBalance.Elem()), ts.Forever),
+			store.WriteOp(ts.MakeTuple(ts.S(to), destCredential, destBalance.Elem()), ts.Forever),
+			entryOp(req.BankAccount, "transfer-out", -amount, fromBalance, call.CorrelationID),
+			entryOp(to, "transfer-in", amount, destBalance, call.CorrelationID),
+		)
+		if err == nil {
+			return resp, nil
+		}
+		if !errors.Is(err, store.ErrTxAborted) {
+			return Response{}, err
+		}
+		fmt.Printf("Accounts %s or %s changed concurrently, retrying: %v\n", req.BankAccount, to, err)
+	}
+}
+
+// Returns the response to a rejected requisition.
+func rejected(req Request, message string) Response {
+	return Response{BankAccount: req.BankAccount, Message: message, Failed: true}
+}
+
+// Returns the message of a requisition with an invalid amount.
+func invalidAmount(s string, err error) string {
+	if err == nil {
+		return fmt.Sprintf("Invalid amount %q: must not be negative", s)
+	}
+	return "Invalid amount " + err.Error()
+}
+
+// updateAccount replaces the balance of the account with the one computed by `update` and
+// records the change in the ledger, in a single transaction with the reply, so a crash or a
+// concurrent worker cannot lose the account, and a retried request does not apply it twice.
+// If the account changed after it was read, the transaction aborts and the update is retried.
+// Nothing is written if the balance stays the same.
+func (b *Bank) updateAccount(call rpc.CallInfo, req Request, kind string, update func(balance Amount) (Amount, string)) (Response, error) {
+	credential, denied, err := b.authenticate(req)
+	if err != nil || denied != "" {
+		return rejected(req, denied), err
+	}
+
+	for {
+		tuple, err := b.space.Read(accountTemplate(req.BankAccount, credential))
+		if err != nil {
+			return Response{}, err
+		}
+
+		if !tuple.IsPresent() {
+			return rejected(req, msgNotFound), nil
+		}
+
+		balance, err := amountOf(tuple.Get().GetElements()[2])
+		if err != nil {
+			return Response{}, err
+		}
+		updated, message := update(balance)
+		if updated == balance {
+			return rejected(req, message), nil
+		}
+
+		resp := Response{BankAccount: req.BankAccount, Message: message}
+		_, err = call.Transact(b.space, resp,
+			store.GetOp(tuple.Get()),
+			store.WriteOp(ts.MakeTuple(ts.S(req.BankAccount), credential, updated.Elem()), ts.Forever),
+			entryOp(req.BankAccount, kind, updated-balance, updated, call.CorrelationID),
+		)
+		if err == nil {
+			return resp, nil
+		}
+		if !errors.Is(err, store.ErrTxAborted) {
+			return Response{}, err
+		}
+		fmt.Printf("Account %s changed concurrently, retrying: %v\n", req.BankAccount, err)
+	}
+}
@@ -0,0 +1,298 @@
+package bank
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"tuplespaceCD/pkg/rpc"
+	ts "tuplespaceCD/pkg/tuplespace"
+	"tuplespaceCD/store"
+)
+
+// Opens a single node store, serves the bank on it and returns a client of the bank.
+func newTestBank(t *testing.T) *rpc.Client {
+	return serveBank(t, newTestSpace(t))
+}
+
+// Opens a single node store and waits until it is the leader.
+func newTestSpace(t *testing.T) *store.Store {
+	t.Helper()
+
+	space := store.New()
+	space.RaftDir = t.TempDir()
+	space.RaftBind = "127.0.0.1:0"
+	if err := space.Open(true, "node0"); err != nil {
+		t.Fatalf("opening the store: %v", err)
+	}
+	for deadline := time.Now().Add(10 * time.Second); !space.IsLeader(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the store did not become the leader")
+		}
+	}
+	return space
+}
+
+// Serves the bank on the space and returns a client of the bank.
+func serveBank(t *testing.T, space *store.Store) *rpc.Client {
+	srv := rpc.NewServer(space, Name)
+	srv.PollInterval = 10 * time.Millisecond
+	bank := New(space).(*Bank)
+	if err := bank.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	bank.Register(srv)
+	stop := make(chan struct{})
+	t.Cleanup(func() { close(stop) })
+	go srv.Serve(stop)
+
+	client := rpc.NewClient(space, Name)
+	client.PollInterval = 10 * time.Millisecond
+	return client
+}
+
+func call(t *testing.T, client *rpc.Client, req Request, opts ...rpc.CallOption) Response {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err := rpc.Call[Request, Response](ctx, client, req.Requisition, req, opts...)
+	if err != nil {
+		t.Fatalf("%s %s: %v", req.Requisition, req.BankAccount, err)
+	}
+	return resp
+}
+
+func TestRequisitions(t *testing.T) {
+	client := newTestBank(t)
+
+	// The steps run in order against the same bank.
+	steps := []struct {
+		name        string
+		account     string
+		password    string
+		requisition string
+		data        string
+		message     string
+		failed      bool
+	}{
+		{"create", "alice", "pw", "create", "", "Account created", false},
+		{"create twice", "alice", "pw", "create", "", "Account already exists", true},
+		{"create without password", "bob", "", "create", "", "A password is required", true},
+		{"create reserved", "SESSION", "pw", "create", "", "Reserved account name", true},
+		{"create with invalid amount", "bob", "pw", "create", "1.234", `Invalid amount "1.234": not a number with at most two decimals`, true},
+		{"create with negative amount", "bob", "pw", "create", "-1", `Invalid amount "-1": must not be negative`, true},
+		{"deposit", "alice", "pw", "deposit", "5", "Deposit successful", false},
+		{"deposit negative", "alice", "pw", "deposit", "-1", `Invalid amount "-1": amount must be positive`, true},
+		{"deposit with wrong password", "alice", "wrong", "deposit", "1", msgBadCredentials, true},
+		{"withdraw too much", "alice", "pw", "withdraw", "100", "Insufficient funds", true},
+		{"withdraw", "alice", "pw", "withdraw", "2", "Withdrawal successful", false},
+		{"balance", "alice", "pw", "balance", "", "Balance: 3.00", false},
+		{"balance of missing account", "bob", "pw", "balance", "", "Account not found", true},
+		{"delete", "alice", "pw", "delete", "", "Account deleted", false},
+		{"delete twice", "alice", "pw", "delete", "", "Account not found", true},
+	}
+
+	for _, step := range steps {
+		resp := call(t, client, Request{BankAccount: step.account, Password: step.password, Requisition: step.requisition, RequisitionData: step.data})
+		if resp.Message != step.message || resp.Failed != step.failed {
+			t.Errorf("%s: got %q (failed %v), want %q (failed %v)", step.name, resp.Message, resp.Failed, step.message, step.failed)
+		}
+	}
+}
+
+func TestConcurrentRequestsGetTheirOwnResponse(t *testing.T) {
+	client := newTestBank(t)
+	call(t, client, Request{BankAccount: "alice", Password: "pw", Requisition: "create"})
+
+	results := make(chan string, 3)
+	for _, account := range []string{"alice", "alice", "bob"} {
+		go func(account string) {
+			req := Request{BankAccount: account, Password: "pw", Requisition: "balance"}
+			resp, err := rpc.Call[Request, Response](context.Background(), client, "balance", req)
+			if err != nil {
+				results <- err.Error()
+				return
+			}
+			results <- account + ": " + resp.Message
+		}(account)
+	}
+	for i := 0; i < 3; i++ {
+		got := <-results
+		if !strings.HasPrefix(got, "alice: Balance: ") && got != "bob: Account not found" {
+			t.Errorf("got %s", got)
+		}
+	}
+}
+
+func TestConcurrentCreatesMakeOneAccount(t *testing.T) {
+	space := newTestSpace(t)
+	client := serveBank(t, space)
+	serveBank(t, space) // A second worker
+
+	const creates = 4
+	created := make(chan bool, creates)
+	for i := 0; i < creates; i++ {
+		go func(i int) {
+			req := Request{BankAccount: "alice", Password: fmt.Sprintf("pw%d", i), Requisition: "create", RequisitionData: "5"}
+			resp, err := rpc.Call[Request, Response](context.Background(), client, "create", req)
+			created <- err == nil && !resp.Failed
+		}(i)
+	}
+	n := 0
+	for i := 0; i < creates; i++ {
+		if <-created {
+			n++
+		}
+	}
+	if n != 1 {
+		t.Errorf("%d creates succeeded, want 1", n)
+	}
+	if accounts, _ := space.Count(accountTemplate("alice", ts.Any())); accounts != 1 {
+		t.Errorf("got %d accounts, want 1", accounts)
+	}
+}
+
+func TestRepeatedRequestIsAppliedOnce(t *testing.T) {
+	client := newTestBank(t)
+
+	call(t, client, Request{BankAccount: "alice", Password: "pw", Requisition: "create"})
+	deposit := Request{BankAccount: "alice", Password: "pw", Requisition: "deposit", RequisitionData: "1"}
+	for i := 0; i < 2; i++ {
+		if resp := call(t, client, deposit, rpc.WithRequestID("deposit-1")); resp.Message != "Deposit successful" {
+			t.Fatalf("deposit %d: got %q", i+1, resp.Message)
+		}
+	}
+
+	resp := call(t, client, Request{BankAccount: "alice", Password: "pw", Requisition: "balance"})
+	if resp.Message != "Balance: 1.00" {
+		t.Errorf("got %q, want the deposit applied once", resp.Message)
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	client := newTestBank(t)
+	call(t, client, Request{BankAccount: "alice", Password: "pw", Requisition: "create", RequisitionData: "10"})
+	call(t, client, Request{BankAccount: "bob", Password: "secret", Requisition: "create", RequisitionData: "0"})
+
+	tests := []struct {
+		data    string
+		message string
+	}{
+		{"bob", "Usage: transfer <to> <amount>"},
+		{"bob -1", `Invalid amount "-1": amount must be positive`},
+		{"alice 1", "Cannot transfer to the same account"},
+		{"carol 1", "Destination account not found"},
+		{"bob 11", "Insufficient funds"},
+		{"bob 4", "Transfer successful"},
+	}
+	for _, test := range tests {
+		resp := call(t, client, Request{BankAccount: "alice", Password: "pw", Requisition: "transfer", RequisitionData: test.data})
+		if resp.Message != test.message {
+			t.Errorf("transfer %s: got %q, want %q", test.data, resp.Message, test.message)
+		}
+	}
+
+	// Concurrent transfers in both directions neither lose nor create money
+	done := make(chan struct{})
+	for i := 0; i < 6; i++ {
+		go func(i int) {
+			defer func() { done <- struct{}{} }()
+			req := Request{BankAccount: "alice", Password: "pw", Requisition: "transfer", RequisitionData: "bob 1"}
+			if i%2 == 1 {
+				req = Request{BankAccount: "bob", Password: "secret", Requisition: "transfer", RequisitionData: "alice 1"}
+			}
+			rpc.Call[Request, Response](context.Background(), client, "transfer", req)
+		}(i)
+	}
+	for i := 0; i < 6; i++ {
+		<-done
+	}
+
+	alice := call(t, client, Request{BankAccount: "alice", Password: "pw", Requisition: "balance"})
+	bob := call(t, client, Request{BankAccount: "bob", Password: "secret", Requisition: "balance"})
+	if alice.Message != "Balance: 6.00" || bob.Message != "Balance: 4.00" {
+		t.Errorf("got %q and %q, want 6 and 4", alice.Message, bob.Message)
+	}
+}
+
+func TestLedger(t *testing.T) {
+	client := newTestBank(t)
+	call(t, client, Request{BankAccount: "alice", Password: "pw", Requisition: "create", RequisitionData: "10"})
+	call(t, client, Request{BankAccount: "bob", Password: "pw", Requisition: "create"})
+	call(t, client, Request{BankAccount: "alice", Password: "pw", Requisition: "deposit", RequisitionData: "5"})
+	call(t, client, Request{BankAccount: "alice", Password: "pw", Requisition: "withdraw", RequisitionData: "3"})
+	call(t, client, Request{BankAccount: "alice", Password: "pw", Requisition: "withdraw", RequisitionData: "100"})
+	call(t, client, Request{BankAccount: "alice", Password: "pw", Requisition: "transfer", RequisitionData: "bob 2"})
+
+	statement := call(t, client, Request{BankAccount: "alice", Password: "pw", Requisition: "statement"})
+	want := []struct {
+		kind            string
+		amount, balance Amount
+	}{{"open", 1000, 1000}, {"deposit", 500, 1500}, {"withdraw", -300, 1200}, {"transfer-out", -200, 1000}}
+	if statement.Message != "4 entries" || len(statement.Entries) != len(want) {
+		t.Fatalf("statement: got %q with %v", statement.Message, statement.Entries)
+	}
+	for i, entry := range statement.Entries {
+		if entry.Type != want[i].kind || entry.Amount != want[i].amount || entry.Balance != want[i].balance {
+			t.Errorf("entry %d: got %s, want %s %+d -> %d", i, entry, want[i].kind, want[i].amount, want[i].balance)
+		}
+		if i > 0 && entry.Time.Before(statement.Entries[i-1].Time) {
+			t.Errorf("entry %d is older than the one before", i)
+		}
+	}
+
+	tests := []struct {
+		account string
+		request string
+		data    string
+		message string
+	}{
+		{"alice", "balance", "reconcile", "Balance: 10.00, reconciled with 4 ledger entries"},
+		{"bob", "balance", "reconcile", "Balance: 2.00, reconciled with 2 ledger entries"},
+		{"alice", "statement", "2100-01-01", "0 entries"},
+		{"alice", "statement", "2000-01-01 2100-01-01", "4 entries"},
+		{"alice", "statement", "yesterday", "Invalid start: yesterday"},
+		{"alice", "statement", "2000-01-01 later", "Invalid end: later"},
+		{"alice", "statement", "a b c", "Usage: statement [from] [to]"},
+	}
+	for _, test := range tests {
+		resp := call(t, client, Request{BankAccount: test.account, Password: "pw", Requisition: test.request, RequisitionData: test.data})
+		if resp.Message != test.message {
+			t.Errorf("%s %s %s: got %q, want %q", test.account, test.request, test.data, resp.Message, test.message)
+		}
+	}
+}
+
+func TestReconcileMismatch(t *testing.T) {
+	space := newTestSpace(t)
+	client := serveBank(t, space)
+	call(t, client, Request{BankAccount: "carol", Password: "pw", Requisition: "create", RequisitionData: "5"})
+	if _, err := space.Transact(entryOp("carol", "deposit", 150, 650, "lost")); err != nil {
+		t.Fatalf("Transact: %v", err)
+	}
+
+	want := "Balance: 5.00, MISMATCH: ledger amounts add up to 6.50, last entry left 6.50"
+	if resp := call(t, client, Request{BankAccount: "carol", Password: "pw", Requisition: "balance", RequisitionData: "reconcile"}); resp.Message != want {
+		t.Errorf("got %q, want %q", resp.Message, want)
+	}
+}
+
+func TestStatementPages(t *testing.T) {
+	client := newTestBank(t)
+	call(t, client, Request{BankAccount: "alice", Password: "pw", Requisition: "create"})
+	for i := 0; i < 25; i++ {
+		call(t, client, Request{BankAccount: "alice", Password: "pw", Requisition: "deposit", RequisitionData: "1"})
+	}
+
+	first := call(t, client, Request{BankAccount: "alice", Password: "pw", Requisition: "statement"})
+	next := strings.TrimPrefix(first.Message, "20 entries, more: statement ")
+	if len(first.Entries) != statementPageSize || next == first.Message {
+		t.Fatalf("first page: got %q with %d entries", first.Message, len(first.Entries))
+	}
+	second := call(t, client, Request{BankAccount: "alice", Password: "pw", Requisition: "statement", RequisitionData: next})
+	if second.Message != "6 entries" || second.Entries[0].Balance != 2000 {
+		t.Errorf("second page: got %q starting with %v", second.Message, second.Entries)
+	}
+}
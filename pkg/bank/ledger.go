@@ -0,0 +1,165 @@
+package bank
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	ts "tuplespaceCD/pkg/tuplespace"
+	"tuplespaceCD/store"
+)
+
+// Every change of an account appends an immutable ledger entry
+//
+//	("LEDGER", account, time, type, amount, balance, correlationID)
+//
+// in the same transaction as the change. The time is assigned by the leader, so entries of an
+// account are ordered in the space.
+const ledgerTag = "LEDGER"
+
+// Number of entries returned per statement requisition
+const statementPageSize = 20
+
+// Entry is a ledger entry: an operation on an account and the balance it left.
+type Entry struct {
+	Time          time.Time
+	Type          string
+	Amount        Amount // Negative when money left the account
+	Balance       Amount
+	CorrelationID string
+}
+
+func (e Entry) String() string {
+	return fmt.Sprintf("%s %-12s %s -> %s (%s)", e.Time.UTC().Format(time.RFC3339Nano), e.Type, e.Amount, e.Balance, e.CorrelationID)
+}
+
+// Returns the transaction operation appending an entry to the ledger of the account.
+func entryOp(account, kind string, amount, balance Amount, correlationID string) store.Op {
+	entry := ts.MakeTuple(ts.S(ledgerTag), ts.S(account), ts.I(0), ts.S(kind), amount.Elem(), balance.Elem(), ts.S(correlationID))
+	return store.StampOp(entry, 2, ts.Forever)
+}
+
+func ledgerTemplate(account string) ts.Tuple {
+	return ts.MakeTuple(ts.S(ledgerTag), ts.S(account), ts.Any(), ts.Any(), ts.Any(), ts.Any(), ts.Any())
+}
+
+func parseEntry(tuple ts.Tuple) (Entry, error) {
+	elements := tuple.GetElements()
+	nanos, _ := elements[2].GetValue().(int)
+	kind, _ := elements[3].GetValue().(string)
+	correlationID, _ := elements[6].GetValue().(string)
+	amount, err := amountOf(elements[4])
+	if err != nil {
+		return Entry{}, fmt.Errorf("ledger entry %s: %w", tuple, err)
+	}
+	balance, err := amountOf(elements[5])
+	if err != nil {
+		return Entry{}, fmt.Errorf("ledger entry %s: %w", tuple, err)
+	}
+	return Entry{
+		Time:          time.Unix(0, int64(nanos)),
+		Type:          kind,
+		Amount:        amount,
+		Balance:       balance,
+		CorrelationID: correlationID,
+	}, nil
+}
+
+// Returns the ledger of the account, oldest entry first.
+func (b *Bank) ledger(account string) ([]Entry, error) {
+	tuples, _, err := b.space.Scan(ledgerTemplate(account), "", 0)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, len(tuples))
+	for i, tuple := range tuples {
+		if entries[i], err = parseEntry(tuple); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// Parses a statement bound, either a date or an RFC 3339 timestamp.
+func parseBound(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// statement lists the ledger of the account, optionally restricted to "[from] [to]". Both
+// bounds are inclusive. Long statements are paged: the message tells how to ask for the next
+// page.
+func (b *Bank) statement(req Request) (Response, error) {
+	_, denied, err := b.authenticate(req)
+	if err != nil || denied != "" {
+		return rejected(req, denied), err
+	}
+
+	var from, to time.Time
+	args := strings.Fields(req.RequisitionData)
+	if len(args) > 2 {
+		return rejected(req, "Usage: statement [from] [to]"), nil
+	}
+	if len(args) > 0 {
+		if from, err = parseBound(args[0]); err != nil {
+			return rejected(req, "Invalid start: "+args[0]), nil
+		}
+	}
+	if len(args) > 1 {
+		if to, err = parseBound(args[1]); err != nil {
+			return rejected(req, "Invalid end: "+args[1]), nil
+		}
+	}
+
+	entries, err := b.ledger(req.BankAccount)
+	if err != nil {
+		return Response{}, err
+	}
+
+	page := []Entry{}
+	message := ""
+	for _, entry := range entries {
+		if entry.Time.Before(from) || (!to.IsZero() && entry.Time.After(to)) {
+			continue
+		}
+		if len(page) == statementPageSize {
+			next := "statement " + entry.Time.UTC().Format(time.RFC3339Nano)
+			if len(args) > 1 {
+				next += " " + args[1]
+			}
+			message = fmt.Sprintf("%d entries, more: %s", len(page), next)
+			break
+		}
+		page = append(page, entry)
+	}
+	if message == "" {
+		message = fmt.Sprintf("%d entries", len(page))
+	}
+	return Response{BankAccount: req.BankAccount, Message: message, Entries: page}, nil
+}
+
+// reconcile checks the balance of the account against its ledger: the amounts of all entries
+// must add up to the balance, which must also be the balance left by the last entry.
+func (b *Bank) reconcile(account string, balance Amount) (string, error) {
+	entries, err := b.ledger(account)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 0 {
+		return "no ledger entries", nil
+	}
+
+	var sum Amount
+	for _, entry := range entries {
+		if sum, err = sum.Add(entry.Amount); err != nil {
+			return "", err
+		}
+	}
+	last := entries[len(entries)-1].Balance
+	if sum != balance || last != balance {
+		return fmt.Sprintf("MISMATCH: ledger amounts add up to %s, last entry left %s", sum, last), nil
+	}
+	return fmt.Sprintf("reconciled with %d ledger entries", len(entries)), nil
+}
@@ -0,0 +1,127 @@
+package bank
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	ts "tuplespaceCD/pkg/tuplespace"
+	"tuplespaceCD/store"
+)
+
+// Balances and ledger amounts used to be stored in whole units, as INT or, before balances
+// were typed, as STRING fields. They are converted to cents once, in the same transaction that
+// writes the marker
+//
+//	("BANK", "cents")
+//
+// so a bank with the marker only holds cents.
+//
+// Other applications may keep tuples of the same shape as accounts in the space, so only the
+// tuples known to be accounts are converted: those holding a credential the bank writes, see
+// `checkPassword`, and those with a ledger. Older accounts, with neither a hashed password nor
+// a ledger, cannot be told apart from other tuples; they are left alone and logged, to be
+// converted by hand.
+var centsMarker = ts.MakeTuple(ts.S("BANK"), ts.S("cents"))
+
+// Init converts the amounts of the accounts and ledger entries to cents, unless the space has
+// the marker already, before the workers of the bank take requests. It retries if they changed
+// concurrently. Amounts that cannot be converted are left alone, and fail the requisitions that
+// read them.
+func (b *Bank) Init() error {
+	for {
+		marker, err := b.space.Read(centsMarker)
+		if err != nil || marker.IsPresent() {
+			return err
+		}
+
+		ops, err := b.migrationOps()
+		if err != nil {
+			return err
+		}
+		_, err = b.space.Transact(append(ops, store.WriteOp(centsMarker, ts.Forever))...)
+		if err == nil {
+			fmt.Printf("Converted %d accounts and ledger entries to cents\n", len(ops)/2)
+			return nil
+		}
+		if !errors.Is(err, store.ErrTxAborted) {
+			return err
+		}
+		fmt.Printf("Accounts changed concurrently while converting them to cents, retrying: %v\n", err)
+	}
+}
+
+// Returns the operations replacing every account and ledger entry with one holding cents.
+func (b *Bank) migrationOps() ([]store.Op, error) {
+	var ops []store.Op
+
+	entries, _, err := b.space.Scan(ts.MakeTuple(ts.S(ledgerTag), ts.Any(), ts.Any(), ts.Any(), ts.Any(), ts.Any(), ts.Any()), "", 0)
+	if err != nil {
+		return nil, err
+	}
+	ledgers := make(map[string]bool)
+	for _, entry := range entries {
+		account, _ := entry.GetElements()[1].GetValue().(string)
+		ledgers[account] = true
+		converted, err := unitsToCents(entry, 4, 5)
+		if err != nil {
+			fmt.Printf("Leaving ledger entry as it is: %v\n", err)
+			continue
+		}
+		ops = append(ops, store.GetOp(entry), store.WriteOp(converted, ts.Forever))
+	}
+
+	accounts, _, err := b.space.Scan(ts.MakeTuple(ts.Formal(ts.STRING), ts.Formal(ts.STRING), ts.Any()), "", 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, account := range accounts {
+		if !isAccount(account, ledgers) {
+			continue
+		}
+		converted, err := unitsToCents(account, 2)
+		if err != nil {
+			fmt.Printf("Leaving account as it is: %v\n", err)
+			continue
+		}
+		ops = append(ops, store.GetOp(account), store.WriteOp(converted, ts.Forever))
+	}
+	return ops, nil
+}
+
+// Tells whether a (?string, ?string, _) tuple is an account, given the accounts with a ledger.
+func isAccount(tuple ts.Tuple, ledgers map[string]bool) bool {
+	elements := tuple.GetElements()
+	name := elements[0].GetValue().(string)
+	credential := elements[1].GetValue().(string)
+	switch {
+	case name == sessionTag || name == lockoutTag:
+		return false
+	case ledgers[name], strings.HasPrefix(credential, "$2"), strings.HasPrefix(credential, "sha256$"):
+		return true
+	}
+	fmt.Printf("Not converting %s, which is no account or one from before passwords were hashed\n", tuple)
+	return false
+}
+
+// Returns the tuple with the amounts at the indices converted from whole units to cents.
+func unitsToCents(tuple ts.Tuple, indices ...int) (ts.Tuple, error) {
+	elements := append([]ts.Elem(nil), tuple.GetElements()...)
+	for _, i := range indices {
+		var amount Amount
+		var err error
+		switch value := elements[i].GetValue().(type) {
+		case int:
+			amount, err = ParseAmount(fmt.Sprint(value))
+		case string:
+			amount, err = ParseAmount(value)
+		default:
+			err = fmt.Errorf("%s is no amount", elements[i])
+		}
+		if err != nil {
+			return tuple, fmt.Errorf("cannot convert %s to cents: %w", tuple, err)
+		}
+		elements[i] = amount.Elem()
+	}
+	return ts.MakeTuple(elements...), nil
+}
@@ -0,0 +1,92 @@
+package bank
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"tuplespaceCD/pkg/rpc"
+	ts "tuplespaceCD/pkg/tuplespace"
+)
+
+func TestUnitsToCents(t *testing.T) {
+	tests := []struct {
+		tuple ts.Tuple
+		want  string
+		err   bool
+	}{
+		{ts.MakeTuple(ts.S("alice"), ts.S("pw"), ts.I(12)), `("alice"|"pw"|1200)`, false},
+		{ts.MakeTuple(ts.S("alice"), ts.S("pw"), ts.S("12")), `("alice"|"pw"|1200)`, false},
+		{ts.MakeTuple(ts.S("alice"), ts.S("pw"), ts.S("1.5")), `("alice"|"pw"|150)`, false},
+		{ts.MakeTuple(ts.S("alice"), ts.S("pw"), ts.S("lots")), "", true},
+		{ts.MakeTuple(ts.S("alice"), ts.S("pw"), ts.F(1.5)), "", true},
+	}
+	for _, test := range tests {
+		got, err := unitsToCents(test.tuple, 2)
+		if (err != nil) != test.err {
+			t.Errorf("unitsToCents(%s): got error %v, want error %v", test.tuple, err, test.err)
+			continue
+		}
+		if test.err && got.String() != test.tuple.String() {
+			t.Errorf("unitsToCents(%s): got %s on error, want the tuple unchanged", test.tuple, got)
+		} else if !test.err && got.String() != test.want {
+			t.Errorf("unitsToCents(%s): got %s, want %s", test.tuple, got, test.want)
+		}
+	}
+}
+
+func TestMigrateConvertsLegacyAmountsOnce(t *testing.T) {
+	space := newTestSpace(t)
+	hashed, err := newCredential("pw")
+	if err != nil {
+		t.Fatalf("newCredential: %v", err)
+	}
+	sum := sha256.Sum256([]byte("salt" + "pw"))
+	salted := "sha256$1$salt$" + hex.EncodeToString(sum[:])
+	job := ts.MakeTuple(ts.S("job"), ts.S("x"), ts.I(12))
+	unknown := ts.MakeTuple(ts.S("dave"), ts.S("pw"), ts.I(7))
+	legacy := []ts.Tuple{
+		ts.MakeTuple(ts.S("alice"), ts.S("pw"), ts.I(12)),
+		ts.MakeTuple(ts.S(ledgerTag), ts.S("alice"), ts.I(1), ts.S("open"), ts.I(12), ts.I(12), ts.S("c1")),
+		ts.MakeTuple(ts.S("bob"), ts.S(salted), ts.S("3")),
+		ts.MakeTuple(ts.S("carol"), ts.S(hashed), ts.S("corrupt")),
+		job,
+		unknown,
+	}
+	if err := space.WriteMany(legacy, ts.Forever); err != nil {
+		t.Fatalf("WriteMany: %v", err)
+	}
+	client := serveBank(t, space)
+
+	if resp := call(t, client, Request{BankAccount: "alice", Password: "pw", Requisition: "balance"}); resp.Message != "Balance: 12.00" {
+		t.Errorf("alice: got %q", resp.Message)
+	}
+	if resp := call(t, client, Request{BankAccount: "bob", Password: "pw", Requisition: "balance"}); resp.Message != "Balance: 3.00" {
+		t.Errorf("bob: got %q", resp.Message)
+	}
+	_, err = rpc.Call[Request, Response](context.Background(), client, "balance", Request{BankAccount: "carol", Password: "pw", Requisition: "balance"})
+	if !errors.Is(err, rpc.ErrDeadLettered) {
+		t.Errorf("carol: got %v, want the unreadable balance reported", err)
+	}
+	statement := call(t, client, Request{BankAccount: "alice", Password: "pw", Requisition: "statement"})
+	if len(statement.Entries) != 1 || statement.Entries[0].Amount != 1200 {
+		t.Errorf("alice's ledger: got %+v, want the opening entry in cents", statement.Entries)
+	}
+	for _, tuple := range []ts.Tuple{job, unknown} {
+		if n, _ := space.Count(tuple); n != 1 {
+			t.Errorf("%s was converted, want it left alone", tuple)
+		}
+	}
+	if n, _ := space.Count(centsMarker); n != 1 {
+		t.Errorf("got %d markers, want 1", n)
+	}
+
+	// Amounts written after the conversion are cents already
+	call(t, client, Request{BankAccount: "erin", Password: "pw", Requisition: "create", RequisitionData: "5"})
+	restarted := serveBank(t, space)
+	if resp := call(t, restarted, Request{BankAccount: "erin", Password: "pw", Requisition: "balance"}); resp.Message != "Balance: 5.00" {
+		t.Errorf("erin after a restart: got %q, want the amount converted once", resp.Message)
+	}
+}
@@ -0,0 +1,130 @@
+package bank
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	ts "tuplespaceCD/pkg/tuplespace"
+)
+
+// Amount is an amount of money in cents. Balances and ledger amounts are stored as INT fields
+// holding cents, so arithmetic on them is exact.
+type Amount int64
+
+// Largest amount that fits into an INT field
+const maxAmount = Amount(math.MaxInt)
+
+var (
+	errInvalidAmount = errors.New("not a number with at most two decimals")
+	errOverflow      = errors.New("amount too large")
+)
+
+// ParseAmount parses a decimal amount with at most two decimals, such as "12", "12.5" or
+// "-0.25".
+func ParseAmount(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	digits := strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+
+	units, cents, hasCents := strings.Cut(digits, ".")
+	if units == "" || (hasCents && (cents == "" || len(cents) > 2)) || !isDigits(units) || !isDigits(cents) {
+		return 0, fmt.Errorf("%q: %w", s, errInvalidAmount)
+	}
+	for len(cents) < 2 {
+		cents += "0"
+	}
+
+	whole, err := strconv.ParseInt(units, 10, 64)
+	if err != nil || whole > int64(maxAmount/100) {
+		return 0, fmt.Errorf("%q: %w", s, errOverflow)
+	}
+	fraction, _ := strconv.ParseInt(cents, 10, 64)
+	amount, err := Amount(whole * 100).Add(Amount(fraction))
+	if err != nil {
+		return 0, fmt.Errorf("%q: %w", s, err)
+	}
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Add returns the sum of the amounts, or an error if it does not fit into an INT field.
+func (a Amount) Add(b Amount) (Amount, error) {
+	if (b > 0 && a > maxAmount-b) || (b < 0 && a < -maxAmount-b) {
+		return 0, errOverflow
+	}
+	return a + b, nil
+}
+
+// Sub returns the difference of the amounts, or an error if it does not fit into an INT field.
+func (a Amount) Sub(b Amount) (Amount, error) {
+	return a.Add(-b)
+}
+
+func (a Amount) String() string {
+	sign := ""
+	if a < 0 {
+		sign = "-"
+		a = -a
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, a/100, a%100)
+}
+
+// Elem returns the INT field that stores the amount.
+func (a Amount) Elem() ts.Elem {
+	return ts.I(int(a))
+}
+
+// Amounts are sent as decimal strings, so clients do not have to know about cents.
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	amount, err := ParseAmount(s)
+	if err != nil {
+		return err
+	}
+	*a = amount
+	return nil
+}
+
+// amountOf returns the amount stored in an INT field of cents. Amounts stored otherwise were
+// converted to cents, see `migrate`, unless they were corrupt.
+func amountOf(field ts.Elem) (Amount, error) {
+	cents, ok := field.GetValue().(int)
+	if !ok {
+		return 0, fmt.Errorf("%s is no amount in cents", field)
+	}
+	return Amount(cents), nil
+}
+
+// parsePositive parses the amount of a requisition, which must be greater than zero.
+func parsePositive(s string) (Amount, error) {
+	amount, err := ParseAmount(s)
+	if err != nil {
+		return 0, err
+	}
+	if amount <= 0 {
+		return 0, fmt.Errorf("%q: amount must be positive", s)
+	}
+	return amount, nil
+}
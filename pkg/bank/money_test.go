@@ -0,0 +1,82 @@
+package bank
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	ts "tuplespaceCD/pkg/tuplespace"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Amount
+		err  error
+	}{
+		{"12", 1200, nil},
+		{"12.5", 1250, nil},
+		{"12.05", 1205, nil},
+		{"-0.25", -25, nil},
+		{"+3", 300, nil},
+		{" 7 ", 700, nil},
+		{"0", 0, nil},
+		{"1.234", 0, errInvalidAmount},
+		{"1.", 0, errInvalidAmount},
+		{".5", 0, errInvalidAmount},
+		{"1e3", 0, errInvalidAmount},
+		{"1,5", 0, errInvalidAmount},
+		{"", 0, errInvalidAmount},
+		{"-", 0, errInvalidAmount},
+		{"99999999999999999999", 0, errOverflow},
+		{"92233720368547758.07", maxAmount, nil},
+		{"92233720368547758.08", 0, errOverflow},
+	}
+
+	for _, test := range tests {
+		got, err := ParseAmount(test.s)
+		if !errors.Is(err, test.err) || got != test.want {
+			t.Errorf("ParseAmount(%q): got %d, %v, want %d, %v", test.s, got, err, test.want, test.err)
+		}
+	}
+}
+
+func TestAmountArithmetic(t *testing.T) {
+	if sum, err := Amount(150).Add(-200); err != nil || sum != -50 || sum.String() != "-0.50" {
+		t.Errorf("Add: got %s, %v, want -0.50", sum, err)
+	}
+	if _, err := maxAmount.Add(1); !errors.Is(err, errOverflow) {
+		t.Errorf("Add beyond the maximum: got %v, want an overflow", err)
+	}
+	if _, err := (-maxAmount).Sub(1); !errors.Is(err, errOverflow) {
+		t.Errorf("Sub beyond the minimum: got %v, want an overflow", err)
+	}
+	if got := Amount(100001).String(); got != "1000.01" {
+		t.Errorf("String: got %s, want 1000.01", got)
+	}
+}
+
+func TestAmountJSON(t *testing.T) {
+	b, err := json.Marshal(Amount(1205))
+	if err != nil || string(b) != `"12.05"` {
+		t.Fatalf("Marshal: got %s, %v", b, err)
+	}
+	var a Amount
+	if err := json.Unmarshal(b, &a); err != nil || a != 1205 {
+		t.Errorf("Unmarshal: got %d, %v, want 1205", a, err)
+	}
+	if err := json.Unmarshal([]byte(`"1.234"`), &a); err == nil {
+		t.Error("Unmarshal of an invalid amount succeeded")
+	}
+}
+
+func TestAmountOf(t *testing.T) {
+	if got, err := amountOf(ts.I(1250)); err != nil || got != 1250 {
+		t.Errorf("amountOf(1250): got %d, %v", got, err)
+	}
+	for _, field := range []ts.Elem{ts.S("12"), ts.S(""), ts.F(1.5)} {
+		if got, err := amountOf(field); err == nil {
+			t.Errorf("amountOf(%s): got %d, want an error", field, got)
+		}
+	}
+}
@@ -0,0 +1,452 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	ts "tuplespaceCD/pkg/tuplespace"
+
+	opt "github.com/micutio/goptional"
+)
+
+// Client defaults
+const (
+	DefaultPoolSize     = 4
+	DefaultRetries      = 5
+	DefaultRetryDelay   = 100 * time.Millisecond
+	DefaultRetryTimeout = 30 * time.Second
+)
+
+var (
+	// ErrClosed is returned by the operations of a closed client.
+	ErrClosed = errors.New("client closed")
+	// ErrNotLeader is returned when the node a request was sent to is not the leader anymore.
+	ErrNotLeader = errors.New("not leader")
+	// ErrUnavailable is returned when no leader answered the request, after all retries.
+	ErrUnavailable = errors.New("cluster unavailable")
+)
+
+// ServerError is returned when the server answered, but rejected the operation.
+type ServerError struct {
+	Op      string
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Op, e.Message)
+}
+
+// Message of the server when no tuple matches
+const msgNoMatch = "No matching tuple"
+
+// Request is a request to the server: either a tuple operation, given by `Op`, or a requisition
+// of the bank.
+type Request struct {
+	BankAccount     string `json:",omitempty"`
+	Password        string `json:",omitempty"` // Only sent to create an account, log in or change the password
+	Token           string `json:",omitempty"` // Session token returned by login
+	Requisition     string `json:",omitempty"`
+	RequisitionData string `json:",omitempty"`
+	RequestID       string `json:",omitempty"` // Lets the server recognize a repeated request
+
+	Op      string        `json:",omitempty"` // "out", "in", "rd", "inp", "rdp", "count" or "scan"
+	Space   string        `json:",omitempty"` // Space of the operation, the default space if empty
+	Tuple   ts.Tuple      // Tuple or template of the operation
+	Lease   time.Duration `json:",omitempty"` // Lease of the tuple written by "out"
+	Timeout time.Duration `json:",omitempty"` // How long "in" and "rd" wait for a match, forever if zero
+	Cursor  string        `json:",omitempty"`
+	Limit   int           `json:",omitempty"`
+
+	AuthToken string `json:",omitempty"` // Token of the principal the request is made for
+	Principal string `json:",omitempty"` // Principal of an access control command
+	Rights    string `json:",omitempty"` // Comma-separated rights of a grant, e.g. "out,rd"
+	Tag       string `json:",omitempty"` // Tag pattern of a grant
+}
+
+// Response is the answer of the server to a request.
+type Response struct {
+	BankAccount   string
+	Message       string
+	Failed        bool   `json:",omitempty"` // Set when the request was rejected or could not be answered
+	NotLeader     bool   `json:",omitempty"` // Set when the node lost leadership while answering
+	CorrelationID string `json:",omitempty"`
+	Token         string `json:",omitempty"`
+
+	Entries []Entry `json:",omitempty"` // Ledger entries of a statement
+
+	Tuples []ts.Tuple `json:",omitempty"`
+	Count  int        `json:",omitempty"`
+	Cursor string     `json:",omitempty"`
+}
+
+// Entry is a ledger entry of a bank statement. Amounts are decimal strings.
+type Entry struct {
+	Time          time.Time
+	Type          string
+	Amount        string
+	Balance       string
+	CorrelationID string
+}
+
+// NewRequestID returns a random request id.
+func NewRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// A connection to the leader, on the port it assigned in the handshake of `probeNode`.
+type conn struct {
+	net.Conn
+	encoder *json.Encoder
+	decoder *json.Decoder
+}
+
+// Client sends requests to the leader of a cluster, which it finds from a list of seed nodes.
+// Connections are pooled and shared by concurrent callers.
+//
+// A request in flight when the connection drops or the leader changes is replayed: it is sent
+// again under the same request id, to the new leader once there is one, which answers with the
+// original result if the request was already applied. Replays stop once the retry budget, i.e.
+// `Retries` attempts or `RetryTimeout`, whichever comes first, is spent.
+type Client struct {
+	seeds []string
+
+	PoolSize         int           // Maximum number of open connections
+	Retries          int           // How often a failed request is sent again
+	RetryDelay       time.Duration // Wait before the first retry, doubled for every further one
+	RetryTimeout     time.Duration // How long after the first attempt a request may still be sent again
+	DiscoveryTimeout time.Duration // How long to look for the leader before giving up
+	Space            string        // Space of the tuple operations, the default space if empty
+	AuthToken        string        // Token of the principal of the requests, if access is controlled
+
+	// Called before a request is sent again, with the number of the attempt and the error
+	// of the previous one
+	OnRetry func(req Request, attempt int, err error)
+
+	mu     sync.Mutex
+	leader string        // Server endpoint of the last known leader
+	idle   []*conn       // Open connections that no request is using
+	slots  chan struct{} // One value per open or opening connection
+	closed bool
+}
+
+// New returns a client for the cluster with the given seed nodes, as "host:port" of their
+// server port. Connections are only opened when needed.
+func New(seeds ...string) *Client {
+	return &Client{
+		seeds:            seeds,
+		PoolSize:         DefaultPoolSize,
+		Retries:          DefaultRetries,
+		RetryDelay:       DefaultRetryDelay,
+		RetryTimeout:     DefaultRetryTimeout,
+		DiscoveryTimeout: DefaultDiscoveryTimeout,
+	}
+}
+
+// Close closes the idle connections. Requests sent after closing fail with `ErrClosed`.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	for _, cn := range c.idle {
+		cn.Close()
+	}
+	c.idle = nil
+	return nil
+}
+
+// Do sends the request to the leader and returns its response, even if the server rejected the
+// request. A request without id is given one first, so that it is safe to send it again.
+func (c *Client) Do(ctx context.Context, req Request) (Response, error) {
+	if req.RequestID == "" {
+		req.RequestID = NewRequestID()
+	}
+	if req.AuthToken == "" {
+		req.AuthToken = c.AuthToken
+	}
+
+	budget := time.Now().Add(c.RetryTimeout)
+	var err error
+	for attempt := 0; attempt <= c.Retries; attempt++ {
+		if attempt > 0 {
+			delay := c.RetryDelay << (attempt - 1)
+			if time.Now().Add(delay).After(budget) {
+				break
+			}
+			if err := sleep(ctx, delay); err != nil {
+				return Response{}, err
+			}
+			if c.OnRetry != nil {
+				c.OnRetry(req, attempt, err)
+			}
+		}
+
+		var resp Response
+		resp, err = c.send(ctx, req)
+		if err == nil {
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			return Response{}, ctx.Err()
+		}
+		// Discovery already retried until its deadline
+		if errors.Is(err, ErrClosed) || errors.Is(err, ErrUnavailable) {
+			return Response{}, err
+		}
+	}
+	return Response{}, fmt.Errorf("%w, gave up resending request %s: %s", ErrUnavailable, req.RequestID, err)
+}
+
+// Out writes the tuple, which expires after `lease`, or never if the lease is `ts.Forever`.
+func (c *Client) Out(ctx context.Context, tuple ts.Tuple, lease time.Duration) error {
+	_, err := c.op(ctx, Request{Op: "out", Tuple: tuple, Lease: lease})
+	return err
+}
+
+// In takes a tuple matching the template, waiting for one until the context is done.
+func (c *Client) In(ctx context.Context, template ts.Tuple) (ts.Tuple, error) {
+	return c.wait(ctx, "in", template)
+}
+
+// Rd reads a tuple matching the template, waiting for one until the context is done.
+func (c *Client) Rd(ctx context.Context, template ts.Tuple) (ts.Tuple, error) {
+	return c.wait(ctx, "rd", template)
+}
+
+// Inp takes a tuple matching the template, if there is one.
+func (c *Client) Inp(ctx context.Context, template ts.Tuple) (opt.Maybe[ts.Tuple], error) {
+	return c.lookup(ctx, "inp", template)
+}
+
+// Rdp reads a tuple matching the template, if there is one.
+func (c *Client) Rdp(ctx context.Context, template ts.Tuple) (opt.Maybe[ts.Tuple], error) {
+	return c.lookup(ctx, "rdp", template)
+}
+
+// Count returns the number of tuples matching the template.
+func (c *Client) Count(ctx context.Context, template ts.Tuple) (int, error) {
+	resp, err := c.op(ctx, Request{Op: "count", Tuple: template})
+	return resp.Count, err
+}
+
+// Scan returns up to `limit` tuples matching the template, starting after the cursor, and the
+// cursor of the next page, empty on the last one. A nil template matches every tuple.
+func (c *Client) Scan(ctx context.Context, template ts.Tuple, cursor string, limit int) ([]ts.Tuple, string, error) {
+	resp, err := c.op(ctx, Request{Op: "scan", Tuple: template, Cursor: cursor, Limit: limit})
+	return resp.Tuples, resp.Cursor, err
+}
+
+// Blocking lookups ask the server to give up this long before the context's deadline, so that
+// its answer arrives in time and a taken tuple is not lost.
+const deadlineMargin = 200 * time.Millisecond
+
+func (c *Client) wait(ctx context.Context, op string, template ts.Tuple) (ts.Tuple, error) {
+	req := Request{Op: op, Tuple: template}
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Timeout = time.Until(deadline) - deadlineMargin
+		if req.Timeout <= 0 {
+			// Not enough time left to wait, so only look once
+			req.Op += "p"
+			req.Timeout = 0
+		}
+	}
+
+	resp, err := c.op(ctx, req)
+	var serverErr *ServerError
+	if errors.As(err, &serverErr) && serverErr.Message == msgNoMatch {
+		return ts.Tuple{}, context.DeadlineExceeded
+	}
+	if err != nil {
+		return ts.Tuple{}, err
+	}
+	return resp.Tuples[0], nil
+}
+
+func (c *Client) lookup(ctx context.Context, op string, template ts.Tuple) (opt.Maybe[ts.Tuple], error) {
+	resp, err := c.op(ctx, Request{Op: op, Tuple: template})
+	var serverErr *ServerError
+	if errors.As(err, &serverErr) && serverErr.Message == msgNoMatch {
+		return opt.NewNothing[ts.Tuple](), nil
+	}
+	if err != nil {
+		return opt.NewNothing[ts.Tuple](), err
+	}
+	return opt.NewJust(resp.Tuples[0]), nil
+}
+
+// op sends a tuple operation and turns a rejection into a `ServerError`.
+func (c *Client) op(ctx context.Context, req Request) (Response, error) {
+	req.Space = c.Space
+	resp, err := c.Do(ctx, req)
+	if err != nil {
+		return resp, err
+	}
+	if resp.Failed {
+		return resp, &ServerError{Op: req.Op, Message: resp.Message}
+	}
+	if (req.Op != "out" && req.Op != "count" && req.Op != "scan") && len(resp.Tuples) == 0 {
+		return resp, &ServerError{Op: req.Op, Message: "response without tuple"}
+	}
+	return resp, nil
+}
+
+// send makes a single attempt at the request on a pooled connection.
+func (c *Client) send(ctx context.Context, req Request) (Response, error) {
+	cn, err := c.get(ctx)
+	if err != nil {
+		return Response{}, err
+	}
+
+	resp, err := roundTrip(ctx, cn, req)
+	if err == nil && resp.NotLeader {
+		err = fmt.Errorf("%w: %s", ErrNotLeader, resp.Message)
+	}
+	if err != nil {
+		// The connection may hold half a response, or lead to a former leader
+		cn.Close()
+		c.release(nil)
+		if ctx.Err() == nil {
+			c.forgetLeader()
+		}
+		return Response{}, err
+	}
+	c.release(cn)
+	return resp, nil
+}
+
+// roundTrip writes the request and reads the response. The connection is closed if the context
+// is done before.
+func roundTrip(ctx context.Context, cn *conn, req Request) (Response, error) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			cn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	var resp Response
+	if err := cn.encoder.Encode(req); err != nil {
+		return resp, err
+	}
+	err := cn.decoder.Decode(&resp)
+	return resp, err
+}
+
+// get returns an idle connection or opens a new one, waiting while `PoolSize` connections are
+// in use.
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if c.slots == nil {
+		size := c.PoolSize
+		if size < 1 {
+			size = 1
+		}
+		c.slots = make(chan struct{}, size)
+	}
+	if n := len(c.idle); n > 0 {
+		cn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return cn, nil
+	}
+	slots := c.slots
+	c.mu.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	// Another caller may have returned a connection in the meantime
+	c.mu.Lock()
+	if n := len(c.idle); n > 0 {
+		cn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		<-slots
+		return cn, nil
+	}
+	c.mu.Unlock()
+
+	cn, err := c.dial(ctx)
+	if err != nil {
+		<-slots
+		return nil, err
+	}
+	return cn, nil
+}
+
+// release puts the connection back into the pool, or frees its slot if it is nil.
+func (c *Client) release(cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cn == nil {
+		<-c.slots
+		return
+	}
+	if c.closed {
+		cn.Close()
+		<-c.slots
+		return
+	}
+	c.idle = append(c.idle, cn)
+}
+
+func (c *Client) forgetLeader() {
+	c.mu.Lock()
+	c.leader = ""
+	for _, cn := range c.idle {
+		cn.Close()
+		<-c.slots
+	}
+	c.idle = nil
+	c.mu.Unlock()
+}
+
+// dial connects to the leader, see `discover`. Unless the context ends earlier, discovery gives
+// up after `DiscoveryTimeout`.
+func (c *Client) dial(ctx context.Context) (*conn, error) {
+	c.mu.Lock()
+	cached := c.leader
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, c.DiscoveryTimeout)
+	defer cancel()
+	found, err := discover(ctx, c.seeds, cached)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.leader = found.endpoint
+	c.mu.Unlock()
+	return &conn{Conn: found.conn, encoder: json.NewEncoder(found.conn), decoder: json.NewDecoder(found.conn)}, nil
+}
+
+// Waits for the duration, unless the context is done before.
+func sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,178 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ts "tuplespaceCD/pkg/tuplespace"
+)
+
+// Returns a client of the fake leader that retries without delay.
+func newTestClient(t *testing.T, leader *fakeNode) *Client {
+	c := New(fmt.Sprintf("127.0.0.1:%d", leader.port))
+	c.RetryDelay = time.Millisecond
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestTupleOperations(t *testing.T) {
+	job := ts.MakeTuple(ts.S("job"), ts.I(1))
+	leader := newFakeLeader(t, func(req Request) (Response, bool) {
+		switch req.Op {
+		case "out":
+			return Response{Message: "Written", Tuples: []ts.Tuple{req.Tuple}}, true
+		case "count":
+			return Response{Message: "3 tuples", Count: 3}, true
+		case "inp":
+			return Response{Message: msgNoMatch, Failed: true}, true
+		case "rd":
+			if req.Timeout > 0 {
+				return Response{Message: msgNoMatch, Failed: true}, true
+			}
+			return Response{Message: "Found", Tuples: []ts.Tuple{job}}, true
+		}
+		return Response{Message: "Invalid operation!", Failed: true}, true
+	})
+	c := newTestClient(t, leader)
+	ctx := context.Background()
+
+	if err := c.Out(ctx, job, ts.Forever); err != nil {
+		t.Errorf("Out: %v", err)
+	}
+	if n, err := c.Count(ctx, job); err != nil || n != 3 {
+		t.Errorf("Count: got %d, %v, want 3", n, err)
+	}
+	if found, err := c.Inp(ctx, job); err != nil || found.IsPresent() {
+		t.Errorf("Inp: got %v, %v, want nothing", found, err)
+	}
+	if found, err := c.Rd(ctx, job); err != nil || found.String() != job.String() {
+		t.Errorf("Rd: got %s, %v", found, err)
+	}
+
+	// With a deadline, the server is asked to give up in time
+	deadline, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if _, err := c.Rd(deadline, job); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Rd with deadline: got %v, want the deadline exceeded", err)
+	}
+	var serverErr *ServerError
+	if _, _, err := c.Scan(ctx, job, "", 10); !errors.As(err, &serverErr) || serverErr.Message != "Invalid operation!" {
+		t.Errorf("Scan: got %v, want a ServerError", err)
+	}
+
+	received := leader.received()
+	if rd := received[len(received)-2]; rd.Op != "rd" || rd.Timeout <= 0 || rd.Timeout > time.Second-deadlineMargin {
+		t.Errorf("Rd with deadline sent %s with timeout %s", rd.Op, rd.Timeout)
+	}
+	for _, req := range received {
+		if req.RequestID == "" {
+			t.Errorf("%s was sent without request id", req.Op)
+		}
+	}
+}
+
+func TestRetriesKeepTheRequestID(t *testing.T) {
+	var attempts int32
+	leader := newFakeLeader(t, func(req Request) (Response, bool) {
+		switch atomic.AddInt32(&attempts, 1) {
+		case 1:
+			return Response{}, false // Connection dropped
+		case 2:
+			return Response{Message: "not leader", Failed: true, NotLeader: true}, true
+		}
+		return Response{Message: "3 tuples", Count: 3}, true
+	})
+	c := newTestClient(t, leader)
+
+	if n, err := c.Count(context.Background(), ts.MakeTuple()); err != nil || n != 3 {
+		t.Fatalf("Count: got %d, %v, want 3 after the retries", n, err)
+	}
+	received := leader.received()
+	if len(received) != 3 {
+		t.Fatalf("got %d attempts, want 3", len(received))
+	}
+	for _, req := range received[1:] {
+		if req.RequestID != received[0].RequestID {
+			t.Errorf("a retry was sent as %s, want the id %s of the first attempt", req.RequestID, received[0].RequestID)
+		}
+	}
+}
+
+func TestUnavailableAndClosed(t *testing.T) {
+	leader := newFakeLeader(t, func(req Request) (Response, bool) {
+		return Response{}, false
+	})
+	c := newTestClient(t, leader)
+	c.Retries = 2
+
+	if _, err := c.Do(context.Background(), Request{Op: "count"}); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("got %v, want the cluster unavailable", err)
+	}
+	if n := len(leader.received()); n != 3 {
+		t.Errorf("got %d attempts, want 3", n)
+	}
+
+	c.Close()
+	if _, err := c.Do(context.Background(), Request{Op: "count"}); !errors.Is(err, ErrClosed) {
+		t.Errorf("after Close: got %v, want ErrClosed", err)
+	}
+}
+
+func TestReplayOnNewLeaderAfterFailover(t *testing.T) {
+	var first, next *fakeNode
+	first = newFakeLeader(t, func(req Request) (Response, bool) {
+		// Leadership moves to the other node while the request is in flight
+		first.setLeader(false, "")
+		next.setLeader(true, "")
+		return Response{Message: "not leader: leadership lost", Failed: true, NotLeader: true}, true
+	})
+	next = newFakeNode(t, false, "", func(req Request) (Response, bool) {
+		return Response{Message: "Written", Tuples: []ts.Tuple{req.Tuple}}, true
+	})
+
+	c := New(first.endpoint, next.endpoint)
+	c.RetryDelay = time.Millisecond
+	defer c.Close()
+	var retries []error
+	c.OnRetry = func(req Request, attempt int, err error) {
+		retries = append(retries, err)
+	}
+
+	if err := c.Out(context.Background(), ts.MakeTuple(ts.S("job")), ts.Forever); err != nil {
+		t.Fatalf("Out: %v", err)
+	}
+	if len(retries) != 1 || !errors.Is(retries[0], ErrNotLeader) {
+		t.Errorf("got retries %v, want one after ErrNotLeader", retries)
+	}
+	sent, replayed := first.received(), next.received()
+	if len(sent) != 1 || len(replayed) != 1 || sent[0].RequestID != replayed[0].RequestID {
+		t.Errorf("got %v on the old leader and %v on the new one, want the same request replayed", sent, replayed)
+	}
+}
+
+func TestReplayStopsAtTheRetryTimeout(t *testing.T) {
+	leader := newFakeLeader(t, func(req Request) (Response, bool) {
+		return Response{}, false
+	})
+	c := newTestClient(t, leader)
+	c.Retries = 100
+	c.RetryDelay = 20 * time.Millisecond
+	c.RetryTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	_, err := c.Do(context.Background(), Request{Op: "count", RequestID: "r1"})
+	if !errors.Is(err, ErrUnavailable) || !strings.Contains(err.Error(), "r1") {
+		t.Errorf("got %v, want the cluster unavailable for request r1", err)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("gave up after %s, want it near the retry timeout", waited)
+	}
+	if n := len(leader.received()); n < 2 || n > 5 {
+		t.Errorf("got %d attempts within the retry timeout", n)
+	}
+}
@@ -0,0 +1,216 @@
+// Package client connects to the tuple space service.
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"time"
+)
+
+type JSONConnectionInfo struct {
+	MesType  string `json:"type"`
+	NodeAddr string `json:"addr"`
+	NodeID   string `json:"id"`
+}
+
+// Discovery defaults
+const (
+	DefaultDiscoveryTimeout = 10 * time.Second
+	probeTimeout            = 2 * time.Second // For a single node to answer the handshake
+	maxRedirects            = 3               // Redirects followed per round of probes
+	backoffBase             = 100 * time.Millisecond
+	backoffMax              = 2 * time.Second
+)
+
+// Dial connects to the leader of the cluster, starting from the node at the given address and
+// port, and returns the connection and the port the leader assigned to it.
+func Dial(address string, port uint16) (net.Conn, uint16, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultDiscoveryTimeout)
+	defer cancel()
+
+	found, err := discover(ctx, []string{net.JoinHostPort(address, strconv.Itoa(int(port)))}, "")
+	if err != nil {
+		return nil, 0, err
+	}
+	return found.conn, found.port, nil
+}
+
+// The outcome of the handshake with a node.
+type probe struct {
+	endpoint string   // Server endpoint of the node, as "host:port"
+	conn     net.Conn // Connection to the port assigned by the node, if it is the leader
+	port     uint16
+	redirect string // Server endpoint of the leader according to a follower, if it knows one
+	err      error
+}
+
+// discover finds the leader, trying the cached endpoint first and then probing all seeds in
+// parallel. Followers name the leader, and those redirects are followed up to `maxRedirects`
+// times per round. Rounds are repeated with jittered exponential backoff until the context is
+// done, in which case the error wraps `ErrUnavailable`.
+func discover(ctx context.Context, seeds []string, cached string) (probe, error) {
+	if cached != "" {
+		if found := probeNode(ctx, cached); found.conn != nil {
+			return found, nil
+		}
+	}
+	if len(seeds) == 0 {
+		return probe{}, fmt.Errorf("%w: no seed nodes", ErrUnavailable)
+	}
+
+	var lastErr error
+	for round := 0; ; round++ {
+		if round > 0 {
+			if err := sleep(ctx, jitteredBackoff(round-1)); err != nil {
+				break
+			}
+		}
+
+		endpoints := seeds
+		probed := make(map[string]bool)
+		for redirects := 0; len(endpoints) > 0 && redirects <= maxRedirects; redirects++ {
+			for _, endpoint := range endpoints {
+				probed[endpoint] = true
+			}
+
+			results := probeAll(ctx, endpoints)
+			var found probe
+			var next []string
+			for _, result := range results {
+				switch {
+				case result.conn != nil && found.conn == nil:
+					found = result
+				case result.conn != nil:
+					// Two nodes claim to be the leader; the stale one is not used
+					result.conn.Close()
+				case result.err != nil:
+					lastErr = result.err
+				case result.redirect != "":
+					if !probed[result.redirect] {
+						next = append(next, result.redirect)
+						probed[result.redirect] = true
+					} else {
+						lastErr = fmt.Errorf("%s redirected to the leader at %s, which was probed already", result.endpoint, result.redirect)
+					}
+				default:
+					lastErr = fmt.Errorf("%s knows no leader", result.endpoint)
+				}
+			}
+			if found.conn != nil {
+				return found, nil
+			}
+			endpoints = next
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	if lastErr == nil {
+		lastErr = ctx.Err()
+	}
+	return probe{}, fmt.Errorf("%w: no leader found among %v: %s", ErrUnavailable, seeds, lastErr)
+}
+
+// Probes the nodes at the same time and returns their results in order.
+func probeAll(ctx context.Context, endpoints []string) []probe {
+	results := make([]probe, len(endpoints))
+	done := make(chan struct{})
+	for i, endpoint := range endpoints {
+		go func(i int, endpoint string) {
+			results[i] = probeNode(ctx, endpoint)
+			done <- struct{}{}
+		}(i, endpoint)
+	}
+	for range endpoints {
+		<-done
+	}
+	return results
+}
+
+// probeNode asks the node at the server endpoint whether it is the leader. The leader assigns
+// the client a port, which the probe connects to.
+func probeNode(ctx context.Context, endpoint string) probe {
+	result := probe{endpoint: endpoint}
+	host, _, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		result.err = err
+		return result
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", endpoint)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	// Write to the server to inform a request
+	b, err := json.Marshal(JSONConnectionInfo{MesType: "request"})
+	if err != nil {
+		result.err = err
+		return result
+	}
+	if _, err := conn.Write(b); err != nil {
+		result.err = err
+		return result
+	}
+
+	var response struct {
+		Addr   string `json:"addr"`
+		Leader bool   `json:"leader"`
+	}
+	decoder := json.NewDecoder(conn)
+	if err := decoder.Decode(&response); err != nil {
+		result.err = fmt.Errorf("handshake with %s: %w", endpoint, err)
+		return result
+	}
+	if !response.Leader {
+		result.redirect = response.Addr
+		return result
+	}
+
+	// Read the new port from the server
+	var portBuf [2]byte
+	// The decoder may have buffered the port already, so read through it first, skipping the
+	// newline that ends the JSON response.
+	portReader := bufio.NewReader(io.MultiReader(decoder.Buffered(), conn))
+	if next, err := portReader.Peek(1); err == nil && next[0] == '\n' {
+		portReader.ReadByte()
+	}
+	if _, err := io.ReadFull(portReader, portBuf[:]); err != nil {
+		result.err = fmt.Errorf("reading the port assigned by %s: %w", endpoint, err)
+		return result
+	}
+	result.port = uint16(portBuf[1])<<8 | uint16(portBuf[0])
+
+	// Connect to the server on the new port
+	result.conn, err = dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(int(result.port))))
+	if err != nil {
+		result.err = err
+	}
+	return result
+}
+
+// Returns the wait before the next round of probes: exponential in the number of rounds so far,
+// capped, and randomized so that clients do not retry in lockstep.
+func jitteredBackoff(round int) time.Duration {
+	backoff := backoffMax
+	if round < 16 && backoffBase<<round < backoffMax {
+		backoff = backoffBase << round
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
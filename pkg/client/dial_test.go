@@ -0,0 +1,259 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A fake node. If it leads the cluster, every handshake on its server port is answered with the
+// port of a fresh listener, whose connection is served by `handle`; a handler that returns
+// false drops the connection without answering. A follower answers with the address of the
+// leader it knows, if any.
+type fakeNode struct {
+	port     uint16
+	endpoint string // Server endpoint, as "host:port"
+
+	mu         sync.Mutex
+	leader     bool
+	leaderAddr string // Address a follower redirects to
+	handshakes int
+	requests   []Request
+}
+
+func newFakeLeader(t *testing.T, handle func(req Request) (Response, bool)) *fakeNode {
+	return newFakeNode(t, true, "", handle)
+}
+
+func newFakeFollower(t *testing.T, leaderAddr string) *fakeNode {
+	return newFakeNode(t, false, leaderAddr, nil)
+}
+
+func newFakeNode(t *testing.T, leader bool, leaderAddr string, handle func(req Request) (Response, bool)) *fakeNode {
+	t.Helper()
+	server, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+	node := &fakeNode{port: uint16(server.Addr().(*net.TCPAddr).Port), endpoint: server.Addr().String()}
+	node.setLeader(leader, leaderAddr)
+
+	go func() {
+		for {
+			conn, err := server.Accept()
+			if err != nil {
+				return
+			}
+			node.mu.Lock()
+			node.handshakes++
+			leader, leaderAddr := node.leader, node.leaderAddr
+			node.mu.Unlock()
+
+			var info JSONConnectionInfo
+			json.NewDecoder(conn).Decode(&info)
+			json.NewEncoder(conn).Encode(map[string]interface{}{"addr": leaderAddr, "leader": leader})
+			if !leader {
+				conn.Close()
+				continue
+			}
+
+			clients, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				conn.Close()
+				return
+			}
+			port := clients.Addr().(*net.TCPAddr).Port
+			conn.Write([]byte{byte(port), byte(port >> 8)})
+			conn.Close()
+
+			go node.serve(clients, handle)
+		}
+	}()
+	return node
+}
+
+// Makes the node lead the cluster, or follow the leader at the given address.
+func (n *fakeNode) setLeader(leader bool, leaderAddr string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.leader = leader
+	n.leaderAddr = leaderAddr
+	if leader {
+		n.leaderAddr = n.endpoint
+	}
+}
+
+// Returns the number of handshakes so far.
+func (n *fakeNode) probes() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.handshakes
+}
+
+func (n *fakeNode) serve(clients net.Listener, handle func(req Request) (Response, bool)) {
+	defer clients.Close()
+	conn, err := clients.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	decoder := json.NewDecoder(conn)
+	for {
+		var req Request
+		if err := decoder.Decode(&req); err != nil {
+			return
+		}
+		n.mu.Lock()
+		n.requests = append(n.requests, req)
+		n.mu.Unlock()
+
+		resp, ok := handle(req)
+		if !ok {
+			return
+		}
+		json.NewEncoder(conn).Encode(resp)
+	}
+}
+
+// Returns the requests received so far.
+func (n *fakeNode) received() []Request {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]Request(nil), n.requests...)
+}
+
+func TestDial(t *testing.T) {
+	leader := newFakeLeader(t, func(req Request) (Response, bool) {
+		return Response{Message: "pong"}, true
+	})
+
+	conn, clientPort, err := Dial("127.0.0.1", leader.port)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	if want := uint16(conn.RemoteAddr().(*net.TCPAddr).Port); clientPort != want {
+		t.Errorf("got port %d, want %d", clientPort, want)
+	}
+
+	var resp Response
+	json.NewEncoder(conn).Encode(Request{Op: "ping"})
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil || resp.Message != "pong" {
+		t.Errorf("the connection does not reach the client port: %+v, %v", resp, err)
+	}
+}
+
+// Returns an endpoint that refuses connections.
+func deadEndpoint(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	listener.Close()
+	return listener.Addr().String()
+}
+
+func TestDiscoverProbesAllSeeds(t *testing.T) {
+	leader := newFakeLeader(t, nil)
+	follower := newFakeFollower(t, "")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	found, err := discover(ctx, []string{deadEndpoint(t), follower.endpoint, leader.endpoint}, "")
+	if err != nil {
+		t.Fatalf("discover: %v", err)
+	}
+	found.conn.Close()
+	if found.endpoint != leader.endpoint {
+		t.Errorf("got %s, want the leader at %s", found.endpoint, leader.endpoint)
+	}
+	if follower.probes() != 1 {
+		t.Errorf("the follower was probed %d times, want once", follower.probes())
+	}
+}
+
+func TestDiscoverTriesTheCachedLeaderFirst(t *testing.T) {
+	leader := newFakeLeader(t, nil)
+	seed := newFakeFollower(t, "")
+
+	found, err := discover(context.Background(), []string{seed.endpoint}, leader.endpoint)
+	if err != nil {
+		t.Fatalf("discover: %v", err)
+	}
+	found.conn.Close()
+	if found.endpoint != leader.endpoint || seed.probes() != 0 {
+		t.Errorf("got %s after probing the seed %d times, want the cached leader only", found.endpoint, seed.probes())
+	}
+
+	// A stale cached leader falls back to the seeds
+	if _, err := discover(context.Background(), []string{leader.endpoint}, deadEndpoint(t)); err != nil {
+		t.Errorf("discover with a stale cached leader: %v", err)
+	}
+}
+
+func TestDiscoverGivesUpWithoutLeader(t *testing.T) {
+	follower := newFakeFollower(t, "")
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := discover(ctx, []string{follower.endpoint, deadEndpoint(t)}, "")
+	if !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("got %v, want the cluster unavailable", err)
+	}
+	if waited := time.Since(start); waited > 2*time.Second {
+		t.Errorf("gave up after %s, want it to stop at the deadline", waited)
+	}
+	if follower.probes() < 2 {
+		t.Errorf("the follower was probed %d times, want several rounds", follower.probes())
+	}
+
+	if _, err := discover(ctx, nil, ""); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("without seeds: got %v, want the cluster unavailable", err)
+	}
+}
+
+func TestDiscoverFollowsRedirects(t *testing.T) {
+	leader := newFakeLeader(t, nil)
+	follower := newFakeFollower(t, leader.endpoint)
+
+	found, err := discover(context.Background(), []string{follower.endpoint}, "")
+	if err != nil {
+		t.Fatalf("discover: %v", err)
+	}
+	found.conn.Close()
+	if found.endpoint != leader.endpoint {
+		t.Errorf("got %s, want the leader %s named by the follower", found.endpoint, leader.endpoint)
+	}
+
+	// Followers naming each other are probed once per round
+	a := newFakeFollower(t, "")
+	b := newFakeFollower(t, a.endpoint)
+	a.setLeader(false, b.endpoint)
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if _, err := discover(ctx, []string{a.endpoint}, ""); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("got %v, want the cluster unavailable", err)
+	}
+	if a.probes() == 0 || b.probes() == 0 || a.probes() > b.probes()+1 {
+		t.Errorf("probed %d and %d times, want the redirect followed once per round", a.probes(), b.probes())
+	}
+}
+
+func TestJitteredBackoff(t *testing.T) {
+	for round := 0; round < 20; round++ {
+		want := backoffMax
+		if round < 16 && backoffBase<<round < backoffMax {
+			want = backoffBase << round
+		}
+		if got := jitteredBackoff(round); got < want/2 || got > want {
+			t.Errorf("round %d: got %s, want between %s and %s", round, got, want/2, want)
+		}
+	}
+}
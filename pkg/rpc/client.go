@@ -0,0 +1,180 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	ts "tuplespaceCD/pkg/tuplespace"
+	"tuplespaceCD/store"
+
+	opt "github.com/micutio/goptional"
+)
+
+// Client defaults
+const (
+	DefaultAttemptTimeout = 5 * time.Second
+	DefaultRetries        = 2
+)
+
+// Client calls the operations of a named service.
+type Client struct {
+	space   Space
+	service string
+
+	AttemptTimeout time.Duration // How long to wait for a reply before retrying
+	Retries        int           // How often an unanswered request is sent again
+	PollInterval   time.Duration // How long to wait before looking for the reply again, unless notified earlier
+}
+
+// NewClient returns a client for the service.
+func NewClient(space Space, service string) *Client {
+	return &Client{
+		space:          space,
+		service:        service,
+		AttemptTimeout: DefaultAttemptTimeout,
+		Retries:        DefaultRetries,
+		PollInterval:   DefaultPollInterval,
+	}
+}
+
+type callOptions struct {
+	correlationID string
+	requestID     string
+}
+
+// CallOption customizes a single call.
+type CallOption func(*callOptions)
+
+// WithCorrelationID makes the call use the given correlation id instead of a random one.
+func WithCorrelationID(id string) CallOption {
+	return func(o *callOptions) {
+		o.correlationID = id
+	}
+}
+
+// WithRequestID makes the call idempotent: repeating a call with the same request id, e.g.
+// after a failover, neither sends the request twice nor loses a reply that was already taken.
+// Unless set explicitly, the correlation id is derived from the request id.
+func WithRequestID(id string) CallOption {
+	return func(o *callOptions) {
+		o.requestID = id
+	}
+}
+
+// Call sends a request to operation `op` of the service and blocks until the reply arrives,
+// the context is done or all attempts timed out.
+//
+// When an attempt times out and the request is still waiting in the space, the caller takes it
+// back and sends it again, up to `Retries` times. A request a worker already took is never sent
+// twice; the caller keeps waiting for its reply instead. A request with a request id is left
+// waiting when the call times out, so that repeating the call gets its reply.
+func Call[Req, Resp any](ctx context.Context, c *Client, op string, req Req, opts ...CallOption) (Resp, error) {
+	var resp Resp
+
+	options := callOptions{}
+	for _, option := range opts {
+		option(&options)
+	}
+	if options.correlationID == "" {
+		if options.requestID != "" {
+			options.correlationID = "req-" + options.requestID
+		} else {
+			options.correlationID = newCorrelationID()
+		}
+	}
+	requestOpts := []store.Option{inSystemSpace}
+	replyOpts := []store.Option{inSystemSpace}
+	if options.requestID != "" {
+		requestOpts = append(requestOpts, store.RequestID(options.requestID+"/req"))
+		replyOpts = append(replyOpts, store.RequestID(options.requestID+"/res"))
+	}
+
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return resp, err
+	}
+	request := requestTuple(c.service, op, options.correlationID, string(payload), 0)
+	pending := mustMap(ts.Template(rpcRequest{Tag: requestTag, Service: c.service, Op: op, CorrelationID: options.correlationID}))
+
+	wake, registration, err := watch(c.space, replyTemplate(options.correlationID))
+	if err != nil {
+		return resp, err
+	}
+	defer registration.Cancel()
+
+	if err := c.space.Write(request, ts.Forever, requestOpts...); err != nil {
+		return resp, err
+	}
+
+	retries := 0
+	deadline := time.Now().Add(c.AttemptTimeout)
+	for {
+		reply, err := c.space.Get(replyTemplate(options.correlationID), replyOpts...)
+		if err != nil {
+			return resp, err
+		}
+		if reply.IsPresent() {
+			return decodeReply[Resp](c.service, op, reply.Get())
+		}
+
+		if time.Now().After(deadline) {
+			// Take the request back if no worker picked it up, so it is either resent or dropped.
+			// One with a request id is only looked at: it stays for a worker, since repeating the
+			// call would not send it again.
+			var unclaimed opt.Maybe[ts.Tuple]
+			if options.requestID != "" {
+				unclaimed, err = c.space.Read(pending, inSystemSpace)
+			} else {
+				unclaimed, err = c.space.Get(pending, inSystemSpace)
+			}
+			if err != nil {
+				return resp, err
+			}
+			if unclaimed.IsPresent() {
+				if retries >= c.Retries {
+					return resp, fmt.Errorf("%w: %s.%s after %d attempts", ErrTimeout, c.service, op, retries+1)
+				}
+				retries++
+				if options.requestID == "" {
+					// Resend the request as taken back, which counts the attempts that failed.
+					if err := c.space.Write(unclaimed.Get(), ts.Forever, inSystemSpace); err != nil {
+						return resp, err
+					}
+				}
+			}
+			deadline = time.Now().Add(c.AttemptTimeout)
+		}
+
+		select {
+		case <-ctx.Done():
+			// Do not leave a request behind that nobody waits for anymore. One with a request
+			// id stays, since repeating the call does not send it again but waits for its reply.
+			if options.requestID == "" {
+				c.space.Get(pending, inSystemSpace)
+			}
+			return resp, ctx.Err()
+		case <-wake:
+		case <-time.After(c.PollInterval):
+		}
+	}
+}
+
+func decodeReply[Resp any](service, op string, tuple ts.Tuple) (Resp, error) {
+	var resp Resp
+
+	var rep rpcReply
+	if err := ts.Unmarshal(tuple, &rep); err != nil {
+		return resp, err
+	}
+	switch rep.Status {
+	case statusOK:
+		err := json.Unmarshal([]byte(rep.Payload), &resp)
+		return resp, err
+	case statusDead:
+		return resp, fmt.Errorf("%w: %s.%s: %s", ErrDeadLettered, service, op, rep.Payload)
+	default:
+		return resp, &RemoteError{Service: service, Op: op, Message: rep.Payload}
+	}
+}
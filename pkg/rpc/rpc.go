@@ -0,0 +1,155 @@
+// Package rpc implements request/reply calls over the replicated tuple space.
+//
+// A call writes a request tuple
+//
+//	("RPC", service, op, correlationID, payload, attempt)
+//
+// which is taken by one of the workers of the service. The worker runs the handler registered
+// for `op` and answers with a reply tuple
+//
+//	("RPL", correlationID, status, payload)
+//
+// that the caller takes. Payloads are the JSON encoding of the typed request and response
+// structs. Requests whose handler keeps failing are moved to the dead letter queue
+//
+//	("DLQ", service, op, correlationID, payload, error)
+//
+// so they can be inspected later.
+//
+// The tuples of the framework are kept in `store.SystemSpace`, out of reach of the clients of
+// the tuple space, since requests may carry secrets such as passwords.
+package rpc
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	ts "tuplespaceCD/pkg/tuplespace"
+	"tuplespaceCD/store"
+
+	opt "github.com/micutio/goptional"
+)
+
+// Space is the part of the replicated store used by the framework. It is implemented by
+// `*store.Store`.
+type Space interface {
+	Write(tuple ts.Tuple, lease time.Duration, opts ...store.Option) error
+	Get(query ts.Tuple, opts ...store.Option) (opt.Maybe[ts.Tuple], error)
+	Read(query ts.Tuple, opts ...store.Option) (opt.Maybe[ts.Tuple], error)
+	Notify(template ts.Tuple, lease time.Duration, handler ts.Handler, opts ...store.Option) (*ts.Registration, error)
+}
+
+// The option of every command of the framework
+var inSystemSpace = store.InSpace(store.SystemSpace)
+
+// Registers a notification for tuples matching the template. The returned channel receives a
+// value whenever there may be something to take, so waiting for it replaces busy polling.
+func watch(space Space, template ts.Tuple) (<-chan struct{}, *ts.Registration, error) {
+	wake := make(chan struct{}, 1)
+	registration, err := space.Notify(template, ts.Forever, func(event ts.Event) {
+		if event.Kind != ts.WRITTEN {
+			return
+		}
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}, inSystemSpace)
+	return wake, registration, err
+}
+
+// Tags of the tuples used by the framework
+const (
+	requestTag    = "RPC"
+	replyTag      = "RPL"
+	deadLetterTag = "DLQ"
+)
+
+// Reply status values
+const (
+	statusOK    = "ok"
+	statusError = "error"
+	statusDead  = "dead"
+)
+
+var (
+	// ErrTimeout is returned when no reply arrived before the deadline.
+	ErrTimeout = errors.New("rpc: timed out waiting for reply")
+	// ErrDeadLettered is returned when the request failed on every attempt and was moved to
+	// the dead letter queue.
+	ErrDeadLettered = errors.New("rpc: request dead-lettered")
+)
+
+// RemoteError is returned when the service answered the call with an error.
+type RemoteError struct {
+	Service string
+	Op      string
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("rpc: %s.%s: %s", e.Service, e.Op, e.Message)
+}
+
+func newCorrelationID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// The tuples of the framework, see the package documentation
+type rpcRequest struct {
+	Tag           string `tuple:"0"`
+	Service       string `tuple:"1"`
+	Op            string `tuple:"2"`
+	CorrelationID string `tuple:"3"`
+	Payload       string `tuple:"4"`
+	Attempt       int    `tuple:"5"`
+}
+
+type rpcReply struct {
+	Tag           string `tuple:"0"`
+	CorrelationID string `tuple:"1"`
+	Status        string `tuple:"2"`
+	Payload       string `tuple:"3"`
+}
+
+type rpcDeadLetter struct {
+	Tag           string `tuple:"0"`
+	Service       string `tuple:"1"`
+	Op            string `tuple:"2"`
+	CorrelationID string `tuple:"3"`
+	Payload       string `tuple:"4"`
+	Reason        string `tuple:"5"`
+}
+
+// Returns the tuple of a struct above, which always maps.
+func mustMap(tuple ts.Tuple, err error) ts.Tuple {
+	if err != nil {
+		panic(err)
+	}
+	return tuple
+}
+
+func requestTemplate(service string) ts.Tuple {
+	return mustMap(ts.Template(rpcRequest{Tag: requestTag, Service: service}))
+}
+
+func requestTuple(service, op, correlationID, payload string, attempt int) ts.Tuple {
+	return mustMap(ts.Marshal(rpcRequest{Tag: requestTag, Service: service, Op: op, CorrelationID: correlationID, Payload: payload, Attempt: attempt}))
+}
+
+func replyTemplate(correlationID string) ts.Tuple {
+	return mustMap(ts.Template(rpcReply{Tag: replyTag, CorrelationID: correlationID}))
+}
+
+func replyTuple(correlationID, status, payload string) ts.Tuple {
+	return mustMap(ts.Marshal(rpcReply{Tag: replyTag, CorrelationID: correlationID, Status: status, Payload: payload}))
+}
+
+func deadLetterTuple(service, op, correlationID, payload, reason string) ts.Tuple {
+	return mustMap(ts.Marshal(rpcDeadLetter{Tag: deadLetterTag, Service: service, Op: op, CorrelationID: correlationID, Payload: payload, Reason: reason}))
+}
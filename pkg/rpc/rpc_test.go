@@ -0,0 +1,259 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ts "tuplespaceCD/pkg/tuplespace"
+	"tuplespaceCD/store"
+)
+
+func newTestStore(t *testing.T) *store.Store {
+	t.Helper()
+	s := store.New()
+	s.RaftDir = t.TempDir()
+	s.RaftBind = "127.0.0.1:0"
+	if err := s.Open(true, "node0"); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for i := 0; !s.IsLeader(); i++ {
+		if i == 100 {
+			t.Fatal("no leader")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return s
+}
+
+// Starts a server for the "test" service and returns a client for it.
+func serve(t *testing.T, space *store.Store, setup func(srv *Server)) *Client {
+	srv := NewServer(space, "test")
+	srv.PollInterval = 10 * time.Millisecond
+	setup(srv)
+	stop := make(chan struct{})
+	t.Cleanup(func() { close(stop) })
+	go srv.Serve(stop)
+
+	c := NewClient(space, "test")
+	c.PollInterval = 10 * time.Millisecond
+	return c
+}
+
+// Counts the tuples matching the template.
+func count(t *testing.T, space *store.Store, template ts.Tuple, opts ...store.Option) int {
+	t.Helper()
+	n, err := space.Count(template, opts...)
+	if err != nil {
+		t.Fatalf("Count: %v", err)
+	}
+	return n
+}
+
+func TestCall(t *testing.T) {
+	space := newTestStore(t)
+	c := serve(t, space, func(srv *Server) {
+		Handle(srv, "upper", func(s string) (string, error) { return strings.ToUpper(s), nil })
+	})
+
+	got, err := Call[string, string](context.Background(), c, "upper", "hello")
+	if err != nil || got != "HELLO" {
+		t.Errorf("upper: got %q, %v, want HELLO", got, err)
+	}
+
+	var remoteErr *RemoteError
+	if _, err := Call[string, string](context.Background(), c, "lower", "hello"); !errors.As(err, &remoteErr) {
+		t.Errorf("unknown operation: got %v, want a RemoteError", err)
+	}
+	if n := count(t, space, ts.MakeTuple(ts.S(replyTag), ts.Any(), ts.Any(), ts.Any()), inSystemSpace); n != 0 {
+		t.Errorf("%d replies left behind", n)
+	}
+}
+
+func TestFailingRequestsAreRetriedAndDeadLettered(t *testing.T) {
+	space := newTestStore(t)
+	var flakyCalls int32
+	c := serve(t, space, func(srv *Server) {
+		Handle(srv, "fail", func(s string) (string, error) { return "", fmt.Errorf("always fails") })
+		Handle(srv, "panic", func(s string) (string, error) { panic("boom") })
+		Handle(srv, "flaky", func(s string) (string, error) {
+			if atomic.AddInt32(&flakyCalls, 1) == 1 {
+				return "", fmt.Errorf("first attempt fails")
+			}
+			return s, nil
+		})
+	})
+
+	for _, op := range []string{"fail", "panic"} {
+		if _, err := Call[string, string](context.Background(), c, op, "x"); !errors.Is(err, ErrDeadLettered) {
+			t.Errorf("%s: got %v, want it dead-lettered", op, err)
+		}
+	}
+	dead := ts.MakeTuple(ts.S(deadLetterTag), ts.S("test"), ts.Any(), ts.Any(), ts.Any(), ts.Any())
+	if n := count(t, space, dead, inSystemSpace); n != 2 {
+		t.Errorf("got %d dead letters, want 2", n)
+	}
+
+	if got, err := Call[string, string](context.Background(), c, "flaky", "x"); err != nil || got != "x" {
+		t.Errorf("flaky: got %q, %v, want the second attempt to succeed", got, err)
+	}
+	if n := atomic.LoadInt32(&flakyCalls); n != 2 {
+		t.Errorf("flaky handler called %d times, want 2", n)
+	}
+}
+
+func TestCallTimesOut(t *testing.T) {
+	space := newTestStore(t)
+	c := NewClient(space, "test")
+	c.AttemptTimeout = 50 * time.Millisecond
+	c.PollInterval = 10 * time.Millisecond
+
+	var sent int32
+	space.Notify(requestTemplate("test"), ts.Forever, func(e ts.Event) {
+		if e.Kind == ts.WRITTEN {
+			atomic.AddInt32(&sent, 1)
+		}
+	}, inSystemSpace)
+
+	if _, err := Call[string, string](context.Background(), c, "upper", "x"); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("got %v, want a timeout", err)
+	}
+	time.Sleep(50 * time.Millisecond) // Let the notifications arrive
+	if n := atomic.LoadInt32(&sent); n != int32(c.Retries+1) {
+		t.Errorf("request sent %d times, want %d", n, c.Retries+1)
+	}
+	if n := count(t, space, requestTemplate("test"), inSystemSpace); n != 0 {
+		t.Errorf("%d requests left behind after the timeout", n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	if _, err := Call[string, string](ctx, c, "upper", "x"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the context error", err)
+	}
+	if n := count(t, space, requestTemplate("test"), inSystemSpace); n != 0 {
+		t.Errorf("%d requests left behind after the context was done", n)
+	}
+}
+
+func TestRetriedCallsWithRequestID(t *testing.T) {
+	space := newTestStore(t)
+	var calls int32
+	c := serve(t, space, func(srv *Server) {
+		Handle(srv, "next", func(s string) (int32, error) { return atomic.AddInt32(&calls, 1), nil })
+	})
+
+	first, err := Call[string, int32](context.Background(), c, "next", "", WithRequestID("r1"))
+	if err != nil {
+		t.Fatalf("Call: %v", err)
+	}
+	retried, err := Call[string, int32](context.Background(), c, "next", "", WithRequestID("r1"))
+	if err != nil || retried != first {
+		t.Errorf("retried call: got %d, %v, want the original reply %d", retried, err, first)
+	}
+	if other, _ := Call[string, int32](context.Background(), c, "next", "", WithRequestID("r2")); other == first {
+		t.Error("a call with another request id got the same reply")
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("handler called %d times, want 2", n)
+	}
+}
+
+func TestTransactionsOfRetriedCallsAreAppliedOnce(t *testing.T) {
+	space := newTestStore(t)
+	var calls int32
+	c := serve(t, space, func(srv *Server) {
+		HandleCall(srv, "deposit", func(call CallInfo, s string) (string, error) {
+			if _, err := call.Transact(space, "done", store.WriteOp(ts.MakeTuple(ts.S("deposit"), ts.S(s)), ts.Forever)); err != nil {
+				return "", err
+			}
+			if atomic.AddInt32(&calls, 1) == 1 {
+				return "", fmt.Errorf("fails after the transaction was committed")
+			}
+			return "not sent, the transaction replied", nil
+		})
+	})
+
+	got, err := Call[string, string](context.Background(), c, "deposit", "x")
+	if err != nil || got != "done" {
+		t.Errorf("got %q, %v, want the reply written by the transaction", got, err)
+	}
+	if n := count(t, space, ts.MakeTuple(ts.S("deposit"), ts.Any())); n != 1 {
+		t.Errorf("the transaction was applied %d times, want once", n)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("handler called %d times, want once: the reply of the first attempt answers the retry", n)
+	}
+}
+
+func TestCallWithRequestIDLeavesTheRequestOnTimeout(t *testing.T) {
+	space := newTestStore(t)
+	c := NewClient(space, "test")
+	c.PollInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	if _, err := Call[string, string](ctx, c, "upper", "x", WithRequestID("r1")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want the context error", err)
+	}
+	if n := count(t, space, requestTemplate("test"), inSystemSpace); n != 1 {
+		t.Fatalf("got %d requests, want the request left for a worker", n)
+	}
+
+	// Running out of attempts leaves it as well, so it is not lost to the deduplicated write
+	c.AttemptTimeout = 20 * time.Millisecond
+	c.Retries = 1
+	if _, err := Call[string, string](context.Background(), c, "upper", "x", WithRequestID("r1")); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("got %v, want a timeout", err)
+	}
+	if n := count(t, space, requestTemplate("test"), inSystemSpace); n != 1 {
+		t.Fatalf("got %d requests after the attempts timed out, want the request left for a worker", n)
+	}
+
+	// Clients of the tuple space cannot see it, since requests may hold secrets
+	if n := count(t, space, requestTemplate("test")); n != 0 {
+		t.Errorf("got %d requests in the default space, want 0", n)
+	}
+	if _, err := space.Read(requestTemplate("test"), inSystemSpace, store.As(store.Anonymous)); !errors.Is(err, store.ErrPermissionDenied) {
+		t.Errorf("Read by a client: got %v, want permission denied", err)
+	}
+
+	// A worker that comes up later answers it, and the repeated call gets the reply
+	serve(t, space, func(srv *Server) {
+		Handle(srv, "upper", func(s string) (string, error) { return strings.ToUpper(s), nil })
+	})
+	if got, err := Call[string, string](context.Background(), c, "upper", "x", WithRequestID("r1")); err != nil || got != "X" {
+		t.Errorf("repeated call: got %q, %v, want X", got, err)
+	}
+}
+
+type secretRequest struct {
+	User     string
+	Password string
+}
+
+func (req *secretRequest) Redact() {
+	req.Password = ""
+}
+
+func TestDeadLettersAreRedacted(t *testing.T) {
+	space := newTestStore(t)
+	c := serve(t, space, func(srv *Server) {
+		Handle(srv, "login", func(req secretRequest) (string, error) { return "", fmt.Errorf("always fails") })
+	})
+
+	if _, err := Call[secretRequest, string](context.Background(), c, "login", secretRequest{User: "alice", Password: "hunter2"}); !errors.Is(err, ErrDeadLettered) {
+		t.Fatalf("got %v, want it dead-lettered", err)
+	}
+	dead, err := space.Read(ts.MakeTuple(ts.S(deadLetterTag), ts.S("test"), ts.Any(), ts.Any(), ts.Any(), ts.Any()), inSystemSpace)
+	if err != nil || !dead.IsPresent() {
+		t.Fatalf("Read: got %v, %v, want the dead letter", dead, err)
+	}
+	if letter := dead.Get().String(); strings.Contains(letter, "hunter2") || !strings.Contains(letter, "alice") {
+		t.Errorf("dead letter %s holds the password or lost the request", letter)
+	}
+}
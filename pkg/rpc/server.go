@@ -0,0 +1,241 @@
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	ts "tuplespaceCD/pkg/tuplespace"
+	"tuplespaceCD/store"
+
+	opt "github.com/micutio/goptional"
+)
+
+// Server defaults
+const (
+	DefaultMaxAttempts  = 3
+	DefaultPollInterval = 1 * time.Second
+	DefaultReplyLease   = 1 * time.Minute
+)
+
+// CallInfo describes the request a handler is answering.
+type CallInfo struct {
+	Service       string
+	Op            string
+	CorrelationID string
+	Attempt       int // Number of earlier attempts that failed
+
+	state *callState
+}
+
+// What the worker needs to know about the transactions of a call.
+type callState struct {
+	replyLease time.Duration
+	txs        int  // Number of transactions run so far
+	replied    bool // Set once a transaction wrote the reply
+}
+
+// Transactor runs transactions with options. It is implemented by `*store.Store`.
+type Transactor interface {
+	With(opts ...store.Option) store.Scope
+}
+
+// Transact runs the operations in a single transaction that also writes `resp` as the reply,
+// so the reply is there if and only if the operations took effect, and the worker does not
+// write it again. The transaction carries a request id derived from the correlation id: if
+// the request is tried again after a failure, even one reported after the transaction was
+// committed, the operations are not applied a second time. A handler that retries aborted
+// transactions gets a new id for each of them, in the same order on every attempt.
+func (call CallInfo) Transact(space Transactor, resp any, ops ...store.Op) ([]opt.Maybe[ts.Tuple], error) {
+	payload, err := json.Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	call.state.txs++
+	id := fmt.Sprintf("%s/tx%d", call.CorrelationID, call.state.txs)
+	ops = append(ops, store.WriteOp(replyTuple(call.CorrelationID, statusOK, string(payload)), call.state.replyLease).In(store.SystemSpace))
+	results, err := space.With(store.RequestID(id)).Transact(ops...)
+	if err != nil {
+		return nil, err
+	}
+	call.state.replied = true
+	return results[:len(results)-1], nil
+}
+
+// Takes the JSON payload of a request and returns the JSON payload of its response.
+type handlerFunc func(call CallInfo, payload string) (string, error)
+
+// Redacter is implemented by requests that hold secrets, such as passwords. `Redact` clears
+// them, and is called before the request is dead-lettered, so the dead letter queue keeps none.
+type Redacter interface {
+	Redact()
+}
+
+// Server runs the handlers of a named service.
+type Server struct {
+	space    Space
+	service  string
+	handlers map[string]handlerFunc
+	redact   map[string]func(payload string) string // Clears the secrets of the requests by op
+
+	MaxAttempts  int           // How often a failing request is tried before it is dead-lettered
+	PollInterval time.Duration // How long idle workers wait before looking for requests again, unless notified earlier
+	ReplyLease   time.Duration // Replies nobody takes expire after this lease
+
+	logger *log.Logger
+}
+
+// NewServer returns a server for the service, without any handlers.
+func NewServer(space Space, service string) *Server {
+	return &Server{
+		space:        space,
+		service:      service,
+		handlers:     make(map[string]handlerFunc),
+		redact:       make(map[string]func(payload string) string),
+		MaxAttempts:  DefaultMaxAttempts,
+		PollInterval: DefaultPollInterval,
+		ReplyLease:   DefaultReplyLease,
+		logger:       log.New(os.Stderr, fmt.Sprintf("[rpc %s] ", service), log.LstdFlags),
+	}
+}
+
+// Handle registers the handler of operation `op`. An error returned by the handler counts as
+// a failure: the request is tried again and dead-lettered once `MaxAttempts` is reached.
+// Outcomes the caller should see, such as a rejected operation, belong in the response.
+// Handlers that change the space should do so with `CallInfo.Transact`, so that a request
+// tried again does not apply the changes twice.
+func Handle[Req, Resp any](srv *Server, op string, handler func(Req) (Resp, error)) {
+	HandleCall(srv, op, func(_ CallInfo, req Req) (Resp, error) {
+		return handler(req)
+	})
+}
+
+// HandleCall is like `Handle` for handlers that need to know about the call, e.g. to record
+// its correlation id.
+func HandleCall[Req, Resp any](srv *Server, op string, handler func(CallInfo, Req) (Resp, error)) {
+	srv.handlers[op] = func(call CallInfo, payload string) (string, error) {
+		var req Req
+		if err := json.Unmarshal([]byte(payload), &req); err != nil {
+			return "", fmt.Errorf("decoding request: %s", err)
+		}
+		resp, err := handler(call, req)
+		if err != nil {
+			return "", err
+		}
+		b, err := json.Marshal(resp)
+		return string(b), err
+	}
+	srv.redact[op] = func(payload string) string {
+		var req Req
+		if err := json.Unmarshal([]byte(payload), &req); err != nil {
+			return payload
+		}
+		redacter, ok := any(&req).(Redacter)
+		if !ok {
+			return payload
+		}
+		redacter.Redact()
+		b, err := json.Marshal(req)
+		if err != nil {
+			return ""
+		}
+		return string(b)
+	}
+}
+
+// Serve takes requests of the service and answers them until `stop` is closed.
+// Several workers may serve the same service, on the same node or on different ones.
+func (srv *Server) Serve(stop <-chan struct{}) {
+	wake, registration, err := watch(srv.space, requestTemplate(srv.service))
+	if err != nil {
+		srv.logger.Printf("failed to watch for requests: %s", err)
+		return
+	}
+	defer registration.Cancel()
+
+	for {
+		request, err := srv.space.Get(requestTemplate(srv.service), inSystemSpace)
+		if err == nil && request.IsPresent() {
+			srv.serve(request.Get())
+			continue
+		}
+
+		// Not the leader, or nothing to do
+		select {
+		case <-stop:
+			return
+		case <-wake:
+		case <-time.After(srv.PollInterval):
+		}
+	}
+}
+
+// Run the handler of the request and write the reply, retrying or dead-lettering on failure.
+func (srv *Server) serve(tuple ts.Tuple) {
+	var req rpcRequest
+	if err := ts.Unmarshal(tuple, &req); err != nil {
+		srv.logger.Printf("dropping malformed request: %s", err)
+		return
+	}
+	op, correlationID, payload, attempt := req.Op, req.CorrelationID, req.Payload, req.Attempt
+
+	handler, found := srv.handlers[op]
+	if !found {
+		srv.reply(correlationID, statusError, fmt.Sprintf("unknown operation %q", op))
+		return
+	}
+
+	// An earlier attempt may have replied before it failed.
+	if attempt > 0 {
+		replied, err := srv.space.Read(replyTemplate(correlationID), inSystemSpace)
+		if err != nil {
+			srv.logger.Printf("failed to look for the reply to %s: %s", correlationID, err)
+		} else if replied.IsPresent() {
+			return
+		}
+	}
+
+	call := CallInfo{Service: srv.service, Op: op, CorrelationID: correlationID, Attempt: attempt, state: &callState{replyLease: srv.ReplyLease}}
+	result, err := srv.call(handler, call, payload)
+	if err == nil {
+		if !call.state.replied {
+			srv.reply(correlationID, statusOK, result)
+		}
+		return
+	}
+
+	attempt++
+	srv.logger.Printf("%s %s failed on attempt %d: %s", op, correlationID, attempt, err)
+	if attempt < srv.MaxAttempts {
+		if err := srv.space.Write(requestTuple(srv.service, op, correlationID, payload, attempt), ts.Forever, inSystemSpace); err != nil {
+			srv.logger.Printf("failed to requeue %s: %s", correlationID, err)
+		}
+		return
+	}
+
+	if err := srv.space.Write(deadLetterTuple(srv.service, op, correlationID, srv.redact[op](payload), err.Error()), ts.Forever, inSystemSpace); err != nil {
+		srv.logger.Printf("failed to dead-letter %s: %s", correlationID, err)
+	}
+	if !call.state.replied {
+		srv.reply(correlationID, statusDead, err.Error())
+	}
+}
+
+// Run the handler, turning a panic into an error.
+func (srv *Server) call(handler handlerFunc, call CallInfo, payload string) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panicked: %v", r)
+		}
+	}()
+	return handler(call, payload)
+}
+
+func (srv *Server) reply(correlationID, status, payload string) {
+	if err := srv.space.Write(replyTuple(correlationID, status, payload), srv.ReplyLease, inSystemSpace); err != nil {
+		srv.logger.Printf("failed to reply to %s: %s", correlationID, err)
+	}
+}
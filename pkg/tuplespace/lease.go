@@ -0,0 +1,19 @@
+package tuplespace
+
+import "time"
+
+// Forever is the lease duration of tuples and registrations that never expire.
+const Forever time.Duration = 0
+
+// Computes the instant a lease granted at `now` runs out. The zero time stands for `Forever`.
+func leaseDeadline(now time.Time, lease time.Duration) time.Time {
+	if lease <= Forever {
+		return time.Time{}
+	}
+	return now.Add(lease)
+}
+
+// Returns true if the deadline is set and `now` is not before it.
+func isExpired(expires time.Time, now time.Time) bool {
+	return !expires.IsZero() && !now.Before(expires)
+}
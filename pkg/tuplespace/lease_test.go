@@ -0,0 +1,104 @@
+package tuplespace
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// A store whose clock only moves when the test advances it.
+func newClockedStore() (*BTreeStore, func(time.Duration)) {
+	now := time.Unix(1000, 0)
+	store := NewSimpleStore()
+	store.SetClock(func() time.Time { return now })
+	return store, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	store, advance := newClockedStore()
+	short := MakeTuple(S("short"))
+	long := MakeTuple(S("long"))
+	forever := MakeTuple(S("forever"))
+	store.Write(short, time.Second)
+	store.Write(long, time.Minute)
+	store.Write(forever, Forever)
+
+	advance(999 * time.Millisecond)
+	if !store.Read(short).IsPresent() {
+		t.Error("tuple expired before its lease ran out")
+	}
+	advance(time.Millisecond)
+	if store.Read(short).IsPresent() {
+		t.Error("tuple still visible when its lease ran out")
+	}
+
+	advance(time.Hour)
+	if store.Get(long).IsPresent() {
+		t.Error("tuple still visible after its lease")
+	}
+	if !store.Get(forever).IsPresent() {
+		t.Error("tuple without lease expired")
+	}
+}
+
+func TestLeaseRenewAndCancel(t *testing.T) {
+	store, advance := newClockedStore()
+	tuple := MakeTuple(S("job"), I(1))
+	store.Write(tuple, time.Second)
+
+	advance(900 * time.Millisecond)
+	if !store.Renew(MakeTuple(S("job"), Any()), time.Second) {
+		t.Fatal("could not renew a live tuple")
+	}
+	advance(900 * time.Millisecond)
+	if !store.Read(tuple).IsPresent() {
+		t.Error("renewed tuple expired on its original lease")
+	}
+	advance(100 * time.Millisecond)
+	if store.Read(tuple).IsPresent() {
+		t.Error("renewed tuple outlived its new lease")
+	}
+	if store.Renew(tuple, time.Second) {
+		t.Error("renewed an expired tuple")
+	}
+
+	store.Write(tuple, time.Second)
+	if !store.Renew(tuple, Forever) {
+		t.Fatal("could not renew a live tuple")
+	}
+	advance(time.Hour)
+	if !store.Cancel(tuple) {
+		t.Fatal("could not cancel a tuple renewed forever")
+	}
+	if store.Read(tuple).IsPresent() || store.Cancel(tuple) {
+		t.Error("cancelled tuple is still there")
+	}
+}
+
+func TestLeasesSurviveSnapshots(t *testing.T) {
+	store, _ := newClockedStore()
+	store.Write(MakeTuple(S("leased")), time.Second)
+	store.Write(MakeTuple(S("forever")), Forever)
+
+	data, err := json.Marshal(store)
+	if err != nil {
+		t.Fatalf("MarshalJSON: %v", err)
+	}
+	var restored BTreeStore
+	if err := json.Unmarshal(data, &restored); err != nil {
+		t.Fatalf("UnmarshalJSON: %v", err)
+	}
+
+	now := time.Unix(1000, 0)
+	restored.SetClock(func() time.Time { return now })
+	if !restored.Read(MakeTuple(S("leased"))).IsPresent() || !restored.Read(MakeTuple(S("forever"))).IsPresent() {
+		t.Fatal("restored store lost tuples")
+	}
+	now = now.Add(time.Second)
+	if restored.Read(MakeTuple(S("leased"))).IsPresent() {
+		t.Error("restored tuple lost its lease")
+	}
+	if !restored.Read(MakeTuple(S("forever"))).IsPresent() {
+		t.Error("restored tuple without lease expired")
+	}
+}
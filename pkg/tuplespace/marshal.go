@@ -0,0 +1,286 @@
+package tuplespace
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Marshal returns the tuple of a struct, or a pointer to one, whose fields are mapped to tuple
+// positions by `tuple` tags:
+//
+//	type Job struct {
+//		Tag    string  `tuple:"0"`
+//		ID     int     `tuple:"1"`
+//		Weight float64 `tuple:"2"`
+//	}
+//
+// The positions must run from 0 to the arity minus one. Fields without tag, or tagged "-", are
+// skipped. Go types map to elements as follows:
+//   - integer kinds are INTs, and so is `time.Time`, as Unix nanoseconds
+//   - float32 and float64 are FLOATs
+//   - string is a STRING
+//   - `Tuple`, and structs with `tuple` tags of their own, are TUPLEs
+//   - `Elem` is taken as it is, so it can hold a wildcard
+//
+// Errors are of type `*MappingError`.
+func Marshal(v interface{}) (Tuple, error) {
+	return marshal(v, false)
+}
+
+// Template is like `Marshal`, but fields holding the zero value of their type become typed
+// formals (see `Formal`), and zero `Elem` fields wildcards. A field that must match its zero
+// value exactly can be an `Elem` instead.
+func Template(v interface{}) (Tuple, error) {
+	return marshal(v, true)
+}
+
+// Unmarshal stores the fields of the tuple in the struct pointed to by `v`, mapped as by
+// `Marshal`. The tuple must have the arity of the struct and every field must have the type of
+// its struct field. Errors are of type `*MappingError`.
+func Unmarshal(t Tuple, v interface{}) error {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Pointer || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return &MappingError{Type: fmt.Sprintf("%T", v), Index: -1, Msg: "not a pointer to a struct"}
+	}
+	return unmarshal(t, value.Elem())
+}
+
+// MappingError describes why a struct could not be mapped to or from a tuple.
+type MappingError struct {
+	Type  string // Struct type
+	Field string // Name of the struct field, if the error is about one
+	Index int    // Tuple position of the field, or -1
+	Msg   string
+}
+
+func (e *MappingError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("tuplespace: %s: %s", e.Type, e.Msg)
+	}
+	return fmt.Sprintf("tuplespace: %s.%s (field %d): %s", e.Type, e.Field, e.Index, e.Msg)
+}
+
+var (
+	elemType  = reflect.TypeOf(Elem{})
+	tupleType = reflect.TypeOf(Tuple{})
+	timeType  = reflect.TypeOf(time.Time{})
+)
+
+// A struct field mapped to a tuple position.
+type mappedField struct {
+	index int // Position in the tuple
+	field reflect.StructField
+}
+
+// Returns the tagged fields of the struct type, ordered by position.
+func mappedFields(t reflect.Type) ([]mappedField, error) {
+	var fields []mappedField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, tagged := field.Tag.Lookup("tuple")
+		if !tagged || tag == "-" {
+			continue
+		}
+		index, err := strconv.Atoi(tag)
+		if err != nil || index < 0 {
+			return nil, &MappingError{Type: t.String(), Field: field.Name, Index: -1, Msg: fmt.Sprintf("invalid tuple tag %q", tag)}
+		}
+		if !field.IsExported() {
+			return nil, &MappingError{Type: t.String(), Field: field.Name, Index: index, Msg: "field is not exported"}
+		}
+		fields = append(fields, mappedField{index: index, field: field})
+	}
+
+	sort.Slice(fields, func(i, j int) bool { return fields[i].index < fields[j].index })
+	for i, f := range fields {
+		if f.index != i {
+			return nil, &MappingError{Type: t.String(), Field: f.field.Name, Index: f.index, Msg: fmt.Sprintf("positions must run from 0 to %d without gaps or repeats", len(fields)-1)}
+		}
+	}
+	if len(fields) == 0 {
+		return nil, &MappingError{Type: t.String(), Index: -1, Msg: "no fields with tuple tags"}
+	}
+	return fields, nil
+}
+
+func marshal(v interface{}, template bool) (Tuple, error) {
+	value := reflect.ValueOf(v)
+	if value.Kind() == reflect.Pointer && !value.IsNil() {
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return Tuple{}, &MappingError{Type: fmt.Sprintf("%T", v), Index: -1, Msg: "not a struct"}
+	}
+	return marshalStruct(value, template)
+}
+
+func marshalStruct(value reflect.Value, template bool) (Tuple, error) {
+	fields, err := mappedFields(value.Type())
+	if err != nil {
+		return Tuple{}, err
+	}
+
+	elements := make([]Elem, len(fields))
+	for i, f := range fields {
+		elements[i], err = marshalField(value.Field(f.field.Index[0]), template)
+		if err != nil {
+			if mappingErr, nested := err.(*MappingError); nested {
+				return Tuple{}, mappingErr
+			}
+			return Tuple{}, &MappingError{Type: value.Type().String(), Field: f.field.Name, Index: f.index, Msg: err.Error()}
+		}
+	}
+	return MakeTuple(elements...), nil
+}
+
+func marshalField(value reflect.Value, template bool) (Elem, error) {
+	if template && value.IsZero() {
+		if value.Type() == elemType {
+			return Any(), nil
+		}
+		kind, err := elementOf(value.Type())
+		if err != nil {
+			return Elem{}, err
+		}
+		return Formal(kind), nil
+	}
+
+	switch {
+	case value.Type() == elemType:
+		return value.Interface().(Elem), nil
+	case value.Type() == tupleType:
+		return T(value.Interface().(Tuple)), nil
+	case value.Type() == timeType:
+		return I(int(value.Interface().(time.Time).UnixNano())), nil
+	}
+
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return I(int(value.Int())), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if value.Uint() > uint64(^uint(0)>>1) {
+			return Elem{}, fmt.Errorf("%d overflows an int", value.Uint())
+		}
+		return I(int(value.Uint())), nil
+	case reflect.Float32, reflect.Float64:
+		return F(value.Float()), nil
+	case reflect.String:
+		return S(value.String()), nil
+	case reflect.Struct:
+		nested, err := marshalStruct(value, template)
+		if err != nil {
+			return Elem{}, err
+		}
+		return T(nested), nil
+	default:
+		return Elem{}, fmt.Errorf("type %s cannot be a tuple field", value.Type())
+	}
+}
+
+// Returns the element type of a Go type.
+func elementOf(t reflect.Type) (TupleElement, error) {
+	switch {
+	case t == tupleType:
+		return TUPLE, nil
+	case t == timeType:
+		return INT, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return INT, nil
+	case reflect.Float32, reflect.Float64:
+		return FLOAT, nil
+	case reflect.String:
+		return STRING, nil
+	case reflect.Struct:
+		return TUPLE, nil
+	default:
+		return NONE, fmt.Errorf("type %s cannot be a tuple field", t)
+	}
+}
+
+func unmarshal(t Tuple, value reflect.Value) error {
+	fields, err := mappedFields(value.Type())
+	if err != nil {
+		return err
+	}
+	elements := t.GetElements()
+	if len(elements) != len(fields) {
+		return &MappingError{Type: value.Type().String(), Index: -1, Msg: fmt.Sprintf("tuple %s has %d fields, the struct maps %d", t, len(elements), len(fields))}
+	}
+
+	for i, f := range fields {
+		err := unmarshalField(elements[i], value.Field(f.field.Index[0]))
+		if err != nil {
+			if mappingErr, nested := err.(*MappingError); nested {
+				return mappingErr
+			}
+			return &MappingError{Type: value.Type().String(), Field: f.field.Name, Index: f.index, Msg: err.Error()}
+		}
+	}
+	return nil
+}
+
+func unmarshalField(e Elem, value reflect.Value) error {
+	if value.Type() == elemType {
+		value.Set(reflect.ValueOf(e))
+		return nil
+	}
+
+	want, err := elementOf(value.Type())
+	if err != nil {
+		return err
+	}
+	if e.GetType() != want {
+		return fmt.Errorf("expected %s, got %s", elementName(want), elementName(e.GetType()))
+	}
+
+	switch {
+	case value.Type() == tupleType:
+		value.Set(reflect.ValueOf(e.GetValue().(Tuple)))
+		return nil
+	case value.Type() == timeType:
+		value.Set(reflect.ValueOf(time.Unix(0, int64(e.GetValue().(int)))))
+		return nil
+	}
+
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n := int64(e.GetValue().(int))
+		if value.OverflowInt(n) {
+			return fmt.Errorf("%d overflows %s", n, value.Type())
+		}
+		value.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n := e.GetValue().(int)
+		if n < 0 || value.OverflowUint(uint64(n)) {
+			return fmt.Errorf("%d overflows %s", n, value.Type())
+		}
+		value.SetUint(uint64(n))
+	case reflect.Float32, reflect.Float64:
+		value.SetFloat(e.GetValue().(float64))
+	case reflect.String:
+		value.SetString(e.GetValue().(string))
+	case reflect.Struct:
+		return unmarshal(e.GetValue().(Tuple), value)
+	}
+	return nil
+}
+
+// Returns the name of an element type for error messages.
+func elementName(t TupleElement) string {
+	switch t {
+	case ANY:
+		return "wildcard"
+	case NONE:
+		return "nil"
+	default:
+		return strings.ToUpper(typeNames[t])
+	}
+}
@@ -0,0 +1,184 @@
+package tuplespace
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testPoint struct {
+	X int `tuple:"0"`
+	Y int `tuple:"1"`
+}
+
+type testJob struct {
+	Tag      string    `tuple:"0"`
+	ID       int       `tuple:"2"`
+	Weight   float64   `tuple:"1"`
+	Small    int8      `tuple:"3"`
+	Count    uint      `tuple:"4"`
+	Ratio    float32   `tuple:"5"`
+	Args     Tuple     `tuple:"6"`
+	At       time.Time `tuple:"7"`
+	Origin   testPoint `tuple:"8"`
+	Any      Elem      `tuple:"9"`
+	Note     string
+	Internal string `tuple:"-"`
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	job := testJob{
+		Tag:    "job",
+		ID:     7,
+		Weight: 2.5,
+		Small:  -3,
+		Count:  4,
+		Ratio:  0.5,
+		Args:   MakeTuple(S("a"), I(1)),
+		At:     time.Unix(0, 1700000000000000000),
+		Origin: testPoint{X: 1, Y: -1},
+		Any:    Formal(STRING),
+	}
+
+	tuple, err := Marshal(&job)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	want := `("job"|2.5|7|-3|4|0.5|("a"|1)|1700000000000000000|(1|-1)|?string)`
+	if tuple.String() != want {
+		t.Errorf("Marshal: got %s, want %s", tuple, want)
+	}
+	if byValue, _ := Marshal(job); byValue.String() != want {
+		t.Errorf("Marshal of a value: got %s, want %s", byValue, want)
+	}
+
+	var got testJob
+	if err := Unmarshal(tuple, &got); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if !reflect.DeepEqual(got, job) {
+		t.Errorf("Unmarshal: got %+v, want %+v", got, job)
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	tests := []struct {
+		job  testJob
+		want string
+	}{
+		{testJob{}, `(?string|?float|?int|?int|?int|?float|?tuple|?int|?tuple|_)`},
+		{testJob{Tag: "job", ID: 3, Any: I(0)}, `("job"|?float|3|?int|?int|?float|?tuple|?int|?tuple|0)`},
+		{testJob{Tag: "job", Origin: testPoint{X: 1}}, `("job"|?float|?int|?int|?int|?float|?tuple|?int|(1|?int)|_)`},
+	}
+
+	for _, test := range tests {
+		template, err := Template(test.job)
+		if err != nil {
+			t.Errorf("Template(%+v): %v", test.job, err)
+			continue
+		}
+		if template.String() != test.want {
+			t.Errorf("Template(%+v): got %s, want %s", test.job, template, test.want)
+		}
+	}
+}
+
+type testGap struct {
+	A int `tuple:"0"`
+	B int `tuple:"2"`
+}
+
+type testRepeat struct {
+	A int `tuple:"0"`
+	B int `tuple:"0"`
+}
+
+type testBadTag struct {
+	A int `tuple:"first"`
+}
+
+type testUnexported struct {
+	a int `tuple:"0"`
+}
+
+type testUntagged struct {
+	A int
+}
+
+type testSlice struct {
+	A []int `tuple:"0"`
+}
+
+type testLarge struct {
+	A uint64 `tuple:"0"`
+}
+
+type testNested struct {
+	Tag   string    `tuple:"0"`
+	Point testPoint `tuple:"1"`
+}
+
+func TestMarshalErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		v     interface{}
+		field string
+		index int
+	}{
+		{"not a struct", 42, "", -1},
+		{"nil pointer", (*testPoint)(nil), "", -1},
+		{"gap", testGap{}, "B", 2},
+		{"repeat", testRepeat{}, "B", 0},
+		{"invalid tag", testBadTag{}, "A", -1},
+		{"unexported", testUnexported{}, "a", 0},
+		{"no tags", testUntagged{}, "", -1},
+		{"unsupported type", testSlice{}, "A", 0},
+		{"overflow", testLarge{A: ^uint64(0)}, "A", 0},
+	}
+
+	for _, test := range tests {
+		_, err := Marshal(test.v)
+		var mappingErr *MappingError
+		if !errors.As(err, &mappingErr) {
+			t.Errorf("%s: got %v, want a *MappingError", test.name, err)
+			continue
+		}
+		if mappingErr.Field != test.field || mappingErr.Index != test.index {
+			t.Errorf("%s: got field %q at %d, want %q at %d: %v", test.name, mappingErr.Field, mappingErr.Index, test.field, test.index, err)
+		}
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		tuple Tuple
+		v     interface{}
+		typ   string
+		field string
+		index int
+	}{
+		{"not a pointer", MakeTuple(I(1), I(2)), testPoint{}, "tuplespace.testPoint", "", -1},
+		{"too few fields", MakeTuple(I(1)), &testPoint{}, "tuplespace.testPoint", "", -1},
+		{"too many fields", MakeTuple(I(1), I(2), I(3)), &testPoint{}, "tuplespace.testPoint", "", -1},
+		{"type mismatch", MakeTuple(I(1), S("2")), &testPoint{}, "tuplespace.testPoint", "Y", 1},
+		{"wildcard", MakeTuple(I(1), Any()), &testPoint{}, "tuplespace.testPoint", "Y", 1},
+		{"nested type mismatch", MakeTuple(S("p"), T(MakeTuple(F(1), I(2)))), &testNested{}, "tuplespace.testPoint", "X", 0},
+		{"nested arity", MakeTuple(S("p"), T(MakeTuple(I(1)))), &testNested{}, "tuplespace.testPoint", "", -1},
+		{"int overflow", MakeTuple(S("job"), F(1), I(1), I(300), I(0), F(0), T(MakeTuple()), I(0), T(MakeTuple(I(0), I(0))), Any()), &testJob{}, "tuplespace.testJob", "Small", 3},
+		{"negative uint", MakeTuple(S("job"), F(1), I(1), I(0), I(-1), F(0), T(MakeTuple()), I(0), T(MakeTuple(I(0), I(0))), Any()), &testJob{}, "tuplespace.testJob", "Count", 4},
+	}
+
+	for _, test := range tests {
+		err := Unmarshal(test.tuple, test.v)
+		var mappingErr *MappingError
+		if !errors.As(err, &mappingErr) {
+			t.Errorf("%s: got %v, want a *MappingError", test.name, err)
+			continue
+		}
+		if mappingErr.Type != test.typ || mappingErr.Field != test.field || mappingErr.Index != test.index {
+			t.Errorf("%s: got %s.%q at %d, want %s.%q at %d: %v", test.name, mappingErr.Type, mappingErr.Field, mappingErr.Index, test.typ, test.field, test.index, err)
+		}
+	}
+}
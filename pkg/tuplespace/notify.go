@@ -0,0 +1,121 @@
+package tuplespace
+
+import (
+	"sync"
+	"time"
+)
+
+type EventKind uint8
+
+const (
+	// WRITTEN indicates that a tuple has been written into the space.
+	WRITTEN EventKind = 1
+	// TAKEN indicates that a tuple has been taken (read and removed) from the space.
+	TAKEN EventKind = 2
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case WRITTEN:
+		return "written"
+	case TAKEN:
+		return "taken"
+	default:
+		return "unknown"
+	}
+}
+
+// Event describes a change of the space that matched the template of a registration.
+type Event struct {
+	Kind  EventKind
+	Tuple Tuple
+}
+
+// Handler is called once for every event matching its registration.
+// Each call runs on its own goroutine, so a handler may block or access the space again.
+type Handler func(Event)
+
+type registration struct {
+	template Tuple
+	handler  Handler
+	expires  time.Time // zero value means the registration never expires
+}
+
+// The Notifier keeps the notify registrations of a space and dispatches events to them.
+// It is safe for concurrent use.
+type Notifier struct {
+	mu     sync.Mutex
+	nextID uint64
+	regs   map[uint64]*registration
+}
+
+// NewNotifier creates a notifier without any registrations.
+func NewNotifier() *Notifier {
+	return &Notifier{regs: make(map[uint64]*registration)}
+}
+
+// Registration is the handle returned by `Notify`, used to renew or cancel the lease of the
+// registration.
+type Registration struct {
+	id       uint64
+	notifier *Notifier
+}
+
+// Register adds a handler that fires whenever a tuple matching the template is written or
+// taken. The registration expires after `lease`, or never if the lease is `Forever`.
+func (n *Notifier) Register(template Tuple, lease time.Duration, handler Handler) *Registration {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.nextID++
+	n.regs[n.nextID] = &registration{
+		template: template,
+		handler:  handler,
+		expires:  leaseDeadline(time.Now(), lease),
+	}
+	return &Registration{id: n.nextID, notifier: n}
+}
+
+// Publish delivers an event to every live registration whose template matches the tuple.
+// Expired registrations are dropped on the way.
+func (n *Notifier) Publish(kind EventKind, tuple Tuple) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	now := time.Now()
+	for id, reg := range n.regs {
+		if isExpired(reg.expires, now) {
+			delete(n.regs, id)
+			continue
+		}
+		if tuple.IsMatching(reg.template) {
+			go reg.handler(Event{Kind: kind, Tuple: tuple})
+		}
+	}
+}
+
+// Renew extends the registration by `lease`, counted from now.
+// Returns `false` if the registration has already expired or was cancelled.
+func (r *Registration) Renew(lease time.Duration) bool {
+	r.notifier.mu.Lock()
+	defer r.notifier.mu.Unlock()
+
+	reg, found := r.notifier.regs[r.id]
+	if !found {
+		return false
+	}
+	now := time.Now()
+	if isExpired(reg.expires, now) {
+		delete(r.notifier.regs, r.id)
+		return false
+	}
+	reg.expires = leaseDeadline(now, lease)
+	return true
+}
+
+// Cancel removes the registration, its handler will not be called anymore.
+func (r *Registration) Cancel() {
+	r.notifier.mu.Lock()
+	defer r.notifier.mu.Unlock()
+	delete(r.notifier.regs, r.id)
+}
@@ -0,0 +1,93 @@
+package tuplespace
+
+import (
+	"testing"
+	"time"
+)
+
+// Returns the next event of the channel, failing the test if none arrives in time.
+func nextEvent(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("no event")
+		return Event{}
+	}
+}
+
+// Fails the test if an event arrives shortly.
+func noEvent(t *testing.T, events <-chan Event) {
+	t.Helper()
+	select {
+	case event := <-events:
+		t.Errorf("unexpected %s event for %s", event.Kind, event.Tuple)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestNotifierPublish(t *testing.T) {
+	n := NewNotifier()
+	events := make(chan Event, 10)
+	n.Register(MakeTuple(S("job"), Any()), Forever, func(e Event) { events <- e })
+
+	n.Publish(WRITTEN, MakeTuple(S("job"), I(1)))
+	if event := nextEvent(t, events); event.Kind != WRITTEN || event.Tuple.String() != `("job"|1)` {
+		t.Errorf("got %s %s, want written (\"job\"|1)", event.Kind, event.Tuple)
+	}
+	n.Publish(TAKEN, MakeTuple(S("job"), I(2)))
+	if event := nextEvent(t, events); event.Kind != TAKEN || event.Tuple.String() != `("job"|2)` {
+		t.Errorf("got %s %s, want taken (\"job\"|2)", event.Kind, event.Tuple)
+	}
+
+	n.Publish(WRITTEN, MakeTuple(S("other"), I(1)))
+	n.Publish(WRITTEN, MakeTuple(S("job"), I(1), I(2)))
+	noEvent(t, events)
+}
+
+func TestRegistrationLease(t *testing.T) {
+	n := NewNotifier()
+	events := make(chan Event, 10)
+	template := MakeTuple(S("job"))
+	job := MakeTuple(S("job"))
+
+	cancelled := n.Register(template, Forever, func(e Event) { events <- e })
+	cancelled.Cancel()
+	if cancelled.Renew(time.Hour) {
+		t.Error("renewed a cancelled registration")
+	}
+
+	expiring := n.Register(template, 20*time.Millisecond, func(e Event) { events <- e })
+	if !expiring.Renew(50 * time.Millisecond) {
+		t.Error("could not renew a live registration")
+	}
+	n.Publish(WRITTEN, job)
+	nextEvent(t, events)
+
+	time.Sleep(60 * time.Millisecond)
+	n.Publish(WRITTEN, job)
+	noEvent(t, events)
+	if expiring.Renew(time.Hour) {
+		t.Error("renewed an expired registration")
+	}
+}
+
+func TestSpaceNotify(t *testing.T) {
+	space := NewSpace()
+	events := make(chan Event, 10)
+	space.Notify(MakeTuple(S("job"), Any()), Forever, func(e Event) { events <- e })
+
+	<-space.Write(MakeTuple(S("job"), I(1)), Forever)
+	if event := nextEvent(t, events); event.Kind != WRITTEN {
+		t.Errorf("got %s, want written", event.Kind)
+	}
+	<-space.Read(MakeTuple(S("job"), Any()))
+	noEvent(t, events)
+	<-space.Get(MakeTuple(S("job"), Any()))
+	if event := nextEvent(t, events); event.Kind != TAKEN {
+		t.Errorf("got %s, want taken", event.Kind)
+	}
+	<-space.Get(MakeTuple(S("job"), Any()))
+	noEvent(t, events)
+}
@@ -0,0 +1,223 @@
+package tuplespace
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Parse parses a tuple literal, the inverse of `Tuple.String()`:
+//
+//	("job"|3|2.5|("nested"|_)|?int|nil)
+//
+// Fields are separated by `|` or by commas, and white space between tokens is ignored.
+//   - Strings are double-quoted, with the escapes of Go string literals.
+//   - Numbers without a fraction or exponent are INTs, others FLOATs, including `NaN` and `Inf`.
+//   - Nested tuples are written in parentheses.
+//   - `_` is a wildcard, `?int`, `?float`, `?string` and `?tuple` are typed formals (see `Formal`).
+//   - `nil` is the NONE element.
+//
+// Errors are of type `*ParseError`.
+func Parse(s string) (Tuple, error) {
+	p := parser{input: s}
+	tuple, err := p.parseTuple()
+	if err != nil {
+		return Tuple{}, err
+	}
+	if p.peek() != 0 {
+		return Tuple{}, p.errorf(p.pos, "unexpected %q after tuple", p.input[p.pos:])
+	}
+	return tuple, nil
+}
+
+// MustParse is like `Parse` but panics if the literal cannot be parsed. It simplifies the
+// initialization of tuples from literals in the source.
+func MustParse(s string) Tuple {
+	tuple, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return tuple
+}
+
+// ParseError describes where a tuple literal is invalid.
+type ParseError struct {
+	Offset int // Byte offset in the input
+	Line   int // Line of the offset, starting at 1
+	Column int // Column of the offset in bytes, starting at 1
+	Msg    string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("tuple literal %d:%d: %s", e.Line, e.Column, e.Msg)
+}
+
+type parser struct {
+	input string
+	pos   int
+}
+
+func (p *parser) errorf(offset int, format string, args ...interface{}) error {
+	line := 1 + strings.Count(p.input[:offset], "\n")
+	column := offset - strings.LastIndex(p.input[:offset], "\n")
+	return &ParseError{Offset: offset, Line: line, Column: column, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Returns the next byte after any white space, or 0 at the end of the input.
+func (p *parser) peek() byte {
+	for p.pos < len(p.input) && strings.IndexByte(" \t\r\n", p.input[p.pos]) >= 0 {
+		p.pos++
+	}
+	if p.pos == len(p.input) {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+func (p *parser) parseTuple() (Tuple, error) {
+	if c := p.peek(); c != '(' {
+		return Tuple{}, p.unexpected(c, "'('")
+	}
+	p.pos++
+
+	elements := []Elem{}
+	if p.peek() == ')' {
+		p.pos++
+		return MakeTuple(elements...), nil
+	}
+	for {
+		elem, err := p.parseElem()
+		if err != nil {
+			return Tuple{}, err
+		}
+		elements = append(elements, elem)
+
+		switch c := p.peek(); c {
+		case '|', ',':
+			p.pos++
+		case ')':
+			p.pos++
+			return MakeTuple(elements...), nil
+		default:
+			return Tuple{}, p.unexpected(c, "'|', ',' or ')'")
+		}
+	}
+}
+
+func (p *parser) unexpected(c byte, expected string) error {
+	if c == 0 {
+		return p.errorf(p.pos, "unexpected end of input, expected %s", expected)
+	}
+	return p.errorf(p.pos, "unexpected %q, expected %s", c, expected)
+}
+
+func (p *parser) parseElem() (Elem, error) {
+	switch c := p.peek(); {
+	case c == '(':
+		tuple, err := p.parseTuple()
+		return T(tuple), err
+	case c == '"':
+		return p.parseString()
+	case c == '?':
+		return p.parseFormal()
+	case c == '-' || c == '+' || c == '.' || isDigit(c):
+		return p.parseNumber()
+	case c == '_' || isLetter(c):
+		return p.parseIdent()
+	default:
+		return Elem{}, p.unexpected(c, "a field")
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// Returns the longest run of letters, digits and underscores at the current position.
+func (p *parser) word() string {
+	start := p.pos
+	for p.pos < len(p.input) && (p.input[p.pos] == '_' || isLetter(p.input[p.pos]) || isDigit(p.input[p.pos])) {
+		p.pos++
+	}
+	return p.input[start:p.pos]
+}
+
+func (p *parser) parseString() (Elem, error) {
+	start := p.pos
+	for i := start + 1; i < len(p.input); i++ {
+		switch p.input[i] {
+		case '\\':
+			i++
+		case '\n':
+			return Elem{}, p.errorf(i, "newline in string")
+		case '"':
+			value, err := strconv.Unquote(p.input[start : i+1])
+			if err != nil {
+				return Elem{}, p.errorf(start, "invalid escape in string %s", p.input[start:i+1])
+			}
+			p.pos = i + 1
+			return S(value), nil
+		}
+	}
+	return Elem{}, p.errorf(start, "unterminated string")
+}
+
+func (p *parser) parseFormal() (Elem, error) {
+	start := p.pos
+	p.pos++
+	name := p.word()
+	for elemType, typeName := range typeNames {
+		if name == typeName {
+			return Formal(elemType), nil
+		}
+	}
+	return Elem{}, p.errorf(start, "unknown type %q in formal, expected ?int, ?float, ?string or ?tuple", name)
+}
+
+func (p *parser) parseIdent() (Elem, error) {
+	start := p.pos
+	switch name := p.word(); name {
+	case "_":
+		return Any(), nil
+	case "nil":
+		return None(), nil
+	case "NaN", "Inf":
+		f, _ := strconv.ParseFloat(name, 64)
+		return F(f), nil
+	default:
+		return Elem{}, p.errorf(start, "unknown identifier %q", name)
+	}
+}
+
+func (p *parser) parseNumber() (Elem, error) {
+	start := p.pos
+	if c := p.input[p.pos]; c == '+' || c == '-' {
+		p.pos++
+	}
+	for p.pos < len(p.input) {
+		c := p.input[p.pos]
+		exponentSign := (c == '+' || c == '-') && (p.input[p.pos-1] == 'e' || p.input[p.pos-1] == 'E')
+		if !isDigit(c) && !isLetter(c) && c != '.' && c != '_' && !exponentSign {
+			break
+		}
+		p.pos++
+	}
+	literal := p.input[start:p.pos]
+
+	if !strings.ContainsAny(literal, ".eEnN") {
+		i, err := strconv.Atoi(literal)
+		if err != nil {
+			return Elem{}, p.errorf(start, "invalid integer %s", literal)
+		}
+		return I(i), nil
+	}
+	f, err := strconv.ParseFloat(literal, 64)
+	if err != nil {
+		return Elem{}, p.errorf(start, "invalid number %s", literal)
+	}
+	return F(f), nil
+}
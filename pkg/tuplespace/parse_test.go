@@ -0,0 +1,107 @@
+package tuplespace
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParseRoundTrip(t *testing.T) {
+	tests := []struct {
+		literal string
+		want    Tuple
+	}{
+		{`()`, MakeTuple()},
+		{`("job"|3|2.5|("nested"|_)|?int|nil)`, MakeTuple(S("job"), I(3), F(2.5), T(MakeTuple(S("nested"), Any())), Formal(INT), None())},
+		{` ( 1 , -2.5e3 ,"x" ) `, MakeTuple(I(1), F(-2500), S("x"))},
+		{`(1.0|+7|.5|1e3)`, MakeTuple(F(1), I(7), F(0.5), F(1000))},
+		{`(Inf|-Inf)`, MakeTuple(F(math.Inf(1)), F(math.Inf(-1)))},
+		{`("a\"b\\c\n\té\x41"|"")`, MakeTuple(S("a\"b\\c\n\téA"), S(""))},
+		{`("|,()_")`, MakeTuple(S("|,()_"))},
+		{`(((1|(2|()))))`, MakeTuple(T(MakeTuple(T(MakeTuple(I(1), T(MakeTuple(I(2), T(MakeTuple()))))))))},
+		{`(?int|?float|?string|?tuple|_|nil)`, MakeTuple(Formal(INT), Formal(FLOAT), Formal(STRING), Formal(TUPLE), Any(), None())},
+	}
+
+	for _, test := range tests {
+		got, err := Parse(test.literal)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", test.literal, err)
+			continue
+		}
+		if got.String() != test.want.String() {
+			t.Errorf("Parse(%q): got %s, want %s", test.literal, got, test.want)
+		}
+
+		again, err := Parse(got.String())
+		if err != nil {
+			t.Errorf("Parse(%q), printed by String: %v", got.String(), err)
+			continue
+		}
+		if again.String() != got.String() {
+			t.Errorf("Parse(%q): got %s, want it unchanged", got.String(), again)
+		}
+	}
+}
+
+func TestParseElementTypes(t *testing.T) {
+	tuple := MustParse(`(1|1.0|"1"|(1)|_|?int|nil|NaN)`)
+	want := []TupleElement{INT, FLOAT, STRING, TUPLE, ANY, ANY, NONE, FLOAT}
+	elements := tuple.GetElements()
+	if len(elements) != len(want) {
+		t.Fatalf("got %d elements, want %d", len(elements), len(want))
+	}
+	for i, e := range elements {
+		if e.GetType() != want[i] {
+			t.Errorf("element %d %s: got type %d, want %d", i, e, e.GetType(), want[i])
+		}
+	}
+	if f := elements[7].GetValue().(float64); !math.IsNaN(f) {
+		t.Errorf("got %v, want NaN", f)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		literal string
+		offset  int
+		line    int
+		column  int
+	}{
+		{``, 0, 1, 1},
+		{`1`, 0, 1, 1},
+		{`(1|2`, 4, 1, 5},
+		{`(1|)`, 3, 1, 4},
+		{`(1 2)`, 3, 1, 4},
+		{`(1) 2`, 4, 1, 5},
+		{`("abc`, 1, 1, 2},
+		{"(\"a\nb\")", 3, 1, 4},
+		{`(1|"a\qb")`, 3, 1, 4},
+		{`(foo)`, 1, 1, 2},
+		{`(1.2.3)`, 1, 1, 2},
+		{`(99999999999999999999)`, 1, 1, 2},
+		{"(1|\n ?bool)", 5, 2, 2},
+		{"(\n(1|\n\t\"x\"|\n@))", 12, 4, 1},
+	}
+
+	for _, test := range tests {
+		_, err := Parse(test.literal)
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("Parse(%q): got %v, want a *ParseError", test.literal, err)
+			continue
+		}
+		if parseErr.Offset != test.offset || parseErr.Line != test.line || parseErr.Column != test.column {
+			t.Errorf("Parse(%q): got error at offset %d (%d:%d), want %d (%d:%d): %v",
+				test.literal, parseErr.Offset, parseErr.Line, parseErr.Column, test.offset, test.line, test.column, err)
+		}
+	}
+}
+
+func TestMustParsePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("MustParse of an invalid literal did not panic")
+		}
+	}()
+	MustParse(`(1|`)
+}
@@ -1,23 +1,26 @@
 package tuplespace
 
 import (
+	"time"
+
 	opt "github.com/micutio/goptional"
 )
 
 // The Space contains the actual store and handles concurrent read and write access to it.
 type Space struct {
-	store Store
+	store    Store
+	notifier *Notifier
 }
 
 // Create a new space instance that uses the default store implementation `SimpleStore`
 func NewSpace() *Space {
 	// Use btree as the default store
-	return &Space{store: NewSimpleStore()}
+	return MakeSpace(NewSimpleStore())
 }
 
 // Create a new space that uses the given store implementation
 func MakeSpace(store Store) *Space {
-	return &Space{store}
+	return &Space{store: store, notifier: NewNotifier()}
 }
 
 // Retrieve a tuple that matches the query from the space and remove it.
@@ -26,7 +29,11 @@ func MakeSpace(store Store) *Space {
 func (s *Space) Get(query Tuple) <-chan opt.Maybe[Tuple] {
 	c := make(chan opt.Maybe[Tuple])
 	go func() {
-		c <- s.store.Get(query)
+		tuple := s.store.Get(query)
+		if tuple.IsPresent() {
+			s.notifier.Publish(TAKEN, tuple.Get())
+		}
+		c <- tuple
 	}()
 	return c
 }
@@ -44,11 +51,87 @@ func (s *Space) Read(query Tuple) <-chan opt.Maybe[Tuple] {
 
 // Insert a tuple into the tuple space.
 // The tuple must be defined, i.e.: NOT contain any wildcards or `None`, otherwise it will not be
-// inserted.
-func (s *Space) Write(query Tuple) <-chan bool {
+// inserted. It expires after `lease`, or never if the lease is `Forever`.
+func (s *Space) Write(query Tuple, lease time.Duration) <-chan bool {
+	c := make(chan bool)
+	go func() {
+		ok := s.store.Write(query, lease)
+		if ok {
+			s.notifier.Publish(WRITTEN, query)
+		}
+		c <- ok
+	}()
+	return c
+}
+
+// Retrieve up to `limit` tuples that match the query from the space and remove them, which is
+// also known as `collect`. A limit of zero or less means no limit.
+func (s *Space) GetAll(query Tuple, limit int) <-chan []Tuple {
+	c := make(chan []Tuple)
+	go func() {
+		tuples := s.store.GetAll(query, limit)
+		for _, tuple := range tuples {
+			s.notifier.Publish(TAKEN, tuple)
+		}
+		c <- tuples
+	}()
+	return c
+}
+
+// Retrieve up to `limit` tuples that match the query from the space but do not remove them.
+// A limit of zero or less means no limit.
+func (s *Space) ReadAll(query Tuple, limit int) <-chan []Tuple {
+	c := make(chan []Tuple)
+	go func() {
+		c <- s.store.ReadAll(query, limit)
+	}()
+	return c
+}
+
+// Count the tuples in the space that match the query.
+func (s *Space) Count(query Tuple) <-chan int {
+	c := make(chan int)
+	go func() {
+		c <- s.store.Count(query)
+	}()
+	return c
+}
+
+// Insert all tuples into the tuple space, or none of them if any is not defined.
+func (s *Space) WriteMany(tuples []Tuple, lease time.Duration) <-chan bool {
+	c := make(chan bool)
+	go func() {
+		ok := s.store.WriteMany(tuples, lease)
+		if ok {
+			for _, tuple := range tuples {
+				s.notifier.Publish(WRITTEN, tuple)
+			}
+		}
+		c <- ok
+	}()
+	return c
+}
+
+// Renew the lease of a tuple that matches the query, counting from now.
+func (s *Space) Renew(query Tuple, lease time.Duration) <-chan bool {
 	c := make(chan bool)
 	go func() {
-		c <- s.store.Write(query)
+		c <- s.store.Renew(query, lease)
 	}()
 	return c
 }
+
+// Cancel the lease of a tuple that matches the query, which removes it from the space.
+func (s *Space) Cancel(query Tuple) <-chan bool {
+	c := make(chan bool)
+	go func() {
+		c <- s.store.Cancel(query)
+	}()
+	return c
+}
+
+// Register a handler that is called whenever a tuple matching the template is written into or
+// taken from the space. The registration expires after `lease`, or never if it is `Forever`.
+func (s *Space) Notify(template Tuple, lease time.Duration, handler Handler) *Registration {
+	return s.notifier.Register(template, lease, handler)
+}
@@ -1,8 +1,10 @@
 package tuplespace
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/tidwall/btree"
 
@@ -20,91 +22,341 @@ type Store interface {
 	// Read a tuple that matches the argument.
 	Read(query Tuple) opt.Maybe[Tuple]
 
-	// Write a tuple into the tuple space.
-	Write(tuple Tuple) bool
+	// Write a tuple into the tuple space. The tuple expires after `lease`, or never if the
+	// lease is `Forever`.
+	Write(tuple Tuple, lease time.Duration) bool
+
+	// Renew the lease of a tuple that matches the argument, counting from now.
+	Renew(query Tuple, lease time.Duration) bool
+
+	// Cancel the lease of a tuple that matches the argument, removing it from the space.
+	Cancel(query Tuple) bool
+
+	// Read up to `limit` tuples that match the argument and remove them from the space.
+	// A limit of zero or less means no limit.
+	GetAll(query Tuple, limit int) []Tuple
+
+	// Read up to `limit` tuples that match the argument. A limit of zero or less means no limit.
+	ReadAll(query Tuple, limit int) []Tuple
+
+	// Count the tuples that match the argument.
+	Count(query Tuple) int
+
+	// Write all tuples into the tuple space, or none of them if any is undefined.
+	WriteMany(tuples []Tuple, lease time.Duration) bool
+}
+
+// A leased tuple and the instant it expires.
+type leasedTuple struct {
+	tuple   Tuple
+	expires time.Time
 }
 
 // The BTreeStore is a simple in-memory implementation of a store.
 type BTreeStore struct {
-	tree *btree.BTreeG[Tuple]
+	tree   *btree.BTreeG[Tuple]
+	leases map[string]leasedTuple // Tuples with a finite lease, keyed by `Tuple.String()`
+	clock  func() time.Time
 }
 
 // NewSimpleStore creates an empty store instance which is ready for use.
 func NewSimpleStore() *BTreeStore {
-	return &BTreeStore{tree: btree.NewBTreeG(TupleOrder)}
+	return &BTreeStore{
+		tree:   btree.NewBTreeG(TupleOrder),
+		leases: make(map[string]leasedTuple),
+		clock:  time.Now,
+	}
 }
 
-// Get implements the `Get` function of the `Store` interface.
-func (store *BTreeStore) Get(query Tuple) opt.Maybe[Tuple] {
+// SetClock replaces the clock used to grant and expire leases, which defaults to `time.Now`.
+// Replicated stores use it to expire tuples deterministically, based on the time recorded in
+// the log instead of the local clock.
+func (store *BTreeStore) SetClock(clock func() time.Time) {
+	store.clock = clock
+}
+
+// Remove every tuple whose lease has run out.
+func (store *BTreeStore) expire() {
+	now := store.clock()
+	for key, leased := range store.leases {
+		if isExpired(leased.expires, now) {
+			store.tree.Delete(leased.tuple)
+			delete(store.leases, key)
+		}
+	}
+}
+
+// Find a tuple that matches the query, ignoring expired ones.
+func (store *BTreeStore) find(query Tuple) (Tuple, bool) {
+	store.expire()
 	tuple, found := store.tree.Get(query)
-	if found {
+	if found && tuple.IsMatching(query) {
+		return tuple, true
+	}
+
+	// Count the defined fields the query starts with, and look for defined fields after them
+	prefix := 0
+	for prefix < len(query.elements) && query.elements[prefix].IsDefined() {
+		prefix++
+	}
+	if prefix == len(query.elements) {
+		return Tuple{}, false
+	}
+	gap := false
+	for _, e := range query.elements[prefix:] {
+		gap = gap || e.IsDefined()
+	}
+
+	found = false
+	if gap {
+		// Wildcards compare equal to anything, so the tree search may step past a match when
+		// defined fields follow a wildcard. Only a full scan finds it.
+		store.matching(query, 1, func(match Tuple) {
+			tuple, found = match, true
+		})
+		return tuple, found
+	}
+
+	// The matches start with the defined fields of the query, so they lie in the range of tuples
+	// sharing that prefix. The tree search may land on a tuple of the range that a typed formal
+	// rejects, or miss the range when tuples of other arities lie in it, so scan just the range.
+	store.tree.Ascend(MakeTuple(query.elements[:prefix]...), func(candidate Tuple) bool {
+		if len(candidate.elements) < prefix {
+			return false
+		}
+		for i, e := range query.elements[:prefix] {
+			if candidate.elements[i].order(e) != EQ {
+				return false
+			}
+		}
+		if candidate.IsMatching(query) {
+			tuple, found = candidate, true
+			return false
+		}
+		return true
+	})
+	return tuple, found
+}
+
+// Call `fn` for up to `limit` tuples matching the query, in `TupleOrder`.
+// A limit of zero or less means no limit. Expired tuples must have been removed beforehand.
+func (store *BTreeStore) matching(query Tuple, limit int, fn func(Tuple)) {
+	count := 0
+	store.tree.Scan(func(tuple Tuple) bool {
 		if tuple.IsMatching(query) {
-			store.tree.Delete(tuple)
-			return opt.NewJust(tuple)
-		} else {
-			fmt.Printf("[Get] tuple %v does not match query %v\n", tuple, query)
+			fn(tuple)
+			count++
 		}
+		return limit <= 0 || count < limit
+	})
+}
+
+// Remove a tuple from the tree together with its lease.
+func (store *BTreeStore) remove(tuple Tuple) {
+	store.tree.Delete(tuple)
+	delete(store.leases, tuple.String())
+}
+
+// Get implements the `Get` function of the `Store` interface.
+func (store *BTreeStore) Get(query Tuple) opt.Maybe[Tuple] {
+	tuple, found := store.find(query)
+	if found {
+		store.remove(tuple)
+		return opt.NewJust(tuple)
 	}
 	return opt.NewNothing[Tuple]()
 }
 
 // Read implements the `Read` function of the `Store` interface.
 func (store *BTreeStore) Read(query Tuple) opt.Maybe[Tuple] {
-	tuple, found := store.tree.Get(query)
+	tuple, found := store.find(query)
 	if found {
-		if tuple.IsMatching(query) {
-			return opt.NewJust(tuple)
-		} else {
-			fmt.Printf("[Read] tuple %v does not match query %v\n", tuple, query)
-		}
+		return opt.NewJust(tuple)
 	}
 	return opt.NewNothing[Tuple]()
 }
 
 // Write implements the `Write` function of the `Store` interface
 // Returns `true` if the tuple was inserted, false otherwise
-func (store *BTreeStore) Write(tuple Tuple) bool {
+func (store *BTreeStore) Write(tuple Tuple, lease time.Duration) bool {
 	if !tuple.IsDefined() {
 		fmt.Printf("[Write] Warning: attempt to store undefined tuple %v \n", tuple)
 		return false
 	} else {
 		store.tree.Set(tuple)
+		store.setLease(tuple, lease)
 		return true
 	}
 }
 
-func (store *BTreeStore) Copy() *BTreeStore {
-	clone := NewSimpleStore()
+func (store *BTreeStore) setLease(tuple Tuple, lease time.Duration) {
+	expires := leaseDeadline(store.clock(), lease)
+	if expires.IsZero() {
+		delete(store.leases, tuple.String())
+	} else {
+		store.leases[tuple.String()] = leasedTuple{tuple: tuple, expires: expires}
+	}
+}
 
-	// Get root node
-	root, empty := store.tree.GetAt(0)
-	if empty {
-		return clone
+// Renew implements the `Renew` function of the `Store` interface.
+// Returns `false` if no live tuple matches the query.
+func (store *BTreeStore) Renew(query Tuple, lease time.Duration) bool {
+	tuple, found := store.find(query)
+	if found {
+		store.setLease(tuple, lease)
 	}
+	return found
+}
 
-	store.tree.Ascend(root, func(i Tuple) bool {
-		clone.Write(i)
-		fmt.Printf("Copied tuple: %v\n", i)
-		return true
+// Cancel implements the `Cancel` function of the `Store` interface.
+// Returns `false` if no live tuple matches the query.
+func (store *BTreeStore) Cancel(query Tuple) bool {
+	tuple, found := store.find(query)
+	if found {
+		store.remove(tuple)
+	}
+	return found
+}
+
+// GetAll implements the `GetAll` function of the `Store` interface.
+func (store *BTreeStore) GetAll(query Tuple, limit int) []Tuple {
+	tuples := store.ReadAll(query, limit)
+	for _, tuple := range tuples {
+		store.remove(tuple)
+	}
+	return tuples
+}
+
+// ReadAll implements the `ReadAll` function of the `Store` interface.
+func (store *BTreeStore) ReadAll(query Tuple, limit int) []Tuple {
+	store.expire()
+	tuples := []Tuple{}
+	store.matching(query, limit, func(tuple Tuple) {
+		tuples = append(tuples, tuple)
 	})
+	return tuples
+}
 
-	return clone
+// Count implements the `Count` function of the `Store` interface.
+func (store *BTreeStore) Count(query Tuple) int {
+	store.expire()
+	count := 0
+	store.matching(query, 0, func(Tuple) {
+		count++
+	})
+	return count
 }
 
-func (store *BTreeStore) MarshalJSON() ([]byte, error) {
-	var tuples []Tuple
+// WriteMany implements the `WriteMany` function of the `Store` interface.
+// Returns `true` if the tuples were inserted, false otherwise
+func (store *BTreeStore) WriteMany(tuples []Tuple, lease time.Duration) bool {
+	for _, tuple := range tuples {
+		if !tuple.IsDefined() {
+			fmt.Printf("[WriteMany] Warning: attempt to store undefined tuple %v \n", tuple)
+			return false
+		}
+	}
+	for _, tuple := range tuples {
+		store.tree.Set(tuple)
+		store.setLease(tuple, lease)
+	}
+	return true
+}
 
-	root, empty := store.tree.GetAt(0)
-	if empty {
-		return nil, nil
+// Scan returns up to `limit` tuples matching the template in `TupleOrder`, starting after the
+// position encoded in the cursor `after` (or from the beginning if it is empty). An empty
+// template matches every tuple. The returned cursor continues the scan on the next call and is
+// empty once there are no more matches. A limit of zero or less means no limit.
+//
+// The scan runs against the store as it is, so callers that page through a store that is
+// being modified should scan a `Copy` of it.
+func (store *BTreeStore) Scan(template Tuple, after string, limit int) ([]Tuple, string, error) {
+	pivot, err := decodeCursor(after)
+	if err != nil {
+		return nil, "", err
 	}
 
-	store.tree.Ascend(root, func(i Tuple) bool {
-		tuples = append(tuples, i)
+	store.expire()
+	tuples := []Tuple{}
+	cursor := ""
+	iter := func(tuple Tuple) bool {
+		if pivot.IsPresent() && !TupleOrder(pivot.Get(), tuple) {
+			return true
+		}
+		if len(template.elements) > 0 && !tuple.IsMatching(template) {
+			return true
+		}
+		if limit > 0 && len(tuples) == limit {
+			cursor = encodeCursor(tuples[len(tuples)-1])
+			return false
+		}
+		tuples = append(tuples, tuple)
+		return true
+	}
+
+	if pivot.IsPresent() {
+		store.tree.Ascend(pivot.Get(), iter)
+	} else {
+		store.tree.Scan(iter)
+	}
+	return tuples, cursor, nil
+}
+
+// Scan cursors are the last tuple of a page, encoded as URL-safe base64 of its JSON form.
+func encodeCursor(last Tuple) string {
+	b, _ := json.Marshal(last)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(cursor string) (opt.Maybe[Tuple], error) {
+	if cursor == "" {
+		return opt.NewNothing[Tuple](), nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return opt.NewNothing[Tuple](), fmt.Errorf("invalid cursor: %s", err)
+	}
+	var last Tuple
+	if err := json.Unmarshal(b, &last); err != nil {
+		return opt.NewNothing[Tuple](), fmt.Errorf("invalid cursor: %s", err)
+	}
+	return opt.NewJust(last), nil
+}
+
+// Copy returns a point-in-time copy of the store. The tree is copied lazily, so this is cheap
+// even for large stores.
+func (store *BTreeStore) Copy() *BTreeStore {
+	clone := &BTreeStore{
+		tree:   store.tree.Copy(),
+		leases: make(map[string]leasedTuple, len(store.leases)),
+		clock:  store.clock,
+	}
+	for key, leased := range store.leases {
+		clone.leases[key] = leased
+	}
+	return clone
+}
+
+// The JSON representation of a stored tuple. `Expires` is given in Unix nanoseconds and
+// omitted for tuples that never expire.
+type jsonEntry struct {
+	Tuple   Tuple `json:"tuple"`
+	Expires int64 `json:"expires,omitempty"`
+}
+
+func (store *BTreeStore) MarshalJSON() ([]byte, error) {
+	entries := []jsonEntry{}
+
+	store.tree.Scan(func(i Tuple) bool {
+		entry := jsonEntry{Tuple: i}
+		if leased, ok := store.leases[i.String()]; ok {
+			entry.Expires = leased.expires.UnixNano()
+		}
+		entries = append(entries, entry)
 		return true
 	})
 
-	result, err := json.Marshal(tuples)
+	result, err := json.Marshal(entries)
 	if err != nil {
 		return nil, err
 	}
@@ -114,6 +366,25 @@ func (store *BTreeStore) MarshalJSON() ([]byte, error) {
 	return result, nil
 }
 
+func (store *BTreeStore) UnmarshalJSON(data []byte) error {
+	var entries []jsonEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+
+	*store = *NewSimpleStore()
+	for _, entry := range entries {
+		store.tree.Set(entry.Tuple)
+		if entry.Expires != 0 {
+			store.leases[entry.Tuple.String()] = leasedTuple{
+				tuple:   entry.Tuple,
+				expires: time.Unix(0, entry.Expires),
+			}
+		}
+	}
+	return nil
+}
+
 func (store *BTreeStore) MarshalBinary() ([]byte, error) {
 	return store.MarshalJSON()
 }
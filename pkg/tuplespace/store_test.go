@@ -0,0 +1,182 @@
+package tuplespace
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns the tuples printed one after the other, for comparisons.
+func join(tuples []Tuple) string {
+	var s []string
+	for _, tuple := range tuples {
+		s = append(s, tuple.String())
+	}
+	return strings.Join(s, " ")
+}
+
+func TestReadFindsMatches(t *testing.T) {
+	store := NewSimpleStore()
+	for _, tuple := range []Tuple{
+		MakeTuple(S("a"), S("x")),
+		MakeTuple(S("a"), S("y"), I(1)),
+		MakeTuple(S("a"), I(5)),
+		MakeTuple(S("a"), I(6), I(7)),
+		MakeTuple(S("b"), F(1.5), S("z")),
+		MakeTuple(S("c"), T(MakeTuple(S("n"), I(1))), I(2)),
+		MakeTuple(I(1), I(2), I(3)),
+	} {
+		store.Write(tuple, Forever)
+	}
+
+	tests := []struct {
+		template Tuple
+		want     string // Empty if nothing matches
+	}{
+		{MakeTuple(S("a"), I(5)), `("a"|5)`},
+		{MakeTuple(S("a"), I(7)), ""},
+		{MakeTuple(S("a"), Any(), Any(), Any()), ""},
+		{MakeTuple(S("a"), Formal(INT)), `("a"|5)`},
+		{MakeTuple(S("a"), Formal(FLOAT)), ""},
+		{MakeTuple(S("a"), Formal(INT), Any()), `("a"|6|7)`},
+		{MakeTuple(S("a"), Formal(STRING), Formal(INT)), `("a"|"y"|1)`},
+		{MakeTuple(Any(), Formal(FLOAT), S("z")), `("b"|1.5|"z")`},
+		{MakeTuple(Formal(INT), Any(), Any()), `(1|2|3)`},
+		{MakeTuple(S("c"), T(MakeTuple(S("n"), Formal(INT))), Any()), `("c"|("n"|1)|2)`},
+		{MakeTuple(Any(), F(1.5), S("z")), `("b"|1.5|"z")`},
+		{MakeTuple(Any(), Any(), I(7)), `("a"|6|7)`},
+		{MakeTuple(Any(), S("y"), Any()), `("a"|"y"|1)`},
+		{MakeTuple(Any(), Any(), I(4)), ""},
+		{MakeTuple(S("c"), T(MakeTuple(Any(), I(1))), I(2)), `("c"|("n"|1)|2)`},
+		{MakeTuple(S("c"), T(MakeTuple(S("n"), I(2))), Any()), ""},
+	}
+
+	for _, test := range tests {
+		got := store.Read(test.template)
+		switch {
+		case test.want == "" && got.IsPresent():
+			t.Errorf("Read(%s): got %s, want nothing", test.template, got.Get())
+		case test.want != "" && !got.IsPresent():
+			t.Errorf("Read(%s): got nothing, want %s", test.template, test.want)
+		case test.want != "" && got.Get().String() != test.want:
+			t.Errorf("Read(%s): got %s, want %s", test.template, got.Get(), test.want)
+		}
+	}
+}
+
+func TestBulkOperations(t *testing.T) {
+	now := time.Unix(1000, 0)
+	store := NewSimpleStore()
+	store.SetClock(func() time.Time { return now })
+
+	jobs := []Tuple{MakeTuple(S("job"), I(3)), MakeTuple(S("job"), I(1)), MakeTuple(S("job"), I(2))}
+	if !store.WriteMany(jobs, Forever) {
+		t.Fatal("WriteMany failed")
+	}
+	if store.WriteMany([]Tuple{MakeTuple(S("job"), I(4)), MakeTuple(S("job"), Any())}, Forever) {
+		t.Error("WriteMany of an undefined tuple succeeded")
+	}
+	store.Write(MakeTuple(S("job"), I(9)), time.Second)
+	store.Write(MakeTuple(S("other"), I(1)), Forever)
+
+	all := MakeTuple(S("job"), Any())
+	if got := store.Count(all); got != 4 {
+		t.Errorf("Count: got %d, want 4", got)
+	}
+	if got := join(store.ReadAll(all, 2)); got != `("job"|1) ("job"|2)` {
+		t.Errorf("ReadAll with limit: got %s", got)
+	}
+
+	now = now.Add(time.Second)
+	if got := store.Count(all); got != 3 {
+		t.Errorf("Count after a lease ran out: got %d, want 3", got)
+	}
+	if got := join(store.GetAll(all, 0)); got != `("job"|1) ("job"|2) ("job"|3)` {
+		t.Errorf("GetAll: got %s", got)
+	}
+	if got := store.Count(all); got != 0 {
+		t.Errorf("Count after GetAll: got %d, want 0", got)
+	}
+	if got := store.Count(MakeTuple(Any(), Any())); got != 1 {
+		t.Errorf("Count of the rest: got %d, want 1", got)
+	}
+}
+
+func TestScanPages(t *testing.T) {
+	store := NewSimpleStore()
+	for i := 0; i < 25; i++ {
+		store.Write(MakeTuple(S("job"), I(i)), Forever)
+		store.Write(MakeTuple(S("other"), I(i)), Forever)
+	}
+
+	template := MakeTuple(S("job"), Any())
+	var seen []Tuple
+	cursor := ""
+	for pages := 1; ; pages++ {
+		tuples, next, err := store.Scan(template, cursor, 10)
+		if err != nil {
+			t.Fatalf("Scan: %v", err)
+		}
+		seen = append(seen, tuples...)
+		if next == "" {
+			if pages != 3 {
+				t.Errorf("got %d pages, want 3", pages)
+			}
+			break
+		}
+		cursor = next
+	}
+
+	if len(seen) != 25 {
+		t.Fatalf("got %d tuples, want 25", len(seen))
+	}
+	for i, tuple := range seen {
+		if !tuple.IsMatching(MakeTuple(S("job"), I(i))) {
+			t.Errorf("tuple %d: got %s", i, tuple)
+		}
+	}
+
+	if all, next, _ := store.Scan(MakeTuple(), "", 0); len(all) != 50 || next != "" {
+		t.Errorf("Scan without template and limit: got %d tuples and cursor %q, want 50", len(all), next)
+	}
+	if _, _, err := store.Scan(template, "not a cursor!", 10); err == nil {
+		t.Error("Scan with an invalid cursor succeeded")
+	}
+}
+
+func TestScanCopy(t *testing.T) {
+	store := NewSimpleStore()
+	for i := 0; i < 5; i++ {
+		store.Write(MakeTuple(S("job"), I(i)), Forever)
+	}
+
+	snapshot := store.Copy()
+	store.Get(MakeTuple(S("job"), I(0)))
+	store.Write(MakeTuple(S("job"), I(10)), Forever)
+
+	tuples, _, _ := snapshot.Scan(MakeTuple(), "", 0)
+	if got := join(tuples); got != `("job"|0) ("job"|1) ("job"|2) ("job"|3) ("job"|4)` {
+		t.Errorf("the copy changed with the store: %s", got)
+	}
+}
+
+func TestTupleOrder(t *testing.T) {
+	// In ascending order: tuples < strings < floats < ints, and by value within a type
+	ordered := []Tuple{
+		MakeTuple(T(MakeTuple(I(1)))),
+		MakeTuple(S("a")),
+		MakeTuple(S("b")),
+		MakeTuple(F(-1.5)),
+		MakeTuple(F(2.5)),
+		MakeTuple(I(-3)),
+		MakeTuple(I(4)),
+		MakeTuple(I(4), I(0)),
+	}
+	for i := range ordered {
+		for j := range ordered {
+			if got, want := TupleOrder(ordered[i], ordered[j]), i < j; got != want {
+				t.Errorf("TupleOrder(%s, %s): got %v, want %v", ordered[i], ordered[j], got, want)
+			}
+		}
+	}
+}
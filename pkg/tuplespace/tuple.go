@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"math"
+	"strconv"
 	"strings"
 )
 
@@ -60,17 +61,34 @@ func (e Elem) GetValue() interface{} {
 	return e.elemValue
 }
 
+// Names of the types in typed formals
+var typeNames = map[TupleElement]string{
+	INT:    "int",
+	FLOAT:  "float",
+	STRING: "string",
+	TUPLE:  "tuple",
+}
+
+// String returns the element in the notation read by `Parse`.
 func (e Elem) String() string {
 	switch e.elemType {
 	case INT:
-		return fmt.Sprintf("%v", e.elemValue.(int))
+		return strconv.Itoa(e.elemValue.(int))
 	case FLOAT:
-		return fmt.Sprintf("%v", e.elemValue.(float64))
+		// Always print a fraction or exponent, so that the number reads back as a float
+		f := strconv.FormatFloat(e.elemValue.(float64), 'g', -1, 64)
+		if !strings.ContainsAny(f, ".eIN") {
+			f += ".0"
+		}
+		return f
 	case STRING:
-		return fmt.Sprintf("\"%v\"", e.elemValue.(string))
+		return strconv.Quote(e.elemValue.(string))
 	case TUPLE:
 		return e.elemValue.(Tuple).String()
 	case ANY:
+		if elemType, typed := e.elemValue.(TupleElement); typed {
+			return "?" + typeNames[elemType]
+		}
 		return "_"
 	case NONE:
 		return "nil"
@@ -106,6 +124,11 @@ func Any() Elem {
 	return Elem{ANY, nil}
 }
 
+// Formal instantiates a typed wildcard, which matches any element of the given type.
+func Formal(elemType TupleElement) Elem {
+	return Elem{ANY, elemType}
+}
+
 func None() Elem {
 	return Elem{NONE, nil}
 }
@@ -153,11 +176,17 @@ func (e Elem) isMatching(other Elem) bool {
 	}
 
 	if e.elemType == ANY || other.elemType == ANY {
-		return true
+		return e.admits(other) && other.admits(e)
 	}
 	return false
 }
 
+// Returns false if the element is a typed formal and the other element a value of another type.
+func (e Elem) admits(other Elem) bool {
+	elemType, typed := e.elemValue.(TupleElement)
+	return !typed || other.elemType == ANY || other.elemType == elemType
+}
+
 // Comparator function, used for determining ordering of two elements.
 // The order between elements of different type is arbitrary, but consistent.
 // ANY < tuple < string < double < int < nil
@@ -207,8 +236,7 @@ func (e Elem) order(other Elem) int {
 		switch other.elemType {
 		case ANY:
 			return EQ
-		case TUPLE:
-		case STRING:
+		case TUPLE, STRING:
 			return GT
 		case FLOAT:
 			if e.elemValue.(float64) < other.elemValue.(float64) {
@@ -236,10 +264,8 @@ func (e Elem) order(other Elem) int {
 			}
 			return GT
 		}
-		if other.elemType == NONE {
-			return GT
-		}
-		return LT
+		// int is the greatest defined type
+		return GT
 	default:
 		return LT
 	}
@@ -477,10 +503,16 @@ func (el Elem) MarshalJSON() ([]byte, error) {
 	case FLOAT:
 		//value = strconv.FormatFloat(el.elemValue.(float64), 'f', -1, 64)
 		value = el.elemValue.(float64)
+		// JSON has no infinities or NaN, so those are sent as strings
+		if f := el.elemValue.(float64); math.IsInf(f, 0) || math.IsNaN(f) {
+			value = el.String()
+		}
 	case STRING:
 		value = el.elemValue.(string)
+	case TUPLE:
+		value = el.elemValue.(Tuple)
 	case ANY:
-		value = "_"
+		value = el.String()
 	}
 
 	elem := map[string]interface{}{
@@ -491,21 +523,70 @@ func (el Elem) MarshalJSON() ([]byte, error) {
 }
 
 func (el *Elem) UnmarshalJSON(data []byte) error {
-	var elem map[string]interface{}
+	var elem struct {
+		Type  TupleElement    `json:"type"`
+		Value json.RawMessage `json:"value"`
+	}
 	if err := json.Unmarshal(data, &elem); err != nil {
 		return err
 	}
 
-	switch TupleElement(elem["type"].(float64)) {
+	switch elem.Type {
 	case INT:
-		*el = I(int(elem["value"].(float64)))
+		var value int
+		if err := json.Unmarshal(elem.Value, &value); err != nil {
+			return err
+		}
+		*el = I(value)
 	case FLOAT:
-		*el = F(elem["value"].(float64))
+		var value float64
+		if err := json.Unmarshal(elem.Value, &value); err != nil {
+			var special string
+			if json.Unmarshal(elem.Value, &special) != nil {
+				return err
+			}
+			if value, err = strconv.ParseFloat(special, 64); err != nil {
+				return err
+			}
+		}
+		*el = F(value)
 	case STRING:
-		*el = S(elem["value"].(string))
+		var value string
+		if err := json.Unmarshal(elem.Value, &value); err != nil {
+			return err
+		}
+		*el = S(value)
+	case TUPLE:
+		var value Tuple
+		if err := json.Unmarshal(elem.Value, &value); err != nil {
+			return err
+		}
+		*el = T(value)
 	case ANY:
+		var value string
+		json.Unmarshal(elem.Value, &value)
 		*el = Any()
+		for elemType, typeName := range typeNames {
+			if value == "?"+typeName {
+				*el = Formal(elemType)
+			}
+		}
+	case NONE:
+		*el = None()
+	default:
+		return fmt.Errorf("invalid elem type %d", elem.Type)
 	}
 
 	return nil
 }
+
+func (t Tuple) MarshalJSON() ([]byte, error) {
+	if t.elements == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(t.elements)
+}
+
+func (t *Tuple) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &t.elements)
+}
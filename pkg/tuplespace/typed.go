@@ -0,0 +1,163 @@
+package tuplespace
+
+import "fmt"
+
+// Field is the constraint of the Go types of typed template fields.
+type Field interface {
+	int | float64 | string | Tuple
+}
+
+// Pattern is a field of a typed template: either a value, see `Is`, or a formal matching any
+// value of type T, which is also the zero Pattern.
+type Pattern[T Field] struct {
+	value T
+	set   bool
+}
+
+// Is returns the pattern matching the value.
+func Is[T Field](value T) Pattern[T] {
+	return Pattern[T]{value: value, set: true}
+}
+
+// AnyOf returns the pattern matching any value of type T.
+func AnyOf[T Field]() Pattern[T] {
+	return Pattern[T]{}
+}
+
+func (p Pattern[T]) elem() Elem {
+	if !p.set {
+		return Formal(fieldType[T]())
+	}
+	return fieldElem(p.value)
+}
+
+// Typed templates hold a template tuple whose fields have the types given as type parameters.
+// The tuple returned by `Tuple()` is passed to the lookups of `Space`, `store.Store` or the
+// client library, and `Extract` reads the fields of a match back into Go values:
+//
+//	jobs := ts.NewTemplate3(ts.Is("job"), ts.AnyOf[int](), ts.AnyOf[float64]())
+//	job, err := c.In(ctx, jobs.Tuple())
+//	...
+//	_, id, weight, err := jobs.Extract(job)
+
+// Template2 is a typed template of tuples with two fields.
+type Template2[A, B Field] struct {
+	template Tuple
+}
+
+// NewTemplate2 returns the typed template with the given fields.
+func NewTemplate2[A, B Field](a Pattern[A], b Pattern[B]) Template2[A, B] {
+	return Template2[A, B]{MakeTuple(a.elem(), b.elem())}
+}
+
+// Tuple returns the template as a tuple.
+func (t Template2[A, B]) Tuple() Tuple {
+	return t.template
+}
+
+// Match returns true if the tuple matches the template.
+func (t Template2[A, B]) Match(tuple Tuple) bool {
+	return t.template.IsMatching(tuple)
+}
+
+// Extract returns the fields of a tuple matching the template.
+func (t Template2[A, B]) Extract(tuple Tuple) (a A, b B, err error) {
+	if err = checkMatch(t.template, tuple); err != nil {
+		return
+	}
+	elements := tuple.GetElements()
+	return fieldValue[A](elements[0]), fieldValue[B](elements[1]), nil
+}
+
+// Template3 is a typed template of tuples with three fields.
+type Template3[A, B, C Field] struct {
+	template Tuple
+}
+
+// NewTemplate3 returns the typed template with the given fields.
+func NewTemplate3[A, B, C Field](a Pattern[A], b Pattern[B], c Pattern[C]) Template3[A, B, C] {
+	return Template3[A, B, C]{MakeTuple(a.elem(), b.elem(), c.elem())}
+}
+
+// Tuple returns the template as a tuple.
+func (t Template3[A, B, C]) Tuple() Tuple {
+	return t.template
+}
+
+// Match returns true if the tuple matches the template.
+func (t Template3[A, B, C]) Match(tuple Tuple) bool {
+	return t.template.IsMatching(tuple)
+}
+
+// Extract returns the fields of a tuple matching the template.
+func (t Template3[A, B, C]) Extract(tuple Tuple) (a A, b B, c C, err error) {
+	if err = checkMatch(t.template, tuple); err != nil {
+		return
+	}
+	elements := tuple.GetElements()
+	return fieldValue[A](elements[0]), fieldValue[B](elements[1]), fieldValue[C](elements[2]), nil
+}
+
+// Template4 is a typed template of tuples with four fields.
+type Template4[A, B, C, D Field] struct {
+	template Tuple
+}
+
+// NewTemplate4 returns the typed template with the given fields.
+func NewTemplate4[A, B, C, D Field](a Pattern[A], b Pattern[B], c Pattern[C], d Pattern[D]) Template4[A, B, C, D] {
+	return Template4[A, B, C, D]{MakeTuple(a.elem(), b.elem(), c.elem(), d.elem())}
+}
+
+// Tuple returns the template as a tuple.
+func (t Template4[A, B, C, D]) Tuple() Tuple {
+	return t.template
+}
+
+// Match returns true if the tuple matches the template.
+func (t Template4[A, B, C, D]) Match(tuple Tuple) bool {
+	return t.template.IsMatching(tuple)
+}
+
+// Extract returns the fields of a tuple matching the template.
+func (t Template4[A, B, C, D]) Extract(tuple Tuple) (a A, b B, c C, d D, err error) {
+	if err = checkMatch(t.template, tuple); err != nil {
+		return
+	}
+	elements := tuple.GetElements()
+	return fieldValue[A](elements[0]), fieldValue[B](elements[1]), fieldValue[C](elements[2]), fieldValue[D](elements[3]), nil
+}
+
+// Since every field of a typed template is typed, a tuple matching it holds values of the
+// template's types in every field.
+func checkMatch(template, tuple Tuple) error {
+	if !template.IsMatching(tuple) {
+		return fmt.Errorf("tuplespace: %s does not match %s", tuple, template)
+	}
+	return nil
+}
+
+// Returns the element of a field value.
+func fieldElem[V Field](value V) Elem {
+	switch v := any(value).(type) {
+	case int:
+		return I(v)
+	case float64:
+		return F(v)
+	case string:
+		return S(v)
+	default:
+		return T(any(value).(Tuple))
+	}
+}
+
+// Returns the element type of V.
+func fieldType[V Field]() TupleElement {
+	var zero V
+	return fieldElem(zero).GetType()
+}
+
+// Returns the value of an element of type V.
+func fieldValue[V Field](e Elem) V {
+	value, _ := e.GetValue().(V)
+	return value
+}
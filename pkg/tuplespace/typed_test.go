@@ -0,0 +1,80 @@
+package tuplespace
+
+import "testing"
+
+func TestTypedTemplateMatching(t *testing.T) {
+	jobs := NewTemplate3(Is("job"), AnyOf[int](), AnyOf[float64]())
+	if got, want := jobs.Tuple().String(), MakeTuple(S("job"), Formal(INT), Formal(FLOAT)).String(); got != want {
+		t.Errorf("got template %s, want %s", got, want)
+	}
+
+	tests := []struct {
+		tuple Tuple
+		match bool
+	}{
+		{MakeTuple(S("job"), I(1), F(0.5)), true},
+		{MakeTuple(S("task"), I(1), F(0.5)), false},      // Other value
+		{MakeTuple(S("job"), S("1"), F(0.5)), false},     // Other type
+		{MakeTuple(S("job"), I(1), I(2)), false},         // Int for a float
+		{MakeTuple(S("job"), I(1)), false},               // Fewer fields
+		{MakeTuple(S("job"), I(1), F(0.5), I(2)), false}, // More fields
+	}
+	for _, test := range tests {
+		if got := jobs.Match(test.tuple); got != test.match {
+			t.Errorf("Match(%s): got %v, want %v", test.tuple, got, test.match)
+		}
+	}
+}
+
+func TestTypedTemplateExtract(t *testing.T) {
+	pairs := NewTemplate2(AnyOf[string](), AnyOf[Tuple]())
+	name, args, err := pairs.Extract(MakeTuple(S("add"), T(MakeTuple(I(1), I(2)))))
+	if err != nil || name != "add" || args.String() != MakeTuple(I(1), I(2)).String() {
+		t.Errorf("Template2: got %q, %s, %v", name, args, err)
+	}
+
+	jobs := NewTemplate3(Is("job"), AnyOf[int](), AnyOf[float64]())
+	tag, id, weight, err := jobs.Extract(MakeTuple(S("job"), I(7), F(2.5)))
+	if err != nil || tag != "job" || id != 7 || weight != 2.5 {
+		t.Errorf("Template3: got %q, %d, %v, %v", tag, id, weight, err)
+	}
+
+	moves := NewTemplate4(Is("move"), AnyOf[string](), Is(3), AnyOf[float64]())
+	_, who, steps, speed, err := moves.Extract(MakeTuple(S("move"), S("rook"), I(3), F(1)))
+	if err != nil || who != "rook" || steps != 3 || speed != 1 {
+		t.Errorf("Template4: got %q, %d, %v, %v", who, steps, speed, err)
+	}
+}
+
+func TestTypedTemplateExtractMismatch(t *testing.T) {
+	jobs := NewTemplate3(Is("job"), AnyOf[int](), AnyOf[float64]())
+	for _, tuple := range []Tuple{
+		MakeTuple(S("job"), F(1), F(0.5)),
+		MakeTuple(S("job"), I(1)),
+		MakeTuple(S("task"), I(1), F(0.5)),
+	} {
+		tag, id, weight, err := jobs.Extract(tuple)
+		if err == nil {
+			t.Errorf("Extract(%s): got no error", tuple)
+		}
+		if tag != "" || id != 0 || weight != 0 {
+			t.Errorf("Extract(%s): got %q, %d, %v, want zero values", tuple, tag, id, weight)
+		}
+	}
+}
+
+func TestPattern(t *testing.T) {
+	if got := AnyOf[string]().elem(); got != Formal(STRING) {
+		t.Errorf("AnyOf[string]: got %s, want a string formal", got)
+	}
+	var zero Pattern[int]
+	if got := zero.elem(); got != Formal(INT) {
+		t.Errorf("zero Pattern[int]: got %s, want an int formal", got)
+	}
+	if got := AnyOf[Tuple]().elem(); got != Formal(TUPLE) {
+		t.Errorf("AnyOf[Tuple]: got %s, want a tuple formal", got)
+	}
+	if got := Is(0).elem(); got != I(0) {
+		t.Errorf("Is(0): got %s, want the value 0", got)
+	}
+}
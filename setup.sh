@@ -4,10 +4,10 @@ export NODES=3
 export START_SERVER_PORT=11000
 export START_RAFT_PORT=15000
 
-go build cmd/main.go
-mv main bin/main
+go build -o bin/main ./cmd
 
-go build client/main.go
-mv main bin/client
+go build -o bin/client ./client
+
+go build -o bin/tsctl ./cmd/tsctl
 
 chmod +x ./run.sh
\ No newline at end of file
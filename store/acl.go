@@ -0,0 +1,449 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	tuplespace "tuplespaceCD/pkg/tuplespace"
+)
+
+// Access control. Principals are authenticated by a token, of which the replicated policy only
+// holds a hash, and are granted rights on spaces and tags. The first principal, an admin, is
+// added by `Bootstrap` from the configuration of a node. Until then tuple commands are open,
+// while admin commands are refused, as they are to `Anonymous` clients at any time. Commands
+// are checked on the leader before they are proposed, and only those made on behalf of a
+// principal, see `As`, so the applications running inside the server are trusted.
+
+// Right is what a grant allows.
+type Right string
+
+// Rights
+const (
+	RightOut   Right = "out"   // Write tuples and renew their leases
+	RightIn    Right = "in"    // Take tuples, or cancel them
+	RightRd    Right = "rd"    // Read and count tuples
+	RightAdmin Right = "admin" // Change the spaces, schemas and the policy itself
+)
+
+// Principal names with a special meaning
+const (
+	Anonymous = "anonymous" // Clients that send no token
+	Everyone  = "*"         // Grants to it apply to every principal, including `Anonymous`
+)
+
+// ErrPermissionDenied is returned when the principal of a command lacks a right it needs.
+var ErrPermissionDenied = errors.New("permission denied")
+
+// ErrUnknownToken is returned by `Authenticate` for tokens of no principal.
+var ErrUnknownToken = errors.New("unknown token")
+
+// Grant gives a principal rights on the tuples of a space whose tag, their first field, matches
+// a pattern. Templates whose first field is not a string only match the pattern "*".
+type Grant struct {
+	Principal string  `json:"principal"` // Name of the principal, or `Everyone`
+	Rights    []Right `json:"rights"`
+	Space     string  `json:"space"` // Name of the space, or "*" for all
+	Tag       string  `json:"tag"`   // Pattern of the tags as for `path.Match`, "*" for all
+}
+
+func (g Grant) String() string {
+	rights := make([]string, len(g.Rights))
+	for i, right := range g.Rights {
+		rights[i] = string(right)
+	}
+	return fmt.Sprintf("%s may %s in space %s on tags %s", g.Principal, strings.Join(rights, ","), g.Space, g.Tag)
+}
+
+// Returns true if the grant covers the principal, right, space and tag.
+func (g Grant) allows(principal string, right Right, space, tag string) bool {
+	if g.Principal != principal && g.Principal != Everyone {
+		return false
+	}
+	if !hasRight(g.Rights, right) {
+		return false
+	}
+	if right == RightAdmin {
+		return true
+	}
+	if g.Space != "*" && g.Space != space {
+		return false
+	}
+	matched, _ := path.Match(g.Tag, tag)
+	return g.Tag == "*" || matched
+}
+
+func hasRight(rights []Right, right Right) bool {
+	for _, r := range rights {
+		if r == right {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseRights parses a comma-separated list of rights, e.g. "out,rd".
+func ParseRights(list string) ([]Right, error) {
+	var rights []Right
+	for _, name := range strings.Split(list, ",") {
+		right := Right(strings.TrimSpace(name))
+		switch right {
+		case RightOut, RightIn, RightRd, RightAdmin:
+			rights = append(rights, right)
+		default:
+			return nil, fmt.Errorf("unknown right %q, must be out, in, rd or admin", right)
+		}
+	}
+	return rights, nil
+}
+
+// A principal as stored in the policy.
+type principal struct {
+	Name      string `json:"name"`
+	TokenHash string `json:"token_hash"`
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// As makes the command run on behalf of the principal, whose rights are checked before it is
+// proposed.
+func As(principal string) Option {
+	return func(c *command) {
+		c.As = principal
+	}
+}
+
+// Authenticate returns the principal of the token, or `Anonymous` if it is empty or there is
+// no policy to enforce yet.
+func (s *Store) Authenticate(token string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if token == "" || len(s.principals) == 0 {
+		return Anonymous, nil
+	}
+	hash := hashToken(token)
+	for name, tokenHash := range s.principals {
+		if tokenHash == hash {
+			return name, nil
+		}
+	}
+	return "", ErrUnknownToken
+}
+
+// authorize checks that the principal of the command, if any, has every right it needs.
+func (s *Store) authorize(c *command) error {
+	if c.As == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.authorizeOp(c, spaceName(c.Space))
+}
+
+// The caller holds the lock.
+func (s *Store) authorizeOp(c *command, space string) error {
+	if space == SystemSpace {
+		return fmt.Errorf("%w: the %s space is reserved to the server", ErrPermissionDenied, SystemSpace)
+	}
+	tuple := tuplespace.MakeTuple(c.Tuple...)
+
+	switch c.Op {
+	case "write", "renew":
+		return s.check(c.As, RightOut, space, tuple)
+	case "get", "getall", "cancel":
+		return s.check(c.As, RightIn, space, tuple)
+	case "read", "readall", "count", "notify", "absent":
+		return s.check(c.As, RightRd, space, tuple)
+	case "update":
+		if err := s.check(c.As, RightIn, space, tuple); err != nil {
+			return err
+		}
+		return s.check(c.As, RightOut, space, tuple)
+	case "writemany":
+		for _, elements := range c.Tuples {
+			if err := s.check(c.As, RightOut, space, tuplespace.MakeTuple(elements...)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "tx":
+		for _, op := range c.Ops {
+			op.As = c.As
+			if err := s.authorizeOp(&op, opSpace(op, space)); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return s.check(c.As, RightAdmin, space, tuple)
+	}
+}
+
+// The caller holds the lock.
+func (s *Store) check(principal string, right Right, space string, tuple tuplespace.Tuple) error {
+	if right == RightAdmin && principal == Anonymous {
+		return fmt.Errorf("%w: %s clients are no admins, send the token of one", ErrPermissionDenied, Anonymous)
+	}
+	if len(s.principals) == 0 {
+		return nil
+	}
+
+	tag := tagOf(tuple)
+	for _, grant := range s.grants {
+		if grant.allows(principal, right, space, tag) {
+			return nil
+		}
+	}
+	if right == RightAdmin {
+		return fmt.Errorf("%w: %s is no admin", ErrPermissionDenied, principal)
+	}
+	return fmt.Errorf("%w: %s may not %s %s in space %q", ErrPermissionDenied, principal, right, tuple, space)
+}
+
+// mayUse returns true if the principal has a right on the tag in the space, either of which may
+// be "*" for any. Admins may use every space but the system one. The caller holds the lock.
+func (s *Store) mayUse(principal, space, tag string) bool {
+	if space == SystemSpace {
+		return false
+	}
+	if len(s.principals) == 0 {
+		return true
+	}
+	for _, grant := range s.grants {
+		if grant.Principal != principal && grant.Principal != Everyone {
+			continue
+		}
+		if hasRight(grant.Rights, RightAdmin) {
+			return true
+		}
+		matched, _ := path.Match(grant.Tag, tag)
+		if (grant.Space == "*" || space == "*" || grant.Space == space) && (grant.Tag == "*" || tag == "*" || matched) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetPrincipal adds a principal authenticated by the token, or replaces its token.
+func (s *Store) SetPrincipal(name, token string, opts ...Option) error {
+	if err := checkPrincipal(name, token); err != nil {
+		return err
+	}
+	_, err := s.apply(&command{Op: "principal", Principal: &principal{Name: name, TokenHash: hashToken(token)}}, opts)
+	return err
+}
+
+// Bootstrap adds the first principal, granted `RightAdmin` on everything, which turns on
+// enforcement. Nodes call it with the admin token of their configuration; it does nothing once
+// the policy has a principal, so every node may do so. Returns `ErrNotLeader` on followers
+// that have not yet applied the first principal.
+func (s *Store) Bootstrap(name, token string) error {
+	if err := checkPrincipal(name, token); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	bootstrapped := len(s.principals) > 0
+	s.mu.Unlock()
+	if bootstrapped {
+		return nil
+	}
+	_, err := s.apply(&command{Op: "bootstrap", Principal: &principal{Name: name, TokenHash: hashToken(token)}}, nil)
+	return err
+}
+
+func checkPrincipal(name, token string) error {
+	if name == "" || name == Anonymous || name == Everyone || token == "" {
+		return fmt.Errorf("a principal needs a token and a name other than %q and %q", Anonymous, Everyone)
+	}
+	return nil
+}
+
+// DropPrincipal removes a principal and its grants. Returns `false` if there is none.
+func (s *Store) DropPrincipal(name string, opts ...Option) (bool, error) {
+	return s.applyBool(&command{Op: "dropprincipal", Principal: &principal{Name: name}}, opts)
+}
+
+// Grant adds the rights of the grant to those the principal has on its space and tag pattern.
+// An empty space or pattern stands for "*".
+func (s *Store) Grant(grant Grant, opts ...Option) error {
+	grant, err := normalizeGrant(grant)
+	if err != nil {
+		return err
+	}
+	_, err = s.apply(&command{Op: "grant", Grant: &grant}, opts)
+	return err
+}
+
+// Revoke removes the rights of the grant from those the principal has on exactly its space and
+// tag pattern. Returns `false` if it had none of them.
+func (s *Store) Revoke(grant Grant, opts ...Option) (bool, error) {
+	grant, err := normalizeGrant(grant)
+	if err != nil {
+		return false, err
+	}
+	return s.applyBool(&command{Op: "revoke", Grant: &grant}, opts)
+}
+
+func normalizeGrant(grant Grant) (Grant, error) {
+	if grant.Space == "" {
+		grant.Space = "*"
+	}
+	if grant.Tag == "" {
+		grant.Tag = "*"
+	}
+	if grant.Principal == "" || len(grant.Rights) == 0 {
+		return grant, fmt.Errorf("a grant needs a principal and rights")
+	}
+	if _, err := path.Match(grant.Tag, ""); err != nil {
+		return grant, fmt.Errorf("invalid tag pattern %q: %s", grant.Tag, err)
+	}
+	return grant, nil
+}
+
+// Policy returns the principals and grants of this replica, in order. Only admins may read it.
+func (s *Store) Policy(opts ...Option) ([]string, []Grant, error) {
+	c := &command{Op: "policy"}
+	for _, option := range opts {
+		option(c)
+	}
+	if err := s.authorize(c); err != nil {
+		return nil, nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sortedNames(s.principals), append([]Grant(nil), s.grants...), nil
+}
+
+// checkAdmin returns an error if principals are left without an admin to manage them.
+func checkAdmin(principals map[string]string, grants []Grant) error {
+	if len(principals) == 0 {
+		return nil
+	}
+	for _, grant := range grants {
+		if hasRight(grant.Rights, RightAdmin) {
+			return nil
+		}
+	}
+	return fmt.Errorf("the policy must keep an admin while it has principals")
+}
+
+func (f *fsm) applySetPrincipal(p principal) interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for name, tokenHash := range f.principals {
+		if tokenHash == p.TokenHash && name != p.Name {
+			return fmt.Errorf("the token belongs to %s already", name)
+		}
+	}
+	f.principals[p.Name] = p.TokenHash
+	return true
+}
+
+func (f *fsm) applyBootstrap(p principal) interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.principals) > 0 {
+		return false
+	}
+	f.principals[p.Name] = p.TokenHash
+	f.grants = append(f.grants, Grant{Principal: p.Name, Rights: []Right{RightAdmin}, Space: "*", Tag: "*"})
+	return true
+}
+
+func (f *fsm) applyDropPrincipal(name string) interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, found := f.principals[name]; !found {
+		return false
+	}
+	principals := make(map[string]string, len(f.principals))
+	for other, tokenHash := range f.principals {
+		if other != name {
+			principals[other] = tokenHash
+		}
+	}
+	var grants []Grant
+	for _, grant := range f.grants {
+		if grant.Principal != name {
+			grants = append(grants, grant)
+		}
+	}
+	if err := checkAdmin(principals, grants); err != nil {
+		return err
+	}
+	f.principals, f.grants = principals, grants
+	return true
+}
+
+func (f *fsm) applyGrant(grant Grant) interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i, existing := range f.grants {
+		if existing.Principal == grant.Principal && existing.Space == grant.Space && existing.Tag == grant.Tag {
+			rights := append([]Right(nil), existing.Rights...)
+			for _, right := range grant.Rights {
+				if !hasRight(rights, right) {
+					rights = append(rights, right)
+				}
+			}
+			f.grants[i].Rights = rights
+			return true
+		}
+	}
+	f.grants = append(f.grants, grant)
+	return true
+}
+
+func (f *fsm) applyRevoke(revoked Grant) interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	found := false
+	var grants []Grant
+	for _, grant := range f.grants {
+		if grant.Principal == revoked.Principal && grant.Space == revoked.Space && grant.Tag == revoked.Tag {
+			var rights []Right
+			for _, right := range grant.Rights {
+				if hasRight(revoked.Rights, right) {
+					found = true
+				} else {
+					rights = append(rights, right)
+				}
+			}
+			if len(rights) == 0 {
+				continue
+			}
+			grant.Rights = rights
+		}
+		grants = append(grants, grant)
+	}
+	if err := checkAdmin(f.principals, grants); err != nil {
+		return err
+	}
+	f.grants = grants
+	return found
+}
+
+// Returns the principals in order, for snapshots.
+func sortedPrincipals(principals map[string]string) []principal {
+	list := make([]principal, 0, len(principals))
+	for name, tokenHash := range principals {
+		list = append(list, principal{Name: name, TokenHash: tokenHash})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
@@ -0,0 +1,260 @@
+package store
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	tuplespace "tuplespaceCD/pkg/tuplespace"
+)
+
+func TestGrantAllows(t *testing.T) {
+	grant := Grant{Principal: "alice", Rights: []Right{RightOut, RightRd}, Space: "jobs", Tag: "job-*"}
+	tests := []struct {
+		principal string
+		right     Right
+		space     string
+		tag       string
+		allowed   bool
+	}{
+		{"alice", RightOut, "jobs", "job-1", true},
+		{"alice", RightRd, "jobs", "job-", true},
+		{"alice", RightIn, "jobs", "job-1", false},
+		{"alice", RightOut, "default", "job-1", false},
+		{"alice", RightOut, "jobs", "task", false},
+		{"alice", RightOut, "jobs", "", false}, // No string tag
+		{"bob", RightOut, "jobs", "job-1", false},
+		{"alice", RightAdmin, "jobs", "job-1", false},
+	}
+	for _, test := range tests {
+		if got := grant.allows(test.principal, test.right, test.space, test.tag); got != test.allowed {
+			t.Errorf("%s: %s %s %q in %s: got %v, want %v", grant, test.principal, test.right, test.tag, test.space, got, test.allowed)
+		}
+	}
+
+	everyone := Grant{Principal: Everyone, Rights: []Right{RightRd}, Space: "*", Tag: "*"}
+	for _, principal := range []string{Anonymous, "alice"} {
+		if !everyone.allows(principal, RightRd, "jobs", "") {
+			t.Errorf("%s does not allow %s to read", everyone, principal)
+		}
+	}
+}
+
+func TestParseRights(t *testing.T) {
+	if rights, err := ParseRights("out, rd"); err != nil || !reflect.DeepEqual(rights, []Right{RightOut, RightRd}) {
+		t.Errorf("got %v, %v", rights, err)
+	}
+	if _, err := ParseRights("out,write"); err == nil {
+		t.Error("an unknown right was accepted")
+	}
+}
+
+func TestAccessControl(t *testing.T) {
+	s := newTestStore(t)
+	job := tuplespace.MakeTuple(tuplespace.S("job"), tuplespace.I(1))
+	anyJob := tuplespace.MakeTuple(tuplespace.S("job"), tuplespace.Any())
+
+	// Until there is a principal the cluster is open
+	if principal, err := s.Authenticate("whatever"); principal != Anonymous || err != nil {
+		t.Errorf("Authenticate without policy: got %q, %v, want anonymous", principal, err)
+	}
+	if err := s.Write(job, tuplespace.Forever, As(Anonymous)); err != nil {
+		t.Errorf("Write without policy: %v", err)
+	}
+
+	// Admin commands are refused to anonymous clients even before the policy is bootstrapped
+	if err := s.SetPrincipal("root", "root-token", As(Anonymous)); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("SetPrincipal by anonymous before the bootstrap: got %v, want permission denied", err)
+	}
+	if _, err := s.CreateSpace("mine", As(Anonymous)); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("CreateSpace by anonymous before the bootstrap: got %v, want permission denied", err)
+	}
+
+	// The bootstrapped principal is the admin, and bootstrapping again does nothing
+	if err := s.Bootstrap("root", "root-token"); err != nil {
+		t.Fatalf("Bootstrap: %v", err)
+	}
+	if err := s.Bootstrap("other", "other-token"); err != nil {
+		t.Errorf("Bootstrap again: %v", err)
+	}
+	if principal, err := s.Authenticate("root-token"); principal != "root" || err != nil {
+		t.Errorf("Authenticate: got %q, %v, want root", principal, err)
+	}
+	if _, err := s.Authenticate("other-token"); !errors.Is(err, ErrUnknownToken) {
+		t.Errorf("Authenticate with an unknown token: got %v", err)
+	}
+	if principal, _ := s.Authenticate(""); principal != Anonymous {
+		t.Errorf("Authenticate without a token: got %q, want anonymous", principal)
+	}
+
+	if err := s.SetPrincipal("alice", "alice-token", As(Anonymous)); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("SetPrincipal by anonymous: got %v, want permission denied", err)
+	}
+	if err := s.SetPrincipal("alice", "alice-token", As("root")); err != nil {
+		t.Fatalf("SetPrincipal: %v", err)
+	}
+	if err := s.SetPrincipal("bob", "alice-token", As("root")); err == nil {
+		t.Error("two principals share a token")
+	}
+	if err := s.Grant(Grant{Principal: "alice", Rights: []Right{RightRd, RightIn}, Tag: "job"}, As("root")); err != nil {
+		t.Fatalf("Grant: %v", err)
+	}
+
+	// Commands on behalf of a principal are checked, those without one are trusted
+	checks := []struct {
+		name    string
+		run     func() error
+		allowed bool
+	}{
+		{"anonymous read", func() error { _, err := s.Read(anyJob, As(Anonymous)); return err }, false},
+		{"alice read", func() error { _, err := s.Read(anyJob, As("alice")); return err }, true},
+		{"alice count", func() error { _, err := s.Count(anyJob, As("alice")); return err }, true},
+		{"alice scan", func() error { _, _, err := s.Scan(anyJob, "", 10, As("alice")); return err }, true},
+		{"alice write", func() error { return s.Write(job, tuplespace.Forever, As("alice")) }, false},
+		{"alice read other tag", func() error { _, err := s.Read(anyAccount("task"), As("alice")); return err }, false},
+		{"alice scan other tag", func() error { _, _, err := s.Scan(anyAccount("task"), "", 10, As("alice")); return err }, false},
+		{"alice update", func() error {
+			_, err := s.With(As("alice")).UpdateFields(anyJob, tuplespace.Forever, AddField(1, tuplespace.I(1)))
+			return err
+		}, false},
+		{"alice tx writing", func() error {
+			_, err := s.With(As("alice")).Transact(GetOp(anyJob), WriteOp(job, tuplespace.Forever))
+			return err
+		}, false},
+		{"alice create space", func() error { _, err := s.CreateSpace("mine", As("alice")); return err }, false},
+		{"alice policy", func() error { _, _, err := s.Policy(As("alice")); return err }, false},
+		{"root write", func() error { return s.Write(job, tuplespace.Forever, As("root")) }, false}, // Admin is no tuple right
+		{"trusted write", func() error { return s.Write(account("job", 2), tuplespace.Forever) }, true},
+		{"alice notify", func() error {
+			registration, err := s.Notify(anyJob, tuplespace.Forever, func(tuplespace.Event) {}, As("alice"))
+			if err == nil {
+				registration.Cancel()
+			}
+			return err
+		}, true},
+		{"anonymous notify", func() error {
+			_, err := s.Notify(anyJob, tuplespace.Forever, func(tuplespace.Event) {}, As(Anonymous))
+			return err
+		}, false},
+		{"alice take", func() error { _, err := s.Get(anyJob, As("alice")); return err }, true},
+	}
+	for _, check := range checks {
+		err := check.run()
+		if check.allowed && err != nil {
+			t.Errorf("%s: %v", check.name, err)
+		}
+		if !check.allowed && !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("%s: got %v, want permission denied", check.name, err)
+		}
+	}
+	if n, _ := s.Count(anyJob); n != 1 {
+		t.Errorf("got %d jobs, want 1: the denied commands must not be applied", n)
+	}
+
+	if revoked, err := s.Revoke(Grant{Principal: "alice", Rights: []Right{RightIn}, Tag: "job"}, As("root")); !revoked || err != nil {
+		t.Errorf("Revoke: got %v, %v", revoked, err)
+	}
+	if _, err := s.Get(anyJob, As("alice")); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("Get after the revoke: got %v, want permission denied", err)
+	}
+	if _, err := s.Revoke(Grant{Principal: "root", Rights: []Right{RightAdmin}}, As("root")); err == nil {
+		t.Error("the last admin right was revoked")
+	}
+	if _, err := s.DropPrincipal("root", As("root")); err == nil {
+		t.Error("the last admin was dropped")
+	}
+	if dropped, err := s.DropPrincipal("alice", As("root")); !dropped || err != nil {
+		t.Errorf("DropPrincipal: got %v, %v", dropped, err)
+	}
+	principals, grants, err := s.Policy(As("root"))
+	if err != nil || !reflect.DeepEqual(principals, []string{"root"}) || len(grants) != 1 {
+		t.Errorf("Policy: got %v, %v, %v, want root and its admin grant", principals, grants, err)
+	}
+}
+
+func TestPolicySurvivesSnapshots(t *testing.T) {
+	f := (*fsm)(New())
+	now := time.Unix(1000, 0)
+	applyAt(t, f, command{Op: "bootstrap", Principal: &principal{Name: "root", TokenHash: hashToken("root-token")}}, now)
+	applyAt(t, f, command{Op: "grant", Grant: &Grant{Principal: Everyone, Rights: []Right{RightRd}, Space: "*", Tag: "*"}}, now)
+
+	snapshot, err := f.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	var sink memorySink
+	if err := snapshot.Persist(&sink); err != nil {
+		t.Fatalf("Persist: %v", err)
+	}
+	restored := New()
+	if err := (*fsm)(restored).Restore(io.NopCloser(&sink)); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	if principal, err := restored.Authenticate("root-token"); principal != "root" || err != nil {
+		t.Errorf("Authenticate: got %q, %v, want root", principal, err)
+	}
+	read := &command{Op: "read", Tuple: anyAccount("job").GetElements(), As: Anonymous}
+	if err := restored.authorize(read); err != nil {
+		t.Errorf("anonymous read: %v", err)
+	}
+	write := &command{Op: "write", Tuple: account("job", 1).GetElements(), As: Anonymous}
+	if err := restored.authorize(write); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("anonymous write: got %v, want permission denied", err)
+	}
+}
+
+func TestListingsFollowThePolicy(t *testing.T) {
+	s := newTestStore(t)
+	s.CreateSpace("jobs")
+	for _, template := range []tuplespace.Tuple{
+		tuplespace.MakeTuple(tuplespace.S("job"), tuplespace.Formal(tuplespace.INT)),
+		tuplespace.MakeTuple(tuplespace.S("task"), tuplespace.Formal(tuplespace.INT)),
+	} {
+		schema, _ := SchemaOf(template)
+		if _, err := s.SetSchema(schema); err != nil {
+			t.Fatalf("SetSchema: %v", err)
+		}
+	}
+	tags := func(schemas []Schema) []string {
+		var tags []string
+		for _, schema := range schemas {
+			tags = append(tags, schema.Tag)
+		}
+		return tags
+	}
+
+	// Before the bootstrap everything but the system space is open
+	if got := s.Spaces(As(Anonymous)); !reflect.DeepEqual(got, []string{DefaultSpace, "jobs"}) {
+		t.Errorf("Spaces by anonymous before the bootstrap: got %v", got)
+	}
+
+	if err := s.Bootstrap("root", "root-token"); err != nil {
+		t.Fatalf("Bootstrap: %v", err)
+	}
+	s.SetPrincipal("alice", "alice-token")
+	s.Grant(Grant{Principal: "alice", Rights: []Right{RightRd}, Space: "jobs", Tag: "job*"})
+
+	tests := []struct {
+		principal string
+		spaces    []string
+		tags      []string
+	}{
+		{"root", []string{DefaultSpace, "jobs"}, []string{"job", "task"}},
+		{"alice", []string{"jobs"}, []string{"job"}},
+		{Anonymous, nil, nil},
+	}
+	for _, test := range tests {
+		if got := s.Spaces(As(test.principal)); !reflect.DeepEqual(got, test.spaces) {
+			t.Errorf("Spaces by %s: got %v, want %v", test.principal, got, test.spaces)
+		}
+		if got := tags(s.Schemas(As(test.principal))); !reflect.DeepEqual(got, test.tags) {
+			t.Errorf("Schemas by %s: got %v, want %v", test.principal, got, test.tags)
+		}
+	}
+	if got := s.Spaces(); !reflect.DeepEqual(got, []string{DefaultSpace, "jobs", SystemSpace}) {
+		t.Errorf("Spaces by the server: got %v", got)
+	}
+}
@@ -0,0 +1,190 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	tuplespace "tuplespaceCD/pkg/tuplespace"
+)
+
+// ErrSchemaViolation is returned when a write is rejected because the tuple does not have the
+// arity or field types of the schema of its tag.
+var ErrSchemaViolation = errors.New("schema violation")
+
+// Schema constrains the tuples whose first field is the string `Tag`, in every space. It is
+// written as the template matching them, e.g.
+//
+//	("REQ"|?string|?int|_)
+//
+// for REQ tuples of three more fields: a STRING, an INT and one of any type.
+type Schema struct {
+	Tag     string                    `json:"tag"`
+	Fields  []tuplespace.TupleElement `json:"fields"`  // Types of the fields after the tag, ANY for any type
+	Version int                       `json:"version"` // Counts the changes of the schema, starting at 1
+}
+
+// SchemaOf returns the schema described by a template whose first field is the tag and whose
+// other fields are typed formals or wildcards.
+func SchemaOf(template tuplespace.Tuple) (Schema, error) {
+	elements := template.GetElements()
+	if len(elements) == 0 || elements[0].GetType() != tuplespace.STRING {
+		return Schema{}, fmt.Errorf("schema %s must start with a string tag", template)
+	}
+
+	schema := Schema{Tag: elements[0].GetValue().(string)}
+	for i, field := range elements[1:] {
+		if field.GetType() != tuplespace.ANY {
+			return Schema{}, fmt.Errorf("field %d of schema %s must be a type like ?int, or _", i+1, template)
+		}
+		fieldType, typed := field.GetValue().(tuplespace.TupleElement)
+		if !typed {
+			fieldType = tuplespace.ANY
+		}
+		schema.Fields = append(schema.Fields, fieldType)
+	}
+	return schema, nil
+}
+
+// Template returns the template matching the tuples of the schema.
+func (s Schema) Template() tuplespace.Tuple {
+	elements := []tuplespace.Elem{tuplespace.S(s.Tag)}
+	for _, fieldType := range s.Fields {
+		if fieldType == tuplespace.ANY {
+			elements = append(elements, tuplespace.Any())
+		} else {
+			elements = append(elements, tuplespace.Formal(fieldType))
+		}
+	}
+	return tuplespace.MakeTuple(elements...)
+}
+
+func (s Schema) String() string {
+	return s.Template().String()
+}
+
+// check returns why the tuple, which has the tag of the schema, violates it.
+func (s Schema) check(tuple tuplespace.Tuple) error {
+	elements := tuple.GetElements()
+	if len(elements) != len(s.Fields)+1 {
+		return fmt.Errorf("%w: %s has %d fields, schema %s (version %d) requires %d", ErrSchemaViolation, tuple, len(elements), s, s.Version, len(s.Fields)+1)
+	}
+	for i, fieldType := range s.Fields {
+		field := elements[i+1]
+		if fieldType != tuplespace.ANY && field.GetType() != fieldType {
+			return fmt.Errorf("%w: field %d of %s is %s, schema %s (version %d) requires %s", ErrSchemaViolation, i+1, tuple, field, s, s.Version, tuplespace.Formal(fieldType))
+		}
+	}
+	return nil
+}
+
+// The FSM response to a schema change.
+type schemaResult struct {
+	schema Schema
+	err    error
+}
+
+// SetSchema defines the schema of its tag, or replaces the current one. Tuples with the tag
+// that are already in the space must satisfy the new schema, otherwise it is rejected. Writes
+// violating the schema fail with `ErrSchemaViolation` from then on. Returns the schema with its
+// new version.
+func (s *Store) SetSchema(schema Schema, opts ...Option) (Schema, error) {
+	response, err := s.apply(&command{Op: "schema", Schema: &schema}, opts)
+	if err != nil {
+		return Schema{}, err
+	}
+
+	result, ok := response.(schemaResult)
+	if !ok {
+		return Schema{}, fmt.Errorf("unexpected response type")
+	}
+	return result.schema, result.err
+}
+
+// DropSchema removes the schema of the tag, so its tuples are not checked anymore. Returns
+// `false` if there is none.
+func (s *Store) DropSchema(tag string, opts ...Option) (bool, error) {
+	return s.applyBool(&command{Op: "dropschema", Schema: &Schema{Tag: tag}}, opts)
+}
+
+// Schemas returns the schemas of this replica, ordered by tag. With `As`, only those of the tags
+// on which the principal has a right are listed.
+func (s *Store) Schemas(opts ...Option) []Schema {
+	c := &command{}
+	for _, option := range opts {
+		option(c)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var schemas []Schema
+	for _, schema := range sortedSchemas(s.schemas) {
+		if c.As == "" || s.mayUse(c.As, "*", schema.Tag) {
+			schemas = append(schemas, schema)
+		}
+	}
+	return schemas
+}
+
+func sortedSchemas(schemas map[string]Schema) []Schema {
+	list := make([]Schema, 0, len(schemas))
+	for _, schema := range schemas {
+		list = append(list, schema)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Tag < list[j].Tag })
+	return list
+}
+
+// checkSchema returns why the tuple violates the schema of its tag, if it does. The caller
+// holds the lock.
+func (f *fsm) checkSchema(tuple tuplespace.Tuple) error {
+	tag := tagOf(tuple)
+	if tag == "" {
+		return nil
+	}
+	schema, found := f.schemas[tag]
+	if !found {
+		return nil
+	}
+	return schema.check(tuple)
+}
+
+// Returns the first field of the tuple if it is a string, the empty string otherwise.
+func tagOf(tuple tuplespace.Tuple) string {
+	elements := tuple.GetElements()
+	if len(elements) == 0 || elements[0].GetType() != tuplespace.STRING {
+		return ""
+	}
+	return elements[0].GetValue().(string)
+}
+
+func (f *fsm) applySetSchema(schema Schema) interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Existing tuples of the tag must not violate the new schema
+	schema.Version = f.schemas[schema.Tag].Version + 1
+	for _, name := range sortedNames(f.spaces) {
+		tuples, _, _ := f.spaces[name].Scan(tuplespace.MakeTuple(), "", 0)
+		for _, tuple := range tuples {
+			if tagOf(tuple) != schema.Tag {
+				continue
+			}
+			if err := schema.check(tuple); err != nil {
+				return schemaResult{err: fmt.Errorf("cannot change the schema of %s to version %d, a tuple in space %q violates it: %w", schema.Tag, schema.Version, name, err)}
+			}
+		}
+	}
+
+	f.schemas[schema.Tag] = schema
+	return schemaResult{schema: schema}
+}
+
+func (f *fsm) applyDropSchema(tag string) interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, found := f.schemas[tag]
+	delete(f.schemas, tag)
+	return found
+}
@@ -0,0 +1,149 @@
+package store
+
+import (
+	"errors"
+	"testing"
+
+	tuplespace "tuplespaceCD/pkg/tuplespace"
+)
+
+func TestSchemaOf(t *testing.T) {
+	tests := []struct {
+		template string
+		want     string
+		err      bool
+	}{
+		{`("REQ")`, `("REQ")`, false},
+		{`("REQ"|?string|?int|_)`, `("REQ"|?string|?int|_)`, false},
+		{`("REQ"|?float|?tuple)`, `("REQ"|?float|?tuple)`, false},
+		{`()`, "", true},
+		{`(1|?int)`, "", true},
+		{`(?string|?int)`, "", true},
+		{`("REQ"|5)`, "", true},
+		{`("REQ"|?int|"x")`, "", true},
+		{`("REQ"|nil)`, "", true},
+	}
+
+	for _, test := range tests {
+		schema, err := SchemaOf(tuplespace.MustParse(test.template))
+		if (err != nil) != test.err {
+			t.Errorf("SchemaOf(%s): got error %v, want error %v", test.template, err, test.err)
+			continue
+		}
+		if !test.err && schema.String() != test.want {
+			t.Errorf("SchemaOf(%s): got %s, want %s", test.template, schema, test.want)
+		}
+	}
+}
+
+func TestSchemaValidation(t *testing.T) {
+	s := newTestStore(t)
+	if _, err := s.CreateSpace("other"); err != nil {
+		t.Fatalf("CreateSpace: %v", err)
+	}
+	schema, err := SchemaOf(tuplespace.MustParse(`("REQ"|?string|?int|_)`))
+	if err != nil {
+		t.Fatalf("SchemaOf: %v", err)
+	}
+	if schema, err = s.SetSchema(schema); err != nil || schema.Version != 1 {
+		t.Fatalf("SetSchema: got version %d, error %v", schema.Version, err)
+	}
+
+	writes := []struct {
+		tuple     string
+		violation bool
+	}{
+		{`("REQ"|"a"|1|2.5)`, false},
+		{`("REQ"|"a"|1|("any"))`, false},
+		{`("REQ"|"a"|1)`, true},
+		{`("REQ"|"a"|1|2|3)`, true},
+		{`("REQ"|5)`, true},
+		{`("REQ"|1|1|1)`, true},
+		{`("REQ"|"a"|1.0|1)`, true},
+		{`("OTHER"|5)`, false},
+		{`(1|"REQ")`, false},
+	}
+	for _, write := range writes {
+		tuple := tuplespace.MustParse(write.tuple)
+		for _, space := range []string{"", "other"} {
+			err := s.Write(tuple, tuplespace.Forever, InSpace(space))
+			if write.violation != errors.Is(err, ErrSchemaViolation) || (!write.violation && err != nil) {
+				t.Errorf("Write(%s) in space %q: got %v, want violation %v", tuple, space, err, write.violation)
+			}
+		}
+	}
+
+	// Writes in batches and transactions are checked as well, and fail as a whole
+	bad := tuplespace.MustParse(`("REQ"|"b")`)
+	good := tuplespace.MustParse(`("REQ"|"b"|2|"z")`)
+	if err := s.WriteMany([]tuplespace.Tuple{good, bad}, tuplespace.Forever); !errors.Is(err, ErrSchemaViolation) {
+		t.Errorf("WriteMany: got %v, want a schema violation", err)
+	}
+	if _, err := s.Transact(WriteOp(good, tuplespace.Forever), WriteOp(bad, tuplespace.Forever)); !errors.Is(err, ErrSchemaViolation) {
+		t.Errorf("Transact: got %v, want a schema violation", err)
+	}
+	if found, _ := s.Read(good); found.IsPresent() {
+		t.Errorf("%s was written by a rejected batch", good)
+	}
+
+	// So are updates of tuples already in the space
+	template := tuplespace.MustParse(`("REQ"|"a"|1|2.5)`)
+	if _, err := s.UpdateFields(template, tuplespace.Forever, SetField(2, tuplespace.S("x"))); !errors.Is(err, ErrSchemaViolation) {
+		t.Errorf("UpdateFields: got %v, want a schema violation", err)
+	}
+	if _, err := s.UpdateFields(template, tuplespace.Forever, AddField(2, tuplespace.I(1))); err != nil {
+		t.Errorf("UpdateFields: %v", err)
+	}
+
+	if dropped, err := s.DropSchema("REQ"); !dropped || err != nil {
+		t.Fatalf("DropSchema: got %v, %v", dropped, err)
+	}
+	if err := s.Write(bad, tuplespace.Forever); err != nil {
+		t.Errorf("Write(%s) without schema: %v", bad, err)
+	}
+}
+
+func TestSchemaRejectedByExistingTuples(t *testing.T) {
+	s := newTestStore(t)
+	if _, err := s.CreateSpace("other"); err != nil {
+		t.Fatalf("CreateSpace: %v", err)
+	}
+	if err := s.Write(tuplespace.MustParse(`("JOB"|1)`), tuplespace.Forever, InSpace("other")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	changes := []struct {
+		template string
+		version  int
+		err      bool
+	}{
+		{`("JOB"|?string)`, 0, true},
+		{`("JOB")`, 0, true},
+		{`("JOB"|?int)`, 1, false},
+		{`("JOB"|?int|_)`, 0, true},
+		{`("JOB"|_)`, 2, false},
+		{`("JOB"|?float)`, 0, true},
+	}
+	for _, change := range changes {
+		schema, err := SchemaOf(tuplespace.MustParse(change.template))
+		if err != nil {
+			t.Fatalf("SchemaOf(%s): %v", change.template, err)
+		}
+		schema, err = s.SetSchema(schema)
+		if change.err {
+			if !errors.Is(err, ErrSchemaViolation) {
+				t.Errorf("SetSchema(%s): got %v, want a schema violation", change.template, err)
+			}
+			continue
+		}
+		if err != nil || schema.Version != change.version {
+			t.Errorf("SetSchema(%s): got version %d, error %v, want version %d", change.template, schema.Version, err, change.version)
+		}
+	}
+
+	// Rejected changes leave the last accepted schema in place
+	schemas := s.Schemas()
+	if len(schemas) != 1 || schemas[0].String() != `("JOB"|_)` || schemas[0].Version != 2 {
+		t.Errorf("got schemas %v, want (\"JOB\"|_) at version 2", schemas)
+	}
+}
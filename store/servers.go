@@ -0,0 +1,53 @@
+package store
+
+import "sort"
+
+// Clients talk to the server of a node, not to its Raft address, so the cluster replicates the
+// server address of every node by its id. Followers use it to tell clients where the server of
+// the leader is.
+
+// The server address of a node, as recorded in the log.
+type nodeServer struct {
+	NodeID     string `json:"node_id"`
+	ServerAddr string `json:"server_addr"`
+}
+
+// SetServerAddr records the server address of the node.
+func (s *Store) SetServerAddr(nodeID, serverAddr string) error {
+	_, err := s.apply(&command{Op: "server", Server: &nodeServer{NodeID: nodeID, ServerAddr: serverAddr}}, nil)
+	return err
+}
+
+// ServerAddr returns the server address of the node, or the empty string if it is not known.
+func (s *Store) ServerAddr(nodeID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.servers[nodeID]
+}
+
+// LeaderServerAddr returns the server address of the leader, or the empty string if there is
+// no leader or its server address is not known.
+func (s *Store) LeaderServerAddr() string {
+	_, leader := s.raft.LeaderWithID()
+	if leader == "" {
+		return ""
+	}
+	return s.ServerAddr(string(leader))
+}
+
+// Returns the server addresses in order, for snapshots.
+func sortedServers(servers map[string]string) []nodeServer {
+	list := make([]nodeServer, 0, len(servers))
+	for nodeID, serverAddr := range servers {
+		list = append(list, nodeServer{NodeID: nodeID, ServerAddr: serverAddr})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].NodeID < list[j].NodeID })
+	return list
+}
+
+func (f *fsm) applySetServerAddr(server nodeServer) interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.servers[server.NodeID] = server.ServerAddr
+	return true
+}
@@ -0,0 +1,44 @@
+package store
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestLeaderServerAddr(t *testing.T) {
+	s := newTestStore(t)
+	if addr := s.LeaderServerAddr(); addr != "" {
+		t.Errorf("got %q before the leader announced its server, want none", addr)
+	}
+	if err := s.SetServerAddr("node0", "10.0.0.1:11000"); err != nil {
+		t.Fatalf("SetServerAddr: %v", err)
+	}
+	if addr := s.LeaderServerAddr(); addr != "10.0.0.1:11000" {
+		t.Errorf("got %q, want the server of node0", addr)
+	}
+	if addr := s.ServerAddr("node1"); addr != "" {
+		t.Errorf("got %q for an unknown node, want none", addr)
+	}
+}
+
+func TestServerAddrsSurviveSnapshots(t *testing.T) {
+	f := (*fsm)(New())
+	applyAt(t, f, command{Op: "server", Server: &nodeServer{NodeID: "node1", ServerAddr: "10.0.0.2:11000"}}, time.Unix(1000, 0))
+
+	snapshot, err := f.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	var sink memorySink
+	if err := snapshot.Persist(&sink); err != nil {
+		t.Fatalf("Persist: %v", err)
+	}
+	restored := New()
+	if err := (*fsm)(restored).Restore(io.NopCloser(&sink)); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if addr := restored.ServerAddr("node1"); addr != "10.0.0.2:11000" {
+		t.Errorf("got %q, want the recorded server address", addr)
+	}
+}
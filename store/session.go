@@ -0,0 +1,239 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	tuplespace "tuplespaceCD/pkg/tuplespace"
+
+	opt "github.com/micutio/goptional"
+)
+
+// How long the FSM remembers the result of a command tagged with a request id.
+const sessionTTL = 10 * time.Minute
+
+// Option customizes a command before it is proposed to the cluster.
+type Option func(*command)
+
+// RequestID tags the command with a client-supplied id. If a command with the same id was
+// applied in the last `sessionTTL`, its original result is returned and the command is not
+// applied again, which makes retries safe. Gets and reads that found nothing are not
+// remembered, so polling with the same id keeps looking for a match.
+func RequestID(id string) Option {
+	return func(c *command) {
+		c.RequestID = id
+	}
+}
+
+// Scope runs the operations that take variadic arguments of their own, `Transact` and
+// `UpdateFields`, as well as `Update`, with options, e.g.
+//
+//	store.With(RequestID(id)).Transact(ops...)
+type Scope struct {
+	store *Store
+	opts  []Option
+}
+
+// With returns the scope of the options.
+func (s *Store) With(opts ...Option) Scope {
+	return Scope{store: s, opts: opts}
+}
+
+// The remembered result of a command, expiring at the given log time (Unix nanoseconds).
+type session struct {
+	response interface{}
+	expires  int64
+}
+
+// An entry of the queue of sessions in the order they expire.
+type sessionExpiry struct {
+	id      string
+	expires int64
+}
+
+// lookupSession returns the remembered result of the command's request id, if any.
+// Expired sessions are dropped first, based on the command's log time, so that every
+// replica holds the same table. Sessions are remembered in log order and all live for
+// `sessionTTL`, so only the front of the expiry queue needs to be looked at.
+func (f *fsm) lookupSession(c *command) (interface{}, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for len(f.expiries) > 0 && f.expiries[0].expires <= c.Time {
+		expired := f.expiries[0]
+		f.expiries = f.expiries[1:]
+		if s, found := f.sessions[expired.id]; found && s.expires == expired.expires {
+			delete(f.sessions, expired.id)
+		}
+	}
+
+	s, found := f.sessions[c.RequestID]
+	return s.response, found
+}
+
+// rememberSession stores the result of the command under its request id.
+func (f *fsm) rememberSession(c *command, response interface{}) {
+	if result, ok := response.(opt.Maybe[tuplespace.Tuple]); ok && !result.IsPresent() {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s := session{
+		response: response,
+		expires:  c.Time + int64(sessionTTL),
+	}
+	f.sessions[c.RequestID] = s
+	f.expiries = append(f.expiries, sessionExpiry{id: c.RequestID, expires: s.expires})
+}
+
+// expiryQueue returns the expiry queue of the sessions, e.g. restored from a snapshot.
+func expiryQueue(sessions map[string]session) []sessionExpiry {
+	queue := make([]sessionExpiry, 0, len(sessions))
+	for id, s := range sessions {
+		queue = append(queue, sessionExpiry{id: id, expires: s.expires})
+	}
+	sort.Slice(queue, func(i, j int) bool {
+		if queue[i].expires != queue[j].expires {
+			return queue[i].expires < queue[j].expires
+		}
+		return queue[i].id < queue[j].id
+	})
+	return queue
+}
+
+// The JSON representation of a remembered result, as stored in snapshots.
+type jsonSession struct {
+	ID       string             `json:"id"`
+	Expires  int64              `json:"expires"`
+	Kind     string             `json:"kind"`
+	Tuples   []tuplespace.Tuple `json:"tuples,omitempty"`
+	Present  []bool             `json:"present,omitempty"` // Which entries of `Tuples` hold a result
+	Value    int                `json:"value,omitempty"`
+	Schema   *Schema            `json:"schema,omitempty"`
+	Err      string             `json:"err,omitempty"`
+	Aborted  bool               `json:"aborted,omitempty"`  // Written by older versions instead of `Sentinel`
+	Sentinel string             `json:"sentinel,omitempty"` // Message of the sentinel error that `Err` wraps
+}
+
+// Errors that keep their identity in snapshots, so that `errors.Is` holds for remembered results.
+var sentinelErrors = []error{ErrTxAborted, ErrSchemaViolation, ErrNoSpace}
+
+func encodeMaybe(entry *jsonSession, result opt.Maybe[tuplespace.Tuple]) {
+	if result.IsPresent() {
+		entry.Tuples = append(entry.Tuples, result.Get())
+	} else {
+		entry.Tuples = append(entry.Tuples, tuplespace.MakeTuple())
+	}
+	entry.Present = append(entry.Present, result.IsPresent())
+}
+
+func decodeMaybe(entry jsonSession, i int) opt.Maybe[tuplespace.Tuple] {
+	if i < len(entry.Present) && entry.Present[i] {
+		return opt.NewJust(entry.Tuples[i])
+	}
+	return opt.NewNothing[tuplespace.Tuple]()
+}
+
+func encodeError(entry *jsonSession, err error) {
+	if err != nil {
+		entry.Err = err.Error()
+		for _, sentinel := range sentinelErrors {
+			if errors.Is(err, sentinel) && strings.HasPrefix(entry.Err, sentinel.Error()) {
+				entry.Sentinel = sentinel.Error()
+				break
+			}
+		}
+	}
+}
+
+func decodeError(entry jsonSession) error {
+	if entry.Err == "" {
+		return nil
+	}
+	if entry.Aborted {
+		entry.Sentinel = ErrTxAborted.Error()
+	}
+	for _, sentinel := range sentinelErrors {
+		if entry.Sentinel == sentinel.Error() {
+			return fmt.Errorf("%w%s", sentinel, strings.TrimPrefix(entry.Err, sentinel.Error()))
+		}
+	}
+	return errors.New(entry.Err)
+}
+
+func encodeSession(id string, s session) jsonSession {
+	entry := jsonSession{ID: id, Expires: s.expires}
+
+	switch response := s.response.(type) {
+	case opt.Maybe[tuplespace.Tuple]:
+		entry.Kind = "maybe"
+		encodeMaybe(&entry, response)
+	case bool:
+		entry.Kind = "bool"
+		if response {
+			entry.Value = 1
+		}
+	case int:
+		entry.Kind = "int"
+		entry.Value = response
+	case []tuplespace.Tuple:
+		entry.Kind = "tuples"
+		entry.Tuples = response
+	case txResult:
+		entry.Kind = "tx"
+		for _, result := range response.tuples {
+			encodeMaybe(&entry, result)
+		}
+		encodeError(&entry, response.err)
+	case updateResult:
+		entry.Kind = "update"
+		encodeMaybe(&entry, response.tuple)
+		encodeError(&entry, response.err)
+	case schemaResult:
+		entry.Kind = "schema"
+		if response.err == nil {
+			entry.Schema = &response.schema
+		}
+		encodeError(&entry, response.err)
+	case error:
+		entry.Kind = "error"
+		encodeError(&entry, response)
+	}
+	return entry
+}
+
+func decodeSession(entry jsonSession) session {
+	s := session{expires: entry.Expires}
+
+	switch entry.Kind {
+	case "maybe":
+		s.response = decodeMaybe(entry, 0)
+	case "bool":
+		s.response = entry.Value != 0
+	case "int":
+		s.response = entry.Value
+	case "tuples":
+		s.response = entry.Tuples
+	case "tx":
+		result := txResult{err: decodeError(entry)}
+		for i := range entry.Present {
+			result.tuples = append(result.tuples, decodeMaybe(entry, i))
+		}
+		s.response = result
+	case "update":
+		s.response = updateResult{tuple: decodeMaybe(entry, 0), err: decodeError(entry)}
+	case "schema":
+		result := schemaResult{err: decodeError(entry)}
+		if entry.Schema != nil {
+			result.schema = *entry.Schema
+		}
+		s.response = result
+	case "error":
+		s.response = decodeError(entry)
+	}
+	return s
+}
@@ -0,0 +1,141 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	tuplespace "tuplespaceCD/pkg/tuplespace"
+
+	"github.com/hashicorp/raft"
+	opt "github.com/micutio/goptional"
+)
+
+// A snapshot sink that keeps the snapshot in memory.
+type memorySink struct {
+	bytes.Buffer
+}
+
+func (s *memorySink) ID() string    { return "test" }
+func (s *memorySink) Cancel() error { return nil }
+func (s *memorySink) Close() error  { return nil }
+
+// Applies the command to the FSM as if it was committed at the given log time.
+func applyAt(t *testing.T, f *fsm, c command, at time.Time) interface{} {
+	t.Helper()
+	c.Time = at.UnixNano()
+	b, err := json.Marshal(c)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	return f.Apply(&raft.Log{Data: b})
+}
+
+func TestRequestIDDeduplicates(t *testing.T) {
+	s := newTestStore(t)
+	for i := 1; i <= 2; i++ {
+		if err := s.Write(account("job", i), tuplespace.Forever); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+
+	first, err := s.Get(anyAccount("job"), RequestID("get-1"))
+	if err != nil || !first.IsPresent() {
+		t.Fatalf("Get: got %v, %v", first, err)
+	}
+	retried, err := s.Get(anyAccount("job"), RequestID("get-1"))
+	if err != nil || !retried.IsPresent() || retried.Get().String() != first.Get().String() {
+		t.Errorf("retried Get: got %v, %v, want %s", retried, err, first.Get())
+	}
+	if n, _ := s.Count(anyAccount("job")); n != 1 {
+		t.Errorf("the retried Get took another tuple: %d left, want 1", n)
+	}
+
+	// A get that found nothing is not remembered, so polling with the same id finds later tuples
+	if found, _ := s.Get(anyAccount("task"), RequestID("poll")); found.IsPresent() {
+		t.Fatalf("Get: got %s, want nothing", found.Get())
+	}
+	if err := s.Write(account("task", 1), tuplespace.Forever); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if found, _ := s.Get(anyAccount("task"), RequestID("poll")); !found.IsPresent() {
+		t.Error("polling with the same id did not find the new tuple")
+	}
+
+	ops := []Op{GetOp(anyAccount("job")), WriteOp(account("done", 1), tuplespace.Forever)}
+	if _, err := s.With(RequestID("tx-1")).Transact(ops...); err != nil {
+		t.Fatalf("Transact: %v", err)
+	}
+	if _, err := s.With(RequestID("tx-1")).Transact(ops...); err != nil {
+		t.Errorf("retried Transact: %v, want the original result", err)
+	}
+	if n, _ := s.Count(anyAccount("done")); n != 1 {
+		t.Errorf("the retried transaction was applied again: %d tuples written, want 1", n)
+	}
+}
+
+func TestSessionsSurviveSnapshots(t *testing.T) {
+	f := (*fsm)(New())
+	now := time.Unix(1000, 0)
+	query := anyAccount("job").GetElements()
+
+	applyAt(t, f, command{Op: "writemany", Tuples: [][]tuplespace.Elem{
+		account("job", 1).GetElements(),
+		account("job", 2).GetElements(),
+		account("job", 3).GetElements(),
+	}}, now)
+	taken := applyAt(t, f, command{Op: "get", Tuple: query, RequestID: "get-1"}, now)
+	tx := applyAt(t, f, command{Op: "tx", RequestID: "tx-1", Ops: []command{
+		{Op: "get", Tuple: query},
+		{Op: "get", Tuple: anyAccount("missing").GetElements()},
+	}}, now).(txResult)
+	if tx.err == nil {
+		t.Fatal("a transaction taking a missing tuple succeeded")
+	}
+	schema, _ := SchemaOf(tuplespace.MakeTuple(tuplespace.S("task"), tuplespace.Formal(tuplespace.INT)))
+	set := applyAt(t, f, command{Op: "schema", Schema: &schema, RequestID: "schema-1"}, now).(schemaResult)
+	if set.err != nil || set.schema.Version != 1 {
+		t.Fatalf("schema: got version %d, error %v", set.schema.Version, set.err)
+	}
+
+	snapshot, err := f.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	var sink memorySink
+	if err := snapshot.Persist(&sink); err != nil {
+		t.Fatalf("Persist: %v", err)
+	}
+	restored := (*fsm)(New())
+	if err := restored.Restore(io.NopCloser(&sink)); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	// Retries against the restored replica return the original results without applying again
+	now = now.Add(time.Minute)
+	retried := applyAt(t, restored, command{Op: "get", Tuple: query, RequestID: "get-1"}, now)
+	if got := retried.(opt.Maybe[tuplespace.Tuple]); !got.IsPresent() || got.Get().String() != taken.(opt.Maybe[tuplespace.Tuple]).Get().String() {
+		t.Errorf("retried Get: got %v, want %v", retried, taken)
+	}
+	retriedTx := applyAt(t, restored, command{Op: "tx", RequestID: "tx-1"}, now).(txResult)
+	if !errors.Is(retriedTx.err, ErrTxAborted) || retriedTx.err.Error() != tx.err.Error() {
+		t.Errorf("retried Transact: got %v, %v, want %v", retriedTx.tuples, retriedTx.err, tx.err)
+	}
+	if n := applyAt(t, restored, command{Op: "count", Tuple: query}, now); n != 2 {
+		t.Errorf("got %v jobs after the retries, want 2", n)
+	}
+	retriedSchema, ok := applyAt(t, restored, command{Op: "schema", Schema: &schema, RequestID: "schema-1"}, now).(schemaResult)
+	if !ok || retriedSchema.err != nil || retriedSchema.schema.Version != 1 || retriedSchema.schema.String() != set.schema.String() {
+		t.Errorf("retried SetSchema: got %+v, want version 1 of %s", retriedSchema, set.schema)
+	}
+
+	// Once the session expires, the same id is a new request
+	now = now.Add(sessionTTL)
+	applyAt(t, restored, command{Op: "get", Tuple: query, RequestID: "get-1"}, now)
+	if n := applyAt(t, restored, command{Op: "count", Tuple: query}, now); n != 1 {
+		t.Errorf("got %v jobs after the session expired, want 1", n)
+	}
+}
@@ -0,0 +1,124 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	tuplespace "tuplespaceCD/pkg/tuplespace"
+)
+
+// DefaultSpace is the space of the operations that do not name one. It always exists.
+const DefaultSpace = "default"
+
+// SystemSpace holds the tuples of the applications running inside the server, such as the calls
+// of the rpc framework, which may carry secrets. It always exists and cannot be dropped, and
+// commands made on behalf of a principal, see `As`, may not use it whatever their grants. Its
+// tuples still reach the log of every node, like all others.
+const SystemSpace = "system"
+
+// ErrNoSpace is returned for operations on a space that was not created, or was dropped.
+var ErrNoSpace = errors.New("no such space")
+
+// InSpace makes the command operate on the named space instead of `DefaultSpace`. Every space
+// holds its own tuples, so templates in one space never match tuples of another.
+func InSpace(name string) Option {
+	return func(c *command) {
+		c.Space = name
+	}
+}
+
+// Returns the name of the space a command operates on.
+func spaceName(name string) string {
+	if name == "" {
+		return DefaultSpace
+	}
+	return name
+}
+
+// Returns true for the spaces that always exist.
+func builtinSpace(name string) bool {
+	return spaceName(name) == DefaultSpace || name == SystemSpace
+}
+
+func noSpace(name string) error {
+	return fmt.Errorf("%w: %q", ErrNoSpace, spaceName(name))
+}
+
+// CreateSpace creates an empty space. Returns `false` if it already exists.
+func (s *Store) CreateSpace(name string, opts ...Option) (bool, error) {
+	if name == "" {
+		return false, fmt.Errorf("space name must not be empty")
+	}
+	return s.applyBool(&command{Op: "createspace", Space: name}, opts)
+}
+
+// DropSpace removes a space together with its tuples. Returns `false` if it does not exist.
+// The default and system spaces cannot be dropped.
+func (s *Store) DropSpace(name string, opts ...Option) (bool, error) {
+	if builtinSpace(name) {
+		return false, fmt.Errorf("the %s space cannot be dropped", spaceName(name))
+	}
+	return s.applyBool(&command{Op: "dropspace", Space: name}, opts)
+}
+
+// Spaces returns the names of the spaces of this replica, in order. With `As`, only those in
+// which the principal has a right are listed.
+func (s *Store) Spaces(opts ...Option) []string {
+	c := &command{}
+	for _, option := range opts {
+		option(c)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for _, name := range sortedNames(s.spaces) {
+		if c.As == "" || s.mayUse(c.As, name, "*") {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func sortedNames[V any](m map[string]V) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// notifier returns the notifier of the named space. Registrations may precede the space, and
+// outlive it when it is dropped, so it is created on first use. The caller holds the lock.
+func (f *fsm) notifier(name string) *tuplespace.Notifier {
+	notifier, found := f.notifiers[name]
+	if !found {
+		notifier = tuplespace.NewNotifier()
+		f.notifiers[name] = notifier
+	}
+	return notifier
+}
+
+func (f *fsm) applyCreateSpace(name string) interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, found := f.spaces[name]; found {
+		return false
+	}
+	f.spaces[name] = (*Store)(f).newTupleSpace(tuplespace.NewSimpleStore())
+	return true
+}
+
+func (f *fsm) applyDropSpace(name string) interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, found := f.spaces[name]; !found || builtinSpace(name) {
+		return false
+	}
+	delete(f.spaces, name)
+	return true
+}
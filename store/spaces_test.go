@@ -0,0 +1,174 @@
+package store
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	tuplespace "tuplespaceCD/pkg/tuplespace"
+)
+
+func TestSpacesAreSeparate(t *testing.T) {
+	s := newTestStore(t)
+	if created, err := s.CreateSpace("other"); !created || err != nil {
+		t.Fatalf("CreateSpace: got %v, %v", created, err)
+	}
+	if created, err := s.CreateSpace("other"); created || err != nil {
+		t.Errorf("CreateSpace of an existing space: got %v, %v, want false", created, err)
+	}
+	if got := s.Spaces(); !reflect.DeepEqual(got, []string{DefaultSpace, "other", SystemSpace}) {
+		t.Errorf("Spaces: got %v", got)
+	}
+
+	if err := s.Write(account("job", 1), tuplespace.Forever, InSpace("other")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if found, _ := s.Read(anyAccount("job")); found.IsPresent() {
+		t.Errorf("the default space holds %s written to another space", found.Get())
+	}
+	if found, _ := s.Read(anyAccount("job"), InSpace(DefaultSpace)); found.IsPresent() {
+		t.Errorf("the default space, by name, holds %s written to another space", found.Get())
+	}
+	ops := []Op{GetOp(anyAccount("job")), WriteOp(account("done", 1), tuplespace.Forever)}
+	if _, err := s.With(InSpace("other")).Transact(ops...); err != nil {
+		t.Errorf("Transact: %v", err)
+	}
+	if n, _ := s.Count(anyAccount("done"), InSpace("other")); n != 1 {
+		t.Errorf("got %d tuples written by the transaction, want 1", n)
+	}
+	if tuples, _, err := s.Scan(anyAccount("done"), "", 10, InSpace("other")); err != nil || len(tuples) != 1 {
+		t.Errorf("Scan: got %v, %v, want the written tuple", tuples, err)
+	}
+
+	// Notifications of a space are not fired by the others
+	fired := make(chan tuplespace.Event, 2)
+	s.Notify(anyAccount("job"), tuplespace.Forever, func(e tuplespace.Event) { fired <- e }, InSpace("other"))
+	s.Write(account("job", 2), tuplespace.Forever)
+	s.Write(account("job", 3), tuplespace.Forever, InSpace("other"))
+	select {
+	case e := <-fired:
+		if e.Tuple.String() != account("job", 3).String() {
+			t.Errorf("notified of %s, want only the write to the space", e.Tuple)
+		}
+	case <-time.After(time.Second):
+		t.Error("not notified of the write to the space")
+	}
+
+	if dropped, err := s.DropSpace("other"); !dropped || err != nil {
+		t.Fatalf("DropSpace: got %v, %v", dropped, err)
+	}
+	if _, err := s.DropSpace(DefaultSpace); err == nil {
+		t.Error("the default space was dropped")
+	}
+	if _, err := s.Read(anyAccount("job"), InSpace("other")); !errors.Is(err, ErrNoSpace) {
+		t.Errorf("Read in a dropped space: got %v, want ErrNoSpace", err)
+	}
+	if _, err := s.With(InSpace("other")).Transact(ops...); !errors.Is(err, ErrNoSpace) {
+		t.Errorf("Transact in a dropped space: got %v, want ErrNoSpace", err)
+	}
+	if _, _, err := s.Scan(anyAccount("job"), "", 10, InSpace("other")); !errors.Is(err, ErrNoSpace) {
+		t.Errorf("Scan of a dropped space: got %v, want ErrNoSpace", err)
+	}
+}
+
+func TestSpacesSurviveSnapshots(t *testing.T) {
+	f := (*fsm)(New())
+	now := time.Unix(1000, 0)
+	applyAt(t, f, command{Op: "createspace", Space: "other"}, now)
+	applyAt(t, f, command{Op: "write", Tuple: account("job", 1).GetElements()}, now)
+	applyAt(t, f, command{Op: "write", Tuple: account("job", 2).GetElements(), Space: "other"}, now)
+	applyAt(t, f, command{Op: "write", Tuple: account("job", 3).GetElements(), Space: "other"}, now)
+	failed := applyAt(t, f, command{Op: "get", Tuple: anyAccount("job").GetElements(), Space: "none", RequestID: "get-1"}, now)
+
+	snapshot, err := f.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	var sink memorySink
+	if err := snapshot.Persist(&sink); err != nil {
+		t.Fatalf("Persist: %v", err)
+	}
+	restored := (*fsm)(New())
+	if err := restored.Restore(io.NopCloser(&sink)); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	if got := (*Store)(restored).Spaces(); !reflect.DeepEqual(got, []string{DefaultSpace, "other", SystemSpace}) {
+		t.Errorf("restored spaces: got %v", got)
+	}
+	for space, want := range map[string]int{"": 1, "other": 2} {
+		if n := applyAt(t, restored, command{Op: "count", Tuple: anyAccount("job").GetElements(), Space: space}, now); n != want {
+			t.Errorf("space %q: got %v tuples, want %d", space, n, want)
+		}
+	}
+	retried := applyAt(t, restored, command{Op: "get", Tuple: anyAccount("job").GetElements(), Space: "none", RequestID: "get-1"}, now)
+	if err, ok := retried.(error); !ok || !errors.Is(err, ErrNoSpace) || err.Error() != failed.(error).Error() {
+		t.Errorf("retried Get in a missing space: got %v, want %v", retried, failed)
+	}
+}
+
+func TestSystemSpace(t *testing.T) {
+	s := newTestStore(t)
+	secret := account("call", 1)
+	if err := s.Write(secret, tuplespace.Forever, InSpace(SystemSpace)); err != nil {
+		t.Fatalf("Write by the server: %v", err)
+	}
+
+	// Principals may not use it, before the bootstrap as after, whatever their grants
+	for _, principal := range []string{Anonymous, "root"} {
+		if principal == "root" {
+			if err := s.Bootstrap("root", "root-token"); err != nil {
+				t.Fatalf("Bootstrap: %v", err)
+			}
+		}
+		if found, err := s.Read(anyAccount("call"), InSpace(SystemSpace), As(principal)); !errors.Is(err, ErrPermissionDenied) || found.IsPresent() {
+			t.Errorf("Read by %s: got %v, %v, want permission denied", principal, found, err)
+		}
+		ops := []Op{ReadOp(anyAccount("call")).In(SystemSpace)}
+		if _, err := s.With(As(principal)).Transact(ops...); !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("Transact by %s: got %v, want permission denied", principal, err)
+		}
+		if _, err := s.DropSpace(SystemSpace, As(principal)); err == nil {
+			t.Errorf("DropSpace by %s succeeded", principal)
+		}
+	}
+	if _, err := s.DropSpace(SystemSpace); err == nil {
+		t.Error("the system space was dropped")
+	}
+	if n, _ := s.Count(secret, InSpace(SystemSpace)); n != 1 {
+		t.Errorf("got %d tuples in the system space, want 1", n)
+	}
+}
+
+func TestTransactionAcrossSpaces(t *testing.T) {
+	s := newTestStore(t)
+	if _, err := s.CreateSpace("other"); err != nil {
+		t.Fatalf("CreateSpace: %v", err)
+	}
+	s.Write(account("job", 1), tuplespace.Forever)
+
+	ops := []Op{GetOp(anyAccount("job")), WriteOp(account("done", 1), tuplespace.Forever).In("other")}
+	if _, err := s.Transact(ops...); err != nil {
+		t.Fatalf("Transact: %v", err)
+	}
+	if n, _ := s.Count(anyAccount("job")); n != 0 {
+		t.Errorf("got %d jobs left in the default space, want 0", n)
+	}
+	if n, _ := s.Count(anyAccount("done"), InSpace("other")); n != 1 {
+		t.Errorf("got %d tuples written to the other space, want 1", n)
+	}
+
+	// An abort leaves every space alone
+	ops = []Op{WriteOp(account("done", 2), tuplespace.Forever).In("other"), GetOp(anyAccount("job"))}
+	if _, err := s.Transact(ops...); !errors.Is(err, ErrTxAborted) {
+		t.Errorf("Transact: got %v, want aborted", err)
+	}
+	if n, _ := s.Count(anyAccount("done"), InSpace("other")); n != 1 {
+		t.Errorf("got %d tuples in the other space after the abort, want 1", n)
+	}
+	if _, err := s.Transact(ReadOp(anyAccount("job")).In("missing")); !errors.Is(err, ErrNoSpace) {
+		t.Errorf("Transact in a missing space: got %v, want ErrNoSpace", err)
+	}
+}
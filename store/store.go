@@ -1,12 +1,16 @@
 package store
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -21,9 +25,32 @@ const (
 	raftTimeout         = 10 * time.Second
 )
 
+// ErrNotLeader is returned when the node is not, or stopped being, the leader. A command that
+// failed with it may still have been committed, so it is only safe to send it again to the new
+// leader with the same `RequestID`.
+var ErrNotLeader = errors.New("not leader")
+
 type command struct {
-	Op    string            `json:"op,omitempty"`
-	Tuple []tuplespace.Elem `json:"tuple,omitempty"`
+	Op     string              `json:"op,omitempty"`
+	Tuple  []tuplespace.Elem   `json:"tuple,omitempty"`
+	Lease  time.Duration       `json:"lease,omitempty"`
+	Time   int64               `json:"time"` // Leader timestamp in Unix nanoseconds, used as the clock for leases
+	Limit  int                 `json:"limit,omitempty"`
+	Tuples [][]tuplespace.Elem `json:"tuples,omitempty"` // Tuples of a batched write
+	Ops    []command           `json:"ops,omitempty"`    // Operations of a transaction
+	Fields []FieldOp           `json:"fields,omitempty"` // Field operations of an update
+	Schema *Schema             `json:"schema,omitempty"` // Schema to set or drop
+	Space  string              `json:"space,omitempty"`  // Name of the space, see `InSpace`
+
+	// Access control changes
+	Principal *principal `json:"principal,omitempty"`
+	Grant     *Grant     `json:"grant,omitempty"`
+
+	Server *nodeServer `json:"server,omitempty"` // Server address of a node, see `SetServerAddr`
+
+	As string `json:"-"` // Principal the command is checked for before it is proposed, see `As`
+
+	RequestID string `json:"request_id,omitempty"` // Client-supplied id, see `RequestID`
 }
 
 // Store is a distributed tuple space store, where all changes are made via Raft consensus.
@@ -32,7 +59,16 @@ type Store struct {
 	RaftBind string
 
 	mu         sync.Mutex
-	tupleSpace tuplespace.Store // The tuple space for the system.
+	spaces     map[string]*tuplespace.BTreeStore // The tuple spaces by name, including `DefaultSpace`
+	notifiers  map[string]*tuplespace.Notifier   // Notifiers of the spaces by name, created on first use
+	logTime    time.Time                         // Timestamp of the log entry being applied
+	sessions   map[string]session
+	expiries   []sessionExpiry   // Sessions in the order they expire
+	schemas    map[string]Schema // Schemas by tag, checked on every write
+	principals map[string]string // Token hashes by principal name
+	grants     []Grant
+	servers    map[string]string   // Server addresses of the nodes by id
+	scans      map[string]scanCopy // Copies that scans page through by id, kept on the leader only
 
 	raft   *raft.Raft // The consensus mechanism
 	logger *log.Logger
@@ -40,10 +76,26 @@ type Store struct {
 
 // New returns a new Store.
 func New() *Store {
-	return &Store{
-		tupleSpace: tuplespace.NewSimpleStore(), // Initialize the tuple space
+	s := &Store{
+		spaces:     make(map[string]*tuplespace.BTreeStore),
+		notifiers:  make(map[string]*tuplespace.Notifier),
+		sessions:   make(map[string]session),
+		schemas:    make(map[string]Schema),
+		principals: make(map[string]string),
+		servers:    make(map[string]string),
+		scans:      make(map[string]scanCopy),
 		logger:     log.New(os.Stderr, "[store] ", log.LstdFlags),
 	}
+	s.spaces[DefaultSpace] = s.newTupleSpace(tuplespace.NewSimpleStore()) // Initialize the tuple spaces
+	s.spaces[SystemSpace] = s.newTupleSpace(tuplespace.NewSimpleStore())
+	return s
+}
+
+// newTupleSpace makes the leases of the tuple space follow the timestamps in the log, so that
+// every replica expires the same tuples at the same log entry.
+func (s *Store) newTupleSpace(space *tuplespace.BTreeStore) *tuplespace.BTreeStore {
+	space.SetClock(func() time.Time { return s.logTime })
+	return space
 }
 
 // Open opens the store. If enableSingle is set, and there are no existing peers,
@@ -96,84 +148,302 @@ func (s *Store) Open(enableSingle bool, localID string) error {
 	return nil
 }
 
-// Write writes a tuple to the tuple space.
-func (s *Store) Write(tuple tuplespace.Tuple) error {
+// apply proposes the command to the cluster and waits for the FSM response.
+func (s *Store) apply(c *command, opts []Option) (interface{}, error) {
 	if s.raft.State() != raft.Leader {
-		return fmt.Errorf("not leader")
+		return nil, ErrNotLeader
 	}
-	fmt.Printf("Write: %s\n", tuple)
-	c := &command{
-		Op:    "write",
-		Tuple: tuple.GetElements(),
+
+	for _, option := range opts {
+		option(c)
+	}
+	if err := s.authorize(c); err != nil {
+		return nil, err
 	}
+	c.Time = time.Now().UnixNano()
 	b, err := json.Marshal(c)
-	fmt.Printf("Write JSON: %s\n", b)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	f := s.raft.Apply(b, raftTimeout)
-	return f.Error()
-}
-
-// Get retrieves and removes a tuple matching the query from the tuple space.
-func (s *Store) Get(query tuplespace.Tuple) (opt.Maybe[tuplespace.Tuple], error) {
-	if s.raft.State() != raft.Leader {
-		return opt.NewNothing[tuplespace.Tuple](), fmt.Errorf("not leader")
+	if err := f.Error(); err != nil {
+		if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) || errors.Is(err, raft.ErrLeadershipTransferInProgress) {
+			return nil, fmt.Errorf("%w: %s", ErrNotLeader, err)
+		}
+		return nil, err
 	}
-
-	c := &command{
-		Op:    "get",
-		Tuple: query.GetElements(),
+	if err, failed := f.Response().(error); failed {
+		return nil, err
 	}
-	b, err := json.Marshal(c)
+	return f.Response(), nil
+}
+
+// applyQuery applies a command whose response is an optional tuple.
+func (s *Store) applyQuery(c *command, opts []Option) (opt.Maybe[tuplespace.Tuple], error) {
+	response, err := s.apply(c, opts)
 	if err != nil {
 		return opt.NewNothing[tuplespace.Tuple](), err
 	}
 
-	f := s.raft.Apply(b, raftTimeout)
-	if err := f.Error(); err != nil {
-		return opt.NewNothing[tuplespace.Tuple](), err
+	result, ok := response.(opt.Maybe[tuplespace.Tuple])
+	if !ok {
+		return opt.NewNothing[tuplespace.Tuple](), fmt.Errorf("unexpected response type")
+	}
+	return result, nil
+}
+
+// applyBool applies a command whose response tells if it found a tuple to act on.
+func (s *Store) applyBool(c *command, opts []Option) (bool, error) {
+	response, err := s.apply(c, opts)
+	if err != nil {
+		return false, err
 	}
 
-	result, ok := f.Response().(opt.Maybe[tuplespace.Tuple])
+	result, ok := response.(bool)
 	if !ok {
-		return opt.NewNothing[tuplespace.Tuple](), fmt.Errorf("unexpected response type")
+		return false, fmt.Errorf("unexpected response type")
 	}
 	return result, nil
 }
 
+// Write writes a tuple to the tuple space. The tuple expires after `lease`, or never if the
+// lease is `tuplespace.Forever`. Fails with `ErrSchemaViolation` if the tuple does not fit the
+// schema of its tag.
+func (s *Store) Write(tuple tuplespace.Tuple, lease time.Duration, opts ...Option) error {
+	fmt.Printf("Write: %s\n", tuple)
+	_, err := s.apply(&command{
+		Op:    "write",
+		Tuple: tuple.GetElements(),
+		Lease: lease,
+	}, opts)
+	return err
+}
+
+// Get retrieves and removes a tuple matching the query from the tuple space.
+func (s *Store) Get(query tuplespace.Tuple, opts ...Option) (opt.Maybe[tuplespace.Tuple], error) {
+	return s.applyQuery(&command{
+		Op:    "get",
+		Tuple: query.GetElements(),
+	}, opts)
+}
+
 // Read retrieves a tuple matching the query from the tuple space.
-func (s *Store) Read(query tuplespace.Tuple) (opt.Maybe[tuplespace.Tuple], error) {
-	if s.raft.State() != raft.Leader {
-		return opt.NewNothing[tuplespace.Tuple](), fmt.Errorf("not leader")
+func (s *Store) Read(query tuplespace.Tuple, opts ...Option) (opt.Maybe[tuplespace.Tuple], error) {
+	result, err := s.applyQuery(&command{
+		Op:    "read",
+		Tuple: query.GetElements(),
+	}, opts)
+	if err == nil {
+		fmt.Printf("Read: %s\n", result)
+	}
+	return result, err
+}
+
+// applyTuples applies a command whose response is a list of tuples.
+func (s *Store) applyTuples(c *command, opts []Option) ([]tuplespace.Tuple, error) {
+	response, err := s.apply(c, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	result, ok := response.([]tuplespace.Tuple)
+	if !ok {
+		return nil, fmt.Errorf("unexpected response type")
 	}
+	return result, nil
+}
 
+// WriteMany writes all tuples to the tuple space in a single log entry. Either all tuples are
+// written or, if any of them is undefined or violates a schema, none is.
+func (s *Store) WriteMany(tuples []tuplespace.Tuple, lease time.Duration, opts ...Option) error {
 	c := &command{
-		Op:    "read",
-		Tuple: query.GetElements(),
+		Op:    "writemany",
+		Lease: lease,
 	}
-	b, err := json.Marshal(c)
+	for _, tuple := range tuples {
+		c.Tuples = append(c.Tuples, tuple.GetElements())
+	}
+
+	ok, err := s.applyBool(c, opts)
 	if err != nil {
-		return opt.NewNothing[tuplespace.Tuple](), err
+		return err
 	}
+	if !ok {
+		return fmt.Errorf("batch contains undefined tuples")
+	}
+	return nil
+}
 
-	f := s.raft.Apply(b, raftTimeout)
-	if err := f.Error(); err != nil {
-		return opt.NewNothing[tuplespace.Tuple](), err
+// GetAll retrieves and removes up to `limit` tuples matching the query from the tuple space.
+// A limit of zero or less means no limit.
+func (s *Store) GetAll(query tuplespace.Tuple, limit int, opts ...Option) ([]tuplespace.Tuple, error) {
+	return s.applyTuples(&command{
+		Op:    "getall",
+		Tuple: query.GetElements(),
+		Limit: limit,
+	}, opts)
+}
+
+// ReadAll retrieves up to `limit` tuples matching the query from the tuple space.
+// A limit of zero or less means no limit.
+func (s *Store) ReadAll(query tuplespace.Tuple, limit int, opts ...Option) ([]tuplespace.Tuple, error) {
+	return s.applyTuples(&command{
+		Op:    "readall",
+		Tuple: query.GetElements(),
+		Limit: limit,
+	}, opts)
+}
+
+// Count counts the tuples matching the query.
+func (s *Store) Count(query tuplespace.Tuple, opts ...Option) (int, error) {
+	response, err := s.apply(&command{
+		Op:    "count",
+		Tuple: query.GetElements(),
+	}, opts)
+	if err != nil {
+		return 0, err
 	}
 
-	result, ok := f.Response().(opt.Maybe[tuplespace.Tuple])
+	result, ok := response.(int)
 	if !ok {
-		return opt.NewNothing[tuplespace.Tuple](), fmt.Errorf("unexpected response type")
+		return 0, fmt.Errorf("unexpected response type")
 	}
-	fmt.Printf("Read: %s\n", result)
 	return result, nil
 }
 
-// Join joins a node, identified by nodeID and located at addr, to this store.
-// The node must be ready to respond to Raft communications at that address.
-func (s *Store) Join(nodeID, addr string) error {
+// How long the copy a scan pages through is kept after the last page read from it
+const scanTTL = 1 * time.Minute
+
+// The point-in-time copy of a space that a scan pages through.
+type scanCopy struct {
+	space   string
+	tuples  *tuplespace.BTreeStore
+	expires time.Time
+}
+
+// Scan pages through the tuples matching the template in `tuplespace.TupleOrder`, see
+// `tuplespace.BTreeStore.Scan`. The first page is read from a point-in-time copy of the tuple
+// space on the leader, without going through the log, and the cursor it returns names the copy,
+// so that the following pages are read from it too and the whole walk sees a single point in
+// time. The copy is dropped after the last page, or `scanTTL` after the last page read from it;
+// a cursor that outlives its copy, e.g. after a failover, continues on a new one, so tuples
+// written or removed in between may or may not show up on later pages, but none is listed twice.
+func (s *Store) Scan(template tuplespace.Tuple, after string, limit int, opts ...Option) ([]tuplespace.Tuple, string, error) {
+	if s.raft.State() != raft.Leader {
+		return nil, "", ErrNotLeader
+	}
+
+	c := &command{Op: "read", Tuple: template.GetElements()}
+	for _, option := range opts {
+		option(c)
+	}
+	if err := s.authorize(c); err != nil {
+		return nil, "", err
+	}
+	name := spaceName(c.Space)
+	id, after, named := strings.Cut(after, ".")
+	if !named {
+		id, after = "", id
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	for scanID, scan := range s.scans {
+		if now.After(scan.expires) {
+			delete(s.scans, scanID)
+		}
+	}
+	scan, found := s.scans[id]
+	if found && scan.space != name {
+		s.mu.Unlock()
+		return nil, "", fmt.Errorf("invalid cursor: it continues a scan of space %q", scan.space)
+	}
+	if !found {
+		space, exists := s.spaces[name]
+		if !exists {
+			s.mu.Unlock()
+			return nil, "", noSpace(c.Space)
+		}
+		// The clock of the space reads the time of the log entry being applied, which changes
+		// under the lock, so the copy expires leases as of now
+		logTime := s.logTime
+		scan = scanCopy{space: name, tuples: space.Copy()}
+		scan.tuples.SetClock(func() time.Time { return logTime })
+		id = newScanID()
+	}
+	s.mu.Unlock()
+
+	tuples, cursor, err := scan.tuples.Scan(template, after, limit)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil || cursor == "" {
+		delete(s.scans, id)
+		return tuples, "", err
+	}
+	scan.expires = now.Add(scanTTL)
+	s.scans[id] = scan
+	return tuples, id + "." + cursor, nil
+}
+
+func newScanID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Renew renews the lease of a tuple matching the query, counting from the time the command is
+// committed. Returns `false` if no live tuple matches.
+func (s *Store) Renew(query tuplespace.Tuple, lease time.Duration, opts ...Option) (bool, error) {
+	return s.applyBool(&command{
+		Op:    "renew",
+		Tuple: query.GetElements(),
+		Lease: lease,
+	}, opts)
+}
+
+// Cancel cancels the lease of a tuple matching the query, removing it from the tuple space.
+// Returns `false` if no live tuple matches.
+func (s *Store) Cancel(query tuplespace.Tuple, opts ...Option) (bool, error) {
+	return s.applyBool(&command{
+		Op:    "cancel",
+		Tuple: query.GetElements(),
+	}, opts)
+}
+
+// Notify registers a handler that fires whenever a committed write or get matches the template.
+// Registrations are local to this node: every node applies each log entry once, so the handler
+// is called once per committed entry, on the node where it was registered. Registering needs
+// the right to read tuples matching the template.
+func (s *Store) Notify(template tuplespace.Tuple, lease time.Duration, handler tuplespace.Handler, opts ...Option) (*tuplespace.Registration, error) {
+	c := &command{Op: "notify", Tuple: template.GetElements()}
+	for _, option := range opts {
+		option(c)
+	}
+	if err := s.authorize(c); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	notifier := (*fsm)(s).notifier(spaceName(c.Space))
+	s.mu.Unlock()
+	return notifier.Register(template, lease, handler), nil
+}
+
+// Join joins a node, identified by nodeID and located at addr, to this store, and records the
+// address of its server. The node must be ready to respond to Raft communications at that address.
+func (s *Store) Join(nodeID, addr, serverAddr string) error {
+	if err := s.addVoter(nodeID, addr); err != nil {
+		return err
+	}
+	if serverAddr == "" {
+		return nil
+	}
+	return s.SetServerAddr(nodeID, serverAddr)
+}
+
+func (s *Store) addVoter(nodeID, addr string) error {
 	s.logger.Printf("received join request for remote node %s at %s", nodeID, addr)
 
 	configFuture := s.raft.GetConfiguration()
@@ -226,16 +496,83 @@ func (f *fsm) Apply(l *raft.Log) interface{} {
 		panic(fmt.Sprintf("failed to unmarshal command: %s", err.Error()))
 	}
 
+	f.mu.Lock()
+	f.logTime = time.Unix(0, c.Time)
+	f.mu.Unlock()
+
+	if c.RequestID == "" {
+		return f.applyCommand(&c)
+	}
+
+	if response, found := f.lookupSession(&c); found {
+		f.logger.Printf("request %s already applied, returning its original result", c.RequestID)
+		return response
+	}
+	response := f.applyCommand(&c)
+	f.rememberSession(&c, response)
+	return response
+}
+
+// applyCommand applies the command to the tuple space and returns the response.
+func (f *fsm) applyCommand(c *command) interface{} {
 	elements := c.Tuple
 	tuple := tuplespace.MakeTuple(elements...)
+	name := spaceName(c.Space)
+
+	// Commands on the spaces and schemas themselves
+	switch c.Op {
+	case "schema":
+		return f.applySetSchema(*c.Schema)
+	case "dropschema":
+		return f.applyDropSchema(c.Schema.Tag)
+	case "createspace":
+		return f.applyCreateSpace(name)
+	case "dropspace":
+		return f.applyDropSpace(name)
+	case "principal":
+		return f.applySetPrincipal(*c.Principal)
+	case "bootstrap":
+		return f.applyBootstrap(*c.Principal)
+	case "server":
+		return f.applySetServerAddr(*c.Server)
+	case "dropprincipal":
+		return f.applyDropPrincipal(c.Principal.Name)
+	case "grant":
+		return f.applyGrant(*c.Grant)
+	case "revoke":
+		return f.applyRevoke(*c.Grant)
+	}
+
+	f.mu.Lock()
+	_, found := f.spaces[name]
+	f.mu.Unlock()
+	if !found {
+		return noSpace(name)
+	}
 
 	switch c.Op {
 	case "write":
-		return f.applyWrite(tuple)
+		return f.applyWrite(name, tuple, c.Lease)
 	case "get":
-		return f.applyGet(tuple)
+		return f.applyGet(name, tuple)
 	case "read":
-		return f.applyRead(tuple)
+		return f.applyRead(name, tuple)
+	case "renew":
+		return f.applyRenew(name, tuple, c.Lease)
+	case "cancel":
+		return f.applyCancel(name, tuple)
+	case "writemany":
+		return f.applyWriteMany(name, c.Tuples, c.Lease)
+	case "getall":
+		return f.applyGetAll(name, tuple, c.Limit)
+	case "readall":
+		return f.applyReadAll(name, tuple, c.Limit)
+	case "count":
+		return f.applyCount(name, tuple)
+	case "tx":
+		return f.applyTransaction(name, c.Ops)
+	case "update":
+		return f.applyUpdate(name, tuple, c.Lease, c.Fields)
 	default:
 		panic(fmt.Sprintf("unrecognized command op: %s", c.Op))
 	}
@@ -246,58 +583,201 @@ func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
-	// Clone the tuple space store.
-	tupleSnapshot := f.cloneTupleSpace()
-	return &fsmSnapshot{tuples: tupleSnapshot}, nil
+	// Clone the tuple spaces.
+	spaces := make(map[string]*tuplespace.BTreeStore, len(f.spaces))
+	for name, space := range f.spaces {
+		spaces[name] = space.Copy()
+	}
+
+	var sessions []jsonSession
+	for id, s := range f.sessions {
+		sessions = append(sessions, encodeSession(id, s))
+	}
+	return &fsmSnapshot{
+		spaces:     spaces,
+		sessions:   sessions,
+		schemas:    sortedSchemas(f.schemas),
+		principals: sortedPrincipals(f.principals),
+		grants:     append([]Grant(nil), f.grants...),
+		servers:    sortedServers(f.servers),
+	}, nil
 }
 
 // Restore restores the tuple space store to a previous state.
 func (f *fsm) Restore(rc io.ReadCloser) error {
-	var snapshot tuplespace.BTreeStore
+	snapshot := jsonSnapshot{Tuples: tuplespace.NewSimpleStore()}
 	if err := json.NewDecoder(rc).Decode(&snapshot); err != nil {
 		return err
 	}
 
+	sessions := make(map[string]session)
+	for _, entry := range snapshot.Sessions {
+		sessions[entry.ID] = decodeSession(entry)
+	}
+	schemas := make(map[string]Schema)
+	for _, schema := range snapshot.Schemas {
+		schemas[schema.Tag] = schema
+	}
+	principals := make(map[string]string)
+	for _, p := range snapshot.Principals {
+		principals[p.Name] = p.TokenHash
+	}
+	servers := make(map[string]string)
+	for _, server := range snapshot.Servers {
+		servers[server.NodeID] = server.ServerAddr
+	}
+	spaces := map[string]*tuplespace.BTreeStore{DefaultSpace: (*Store)(f).newTupleSpace(snapshot.Tuples)}
+	for _, space := range snapshot.Spaces {
+		spaces[space.Name] = (*Store)(f).newTupleSpace(space.Tuples)
+	}
+	if _, found := spaces[SystemSpace]; !found { // Snapshots taken before it existed
+		spaces[SystemSpace] = (*Store)(f).newTupleSpace(tuplespace.NewSimpleStore())
+	}
+
 	// Restore the state from the snapshot.
 	f.mu.Lock()
-	f.tupleSpace = &snapshot
+	f.spaces = spaces
+	f.sessions = sessions
+	f.expiries = expiryQueue(sessions)
+	f.schemas = schemas
+	f.principals = principals
+	f.grants = snapshot.Grants
+	f.servers = servers
 	f.mu.Unlock()
 
 	return nil
 }
 
-func (f *fsm) applyWrite(tuple tuplespace.Tuple) interface{} {
+func (f *fsm) applyWrite(name string, tuple tuplespace.Tuple, lease time.Duration) interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.checkSchema(tuple); err != nil {
+		return err
+	}
+	ok := f.spaces[name].Write(tuple, lease)
+	if ok {
+		f.notifier(name).Publish(tuplespace.WRITTEN, tuple)
+	}
+	return ok
+}
+
+func (f *fsm) applyGet(name string, query tuplespace.Tuple) interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	result := f.spaces[name].Get(query)
+	if result.IsPresent() {
+		f.notifier(name).Publish(tuplespace.TAKEN, result.Get())
+	}
+	return result
+}
+
+func (f *fsm) applyRead(name string, query tuplespace.Tuple) interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.spaces[name].Read(query)
+}
+
+func (f *fsm) applyRenew(name string, query tuplespace.Tuple, lease time.Duration) interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.spaces[name].Renew(query, lease)
+}
+
+func (f *fsm) applyCancel(name string, query tuplespace.Tuple) interface{} {
 	f.mu.Lock()
 	defer f.mu.Unlock()
-	return f.tupleSpace.Write(tuple)
+	return f.spaces[name].Cancel(query)
 }
 
-func (f *fsm) applyGet(query tuplespace.Tuple) interface{} {
+func (f *fsm) applyWriteMany(name string, batch [][]tuplespace.Elem, lease time.Duration) interface{} {
 	f.mu.Lock()
 	defer f.mu.Unlock()
-	return f.tupleSpace.Get(query)
+
+	tuples := make([]tuplespace.Tuple, len(batch))
+	for i, elements := range batch {
+		tuples[i] = tuplespace.MakeTuple(elements...)
+		if err := f.checkSchema(tuples[i]); err != nil {
+			return err
+		}
+	}
+
+	ok := f.spaces[name].WriteMany(tuples, lease)
+	if ok {
+		for _, tuple := range tuples {
+			f.notifier(name).Publish(tuplespace.WRITTEN, tuple)
+		}
+	}
+	return ok
 }
 
-func (f *fsm) applyRead(query tuplespace.Tuple) interface{} {
+func (f *fsm) applyGetAll(name string, query tuplespace.Tuple, limit int) interface{} {
 	f.mu.Lock()
 	defer f.mu.Unlock()
-	return f.tupleSpace.Read(query)
+	tuples := f.spaces[name].GetAll(query, limit)
+	for _, tuple := range tuples {
+		f.notifier(name).Publish(tuplespace.TAKEN, tuple)
+	}
+	return tuples
 }
 
-// cloneTupleSpace creates a deep copy of the tuple space store.
-func (f *fsm) cloneTupleSpace() *tuplespace.BTreeStore {
-	clone := f.tupleSpace.(*tuplespace.BTreeStore).Copy()
-	return clone
+func (f *fsm) applyReadAll(name string, query tuplespace.Tuple, limit int) interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.spaces[name].ReadAll(query, limit)
+}
+
+func (f *fsm) applyCount(name string, query tuplespace.Tuple) interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.spaces[name].Count(query)
 }
 
 type fsmSnapshot struct {
-	tuples *tuplespace.BTreeStore
+	spaces   map[string]*tuplespace.BTreeStore
+	sessions []jsonSession
+	schemas  []Schema
+
+	principals []principal
+	grants     []Grant
+	servers    []nodeServer
+}
+
+// The JSON representation of a snapshot. The default space is stored in `Tuples`, the named
+// ones in a section each.
+type jsonSnapshot struct {
+	Tuples   *tuplespace.BTreeStore `json:"tuples"`
+	Spaces   []jsonSpace            `json:"spaces,omitempty"`
+	Sessions []jsonSession          `json:"sessions,omitempty"`
+	Schemas  []Schema               `json:"schemas,omitempty"`
+
+	Principals []principal  `json:"principals,omitempty"`
+	Grants     []Grant      `json:"grants,omitempty"`
+	Servers    []nodeServer `json:"servers,omitempty"`
+}
+
+// The JSON representation of a named space.
+type jsonSpace struct {
+	Name   string                 `json:"name"`
+	Tuples *tuplespace.BTreeStore `json:"tuples"`
 }
 
 func (f *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
 	err := func() error {
 		// Encode data.
-		b, err := json.Marshal(f.tuples)
+		snapshot := jsonSnapshot{
+			Tuples:     f.spaces[DefaultSpace],
+			Sessions:   f.sessions,
+			Schemas:    f.schemas,
+			Principals: f.principals,
+			Grants:     f.grants,
+			Servers:    f.servers,
+		}
+		for _, name := range sortedNames(f.spaces) {
+			if name != DefaultSpace {
+				snapshot.Spaces = append(snapshot.Spaces, jsonSpace{Name: name, Tuples: f.spaces[name]})
+			}
+		}
+		b, err := json.Marshal(snapshot)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,269 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	tuplespace "tuplespaceCD/pkg/tuplespace"
+)
+
+// Opens a single node store and waits until it is the leader.
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+
+	s := New()
+	s.RaftDir = t.TempDir()
+	s.RaftBind = "127.0.0.1:0"
+	if err := s.Open(true, "node0"); err != nil {
+		t.Fatalf("opening the store: %v", err)
+	}
+	for deadline := time.Now().Add(10 * time.Second); !s.IsLeader(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the store did not become the leader")
+		}
+	}
+	return s
+}
+
+func TestNotify(t *testing.T) {
+	s := newTestStore(t)
+	events := make(chan tuplespace.Event, 10)
+	s.Notify(tuplespace.MakeTuple(tuplespace.S("job"), tuplespace.Any()), tuplespace.Forever, func(e tuplespace.Event) { events <- e })
+
+	next := func() tuplespace.Event {
+		t.Helper()
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(time.Second):
+			t.Fatal("no event")
+			return tuplespace.Event{}
+		}
+	}
+
+	if err := s.Write(tuplespace.MakeTuple(tuplespace.S("job"), tuplespace.I(1)), tuplespace.Forever); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if event := next(); event.Kind != tuplespace.WRITTEN || event.Tuple.String() != `("job"|1)` {
+		t.Errorf("got %s %s, want written (\"job\"|1)", event.Kind, event.Tuple)
+	}
+	if err := s.Write(tuplespace.MakeTuple(tuplespace.S("other"), tuplespace.I(1)), tuplespace.Forever); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if _, err := s.Get(tuplespace.MakeTuple(tuplespace.S("job"), tuplespace.Any())); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if event := next(); event.Kind != tuplespace.TAKEN || event.Tuple.String() != `("job"|1)` {
+		t.Errorf("got %s %s, want taken (\"job\"|1)", event.Kind, event.Tuple)
+	}
+
+	select {
+	case event := <-events:
+		t.Errorf("unexpected %s event for %s", event.Kind, event.Tuple)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestLeasesFollowTheLog(t *testing.T) {
+	s := newTestStore(t)
+	leased := tuplespace.MakeTuple(tuplespace.S("leased"), tuplespace.I(1))
+	renewed := tuplespace.MakeTuple(tuplespace.S("renewed"), tuplespace.I(1))
+	for _, tuple := range []tuplespace.Tuple{leased, renewed} {
+		if err := s.Write(tuple, 200*time.Millisecond); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if found, err := s.Read(leased); err != nil || !found.IsPresent() {
+		t.Fatalf("Read: got %v, %v before the lease ran out", found, err)
+	}
+	if ok, err := s.Renew(renewed, time.Hour); err != nil || !ok {
+		t.Fatalf("Renew: got %v, %v", ok, err)
+	}
+
+	// Entries committed after the lease see the tuple expired
+	time.Sleep(250 * time.Millisecond)
+	if found, err := s.Read(leased); err != nil || found.IsPresent() {
+		t.Errorf("Read: got %v, %v after the lease ran out", found, err)
+	}
+	if ok, err := s.Renew(leased, time.Hour); err != nil || ok {
+		t.Errorf("Renew of an expired tuple: got %v, %v", ok, err)
+	}
+	if ok, err := s.Cancel(renewed); err != nil || !ok {
+		t.Errorf("Cancel of a renewed tuple: got %v, %v", ok, err)
+	}
+	if found, err := s.Read(renewed); err != nil || found.IsPresent() {
+		t.Errorf("Read: got %v, %v after cancelling", found, err)
+	}
+}
+
+func TestBulkOperations(t *testing.T) {
+	s := newTestStore(t)
+	var jobs []tuplespace.Tuple
+	for i := 0; i < 1000; i++ {
+		jobs = append(jobs, tuplespace.MakeTuple(tuplespace.S("job"), tuplespace.I(i)))
+	}
+	if err := s.WriteMany(jobs, tuplespace.Forever); err != nil {
+		t.Fatalf("WriteMany: %v", err)
+	}
+	if err := s.WriteMany([]tuplespace.Tuple{tuplespace.MakeTuple(tuplespace.S("job"), tuplespace.Any())}, tuplespace.Forever); err == nil {
+		t.Error("WriteMany of an undefined tuple succeeded")
+	}
+
+	all := tuplespace.MakeTuple(tuplespace.S("job"), tuplespace.Any())
+	if n, err := s.Count(all); err != nil || n != 1000 {
+		t.Errorf("Count: got %d, %v, want 1000", n, err)
+	}
+	if tuples, err := s.ReadAll(all, 10); err != nil || len(tuples) != 10 {
+		t.Errorf("ReadAll: got %d tuples, %v, want 10", len(tuples), err)
+	}
+	taken, err := s.GetAll(all, 600)
+	if err != nil || len(taken) != 600 {
+		t.Errorf("GetAll: got %d tuples, %v, want 600", len(taken), err)
+	}
+	if rest, err := s.GetAll(all, 0); err != nil || len(rest) != 400 {
+		t.Errorf("GetAll without limit: got %d tuples, %v, want 400", len(rest), err)
+	}
+	if n, err := s.Count(all); err != nil || n != 0 {
+		t.Errorf("Count after GetAll: got %d, %v, want 0", n, err)
+	}
+}
+
+func TestScan(t *testing.T) {
+	s := newTestStore(t)
+	for i := 0; i < 50; i++ {
+		if err := s.Write(tuplespace.MakeTuple(tuplespace.S("job"), tuplespace.I(i)), tuplespace.Forever); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+
+	template := tuplespace.MakeTuple(tuplespace.S("job"), tuplespace.Any())
+	seen := make(map[int]bool)
+	cursor := ""
+	for page := 0; page == 0 || cursor != ""; page++ {
+		var tuples []tuplespace.Tuple
+		var err error
+		tuples, cursor, err = s.Scan(template, cursor, 7)
+		if err != nil {
+			t.Fatalf("Scan: %v", err)
+		}
+		for _, tuple := range tuples {
+			i := tuple.GetElements()[1].GetValue().(int)
+			if seen[i] {
+				t.Errorf("page %d lists %s again", page, tuple)
+			}
+			seen[i] = true
+		}
+	}
+	if len(seen) != 50 {
+		t.Errorf("got %d tuples, want 50", len(seen))
+	}
+}
+
+func TestFollowerRefusesCommands(t *testing.T) {
+	// Without bootstrapping, the node has no cluster to lead
+	s := New()
+	s.RaftDir = t.TempDir()
+	s.RaftBind = "127.0.0.1:0"
+	if err := s.Open(false, "node0"); err != nil {
+		t.Fatalf("opening the store: %v", err)
+	}
+
+	if err := s.Write(tuplespace.MakeTuple(tuplespace.S("job")), tuplespace.Forever); !errors.Is(err, ErrNotLeader) {
+		t.Errorf("Write: got %v, want ErrNotLeader", err)
+	}
+	if _, err := s.Get(tuplespace.MakeTuple(tuplespace.S("job"))); !errors.Is(err, ErrNotLeader) {
+		t.Errorf("Get: got %v, want ErrNotLeader", err)
+	}
+}
+
+func TestScanWhileWriting(t *testing.T) {
+	s := newTestStore(t)
+	for i := 0; i < 50; i++ {
+		if err := s.Write(tuplespace.MakeTuple(tuplespace.S("old"), tuplespace.I(i)), tuplespace.Forever); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+
+	// Writes with leases move the clock of the space while the pages are read
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			s.Write(tuplespace.MakeTuple(tuplespace.S("new"), tuplespace.I(i)), time.Millisecond)
+		}
+	}()
+
+	template := tuplespace.MustParse(`("old"|?int)`)
+	seen := make(map[int]bool)
+	cursor := ""
+	for page := 0; page == 0 || cursor != ""; page++ {
+		var tuples []tuplespace.Tuple
+		var err error
+		tuples, cursor, err = s.Scan(template, cursor, 7)
+		if err != nil {
+			t.Fatalf("Scan: %v", err)
+		}
+		// Let writes be applied between the pages
+		time.Sleep(5 * time.Millisecond)
+		for _, tuple := range tuples {
+			i := tuple.GetElements()[1].GetValue().(int)
+			if seen[i] {
+				t.Errorf("page %d lists %s again", page, tuple)
+			}
+			seen[i] = true
+		}
+	}
+	if len(seen) != 50 {
+		t.Errorf("got %d tuples, want 50", len(seen))
+	}
+}
+
+func TestScanPagesThroughOneCopy(t *testing.T) {
+	s := newTestStore(t)
+	for i := 0; i < 10; i++ {
+		s.Write(account("old", i), tuplespace.Forever)
+	}
+
+	tuples, cursor, err := s.Scan(anyAccount("old"), "", 4)
+	if err != nil || len(tuples) != 4 || cursor == "" {
+		t.Fatalf("first page: got %v, %q, %v", tuples, cursor, err)
+	}
+	// Changes after the first page are not seen by the later ones
+	s.Get(account("old", 9))
+	s.Write(account("old", 10), tuplespace.Forever)
+	for cursor != "" {
+		var page []tuplespace.Tuple
+		if page, cursor, err = s.Scan(anyAccount("old"), cursor, 4); err != nil {
+			t.Fatalf("Scan: %v", err)
+		}
+		tuples = append(tuples, page...)
+	}
+	if len(tuples) != 10 || tuples[9].String() != account("old", 9).String() {
+		t.Errorf("got %v, want the 10 tuples as of the first page", tuples)
+	}
+	if len(s.scans) != 0 {
+		t.Errorf("%d copies kept after the last page, want none", len(s.scans))
+	}
+
+	// A cursor whose copy expired continues on a new one
+	_, cursor, _ = s.Scan(anyAccount("old"), "", 5)
+	if _, _, err := s.Scan(anyAccount("old"), cursor, 5, InSpace("missing")); err == nil {
+		t.Error("a cursor was continued in another space")
+	}
+	s.mu.Lock()
+	for id, scan := range s.scans {
+		scan.expires = time.Now().Add(-time.Second)
+		s.scans[id] = scan
+	}
+	s.mu.Unlock()
+	rest, cursor, err := s.Scan(anyAccount("old"), cursor, 5)
+	if err != nil || cursor != "" || len(rest) != 5 || rest[4].String() != account("old", 10).String() {
+		t.Errorf("after the copy expired: got %v, %q, %v, want the current tuples", rest, cursor, err)
+	}
+}
@@ -0,0 +1,169 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	tuplespace "tuplespaceCD/pkg/tuplespace"
+
+	opt "github.com/micutio/goptional"
+)
+
+// ErrTxAborted is returned when a transaction was not applied because one of its operations
+// could not be performed. None of its operations take effect in that case, nor if a write
+// violates a schema, which fails with `ErrSchemaViolation` instead.
+var ErrTxAborted = errors.New("transaction aborted")
+
+// Op is a single operation of a transaction, built with `GetOp`, `ReadOp`, `AbsentOp`,
+// `WriteOp` or `StampOp`.
+type Op struct {
+	c command
+}
+
+// GetOp takes a tuple matching the query. The whole transaction fails if there is none.
+func GetOp(query tuplespace.Tuple) Op {
+	return Op{command{Op: "get", Tuple: query.GetElements()}}
+}
+
+// ReadOp reads a tuple matching the query, without removing it.
+func ReadOp(query tuplespace.Tuple) Op {
+	return Op{command{Op: "read", Tuple: query.GetElements()}}
+}
+
+// AbsentOp checks that no tuple matches the query. The whole transaction fails if one does.
+func AbsentOp(query tuplespace.Tuple) Op {
+	return Op{command{Op: "absent", Tuple: query.GetElements()}}
+}
+
+// WriteOp writes a tuple that expires after `lease`, or never if the lease is `Forever`.
+func WriteOp(tuple tuplespace.Tuple, lease time.Duration) Op {
+	return Op{command{Op: "write", Tuple: tuple.GetElements(), Lease: lease}}
+}
+
+// StampOp writes a tuple like `WriteOp`, with the field at `index` replaced by the leader
+// timestamp of the transaction, see `SetTime`. Every replica writes the same tuple.
+func StampOp(tuple tuplespace.Tuple, index int, lease time.Duration) Op {
+	return Op{command{Op: "write", Tuple: tuple.GetElements(), Lease: lease, Fields: []FieldOp{SetTime(index)}}}
+}
+
+// In makes the operation use the named space instead of the one of its transaction, so a
+// transaction may change several spaces at once.
+func (op Op) In(space string) Op {
+	op.c.Space = space
+	return op
+}
+
+// Returns the name of the space of an operation in a transaction on the named space.
+func opSpace(op command, name string) string {
+	if op.Space == "" {
+		return name
+	}
+	return op.Space
+}
+
+// The FSM response to a transaction.
+type txResult struct {
+	tuples []opt.Maybe[tuplespace.Tuple]
+	err    error
+}
+
+// Transact applies all operations in order as a single log entry: either all of them take
+// effect or none does. The result holds, for every operation, the tuple it took or read; writes
+// and reads without a match yield nothing. Use `With` to pass options.
+func (s *Store) Transact(ops ...Op) ([]opt.Maybe[tuplespace.Tuple], error) {
+	return s.With().Transact(ops...)
+}
+
+// Transact is like `Store.Transact`, with the options of the scope.
+func (w Scope) Transact(ops ...Op) ([]opt.Maybe[tuplespace.Tuple], error) {
+	c := &command{Op: "tx"}
+	for _, op := range ops {
+		c.Ops = append(c.Ops, op.c)
+	}
+
+	response, err := w.store.apply(c, w.opts)
+	if err != nil {
+		return nil, err
+	}
+
+	result, ok := response.(txResult)
+	if !ok {
+		return nil, fmt.Errorf("unexpected response type")
+	}
+	return result.tuples, result.err
+}
+
+// applyTransaction runs the operations against copies of the spaces they use, the named one
+// unless they name another, which replace the current ones only if all operations succeed.
+func (f *fsm) applyTransaction(name string, ops []command) interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	spaces := make(map[string]*tuplespace.BTreeStore)
+	for _, op := range ops {
+		opName := opSpace(op, name)
+		if _, copied := spaces[opName]; copied {
+			continue
+		}
+		space, found := f.spaces[opName]
+		if !found {
+			return txResult{err: noSpace(opName)}
+		}
+		spaces[opName] = space.Copy()
+	}
+	tuples := make([]opt.Maybe[tuplespace.Tuple], len(ops))
+	type spaceEvent struct {
+		space string
+		tuplespace.Event
+	}
+	var events []spaceEvent
+
+	for i, op := range ops {
+		opName := opSpace(op, name)
+		space := spaces[opName]
+		tuple := tuplespace.MakeTuple(op.Tuple...)
+		tuples[i] = opt.NewNothing[tuplespace.Tuple]()
+
+		switch op.Op {
+		case "get":
+			result := space.Get(tuple)
+			if !result.IsPresent() {
+				return txResult{err: fmt.Errorf("%w: get #%d found no tuple matching %s", ErrTxAborted, i, tuple)}
+			}
+			tuples[i] = result
+			events = append(events, spaceEvent{opName, tuplespace.Event{Kind: tuplespace.TAKEN, Tuple: result.Get()}})
+		case "read":
+			tuples[i] = space.Read(tuple)
+		case "absent":
+			if found := space.Read(tuple); found.IsPresent() {
+				return txResult{err: fmt.Errorf("%w: absent #%d found %s", ErrTxAborted, i, found.Get())}
+			}
+		case "write":
+			if len(op.Fields) > 0 {
+				var err error
+				if tuple, err = applyFieldOps(tuple, op.Fields, f.logTime); err != nil {
+					return txResult{err: fmt.Errorf("%w: write #%d: %s", ErrTxAborted, i, err)}
+				}
+			}
+			// A violation is not an abort: retrying the transaction would fail the same way
+			if err := f.checkSchema(tuple); err != nil {
+				return txResult{err: err}
+			}
+			if !space.Write(tuple, op.Lease) {
+				return txResult{err: fmt.Errorf("%w: write #%d of undefined tuple %s", ErrTxAborted, i, tuple)}
+			}
+			events = append(events, spaceEvent{opName, tuplespace.Event{Kind: tuplespace.WRITTEN, Tuple: tuple}})
+		default:
+			return txResult{err: fmt.Errorf("%w: unrecognized op %q", ErrTxAborted, op.Op)}
+		}
+	}
+
+	for opName, space := range spaces {
+		f.spaces[opName] = space
+	}
+	for _, event := range events {
+		f.notifier(event.space).Publish(event.Kind, event.Tuple)
+	}
+	return txResult{tuples: tuples}
+}
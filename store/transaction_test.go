@@ -0,0 +1,152 @@
+package store
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	tuplespace "tuplespaceCD/pkg/tuplespace"
+)
+
+func account(name string, balance int) tuplespace.Tuple {
+	return tuplespace.MakeTuple(tuplespace.S(name), tuplespace.I(balance))
+}
+
+func anyAccount(name string) tuplespace.Tuple {
+	return tuplespace.MakeTuple(tuplespace.S(name), tuplespace.Any())
+}
+
+func TestTransact(t *testing.T) {
+	s := newTestStore(t)
+	if err := s.Write(account("alice", 10), tuplespace.Forever); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	results, err := s.Transact(GetOp(anyAccount("alice")), WriteOp(account("alice", 15), tuplespace.Forever), ReadOp(anyAccount("alice")), ReadOp(anyAccount("bob")))
+	if err != nil {
+		t.Fatalf("Transact: %v", err)
+	}
+	want := []string{`("alice"|10)`, "", `("alice"|15)`, ""}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(results), len(want))
+	}
+	for i, result := range results {
+		got := ""
+		if result.IsPresent() {
+			got = result.Get().String()
+		}
+		if got != want[i] {
+			t.Errorf("result %d: got %q, want %q", i, got, want[i])
+		}
+	}
+	if found, _ := s.Read(account("alice", 10)); found.IsPresent() {
+		t.Error("the taken tuple is still there")
+	}
+}
+
+func TestTransactAppliesAllOrNothing(t *testing.T) {
+	s := newTestStore(t)
+	if err := s.Write(account("alice", 10), tuplespace.Forever); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	events := make(chan tuplespace.Event, 10)
+	s.Notify(tuplespace.MakeTuple(tuplespace.Any(), tuplespace.Any()), tuplespace.Forever, func(e tuplespace.Event) { events <- e })
+
+	aborts := [][]Op{
+		{GetOp(anyAccount("alice")), WriteOp(account("bob", 10), tuplespace.Forever), GetOp(anyAccount("carol"))},
+		{GetOp(anyAccount("alice")), WriteOp(anyAccount("bob"), tuplespace.Forever)},
+		{GetOp(anyAccount("alice")), GetOp(anyAccount("alice"))},
+		{WriteOp(account("bob", 10), tuplespace.Forever), AbsentOp(anyAccount("alice"))},
+	}
+	for i, ops := range aborts {
+		if _, err := s.Transact(ops...); !errors.Is(err, ErrTxAborted) {
+			t.Errorf("transaction %d: got %v, want it aborted", i, err)
+		}
+	}
+
+	if found, _ := s.Read(account("alice", 10)); !found.IsPresent() {
+		t.Error("an aborted transaction took a tuple")
+	}
+	if found, _ := s.Read(anyAccount("bob")); found.IsPresent() {
+		t.Error("an aborted transaction wrote a tuple")
+	}
+	select {
+	case event := <-events:
+		t.Errorf("an aborted transaction published a %s event for %s", event.Kind, event.Tuple)
+	default:
+	}
+}
+
+func TestAbsentOp(t *testing.T) {
+	s := newTestStore(t)
+	create := []Op{AbsentOp(anyAccount("alice")), WriteOp(account("alice", 10), tuplespace.Forever)}
+	if _, err := s.Transact(create...); err != nil {
+		t.Fatalf("Transact: %v", err)
+	}
+	if _, err := s.Transact(create...); !errors.Is(err, ErrTxAborted) {
+		t.Errorf("Transact with a match: got %v, want it aborted", err)
+	}
+	if n, _ := s.Count(anyAccount("alice")); n != 1 {
+		t.Errorf("got %d accounts, want 1", n)
+	}
+}
+
+func TestConcurrentTransactionsDoNotLoseUpdates(t *testing.T) {
+	s := newTestStore(t)
+	if err := s.Write(account("counter", 0), tuplespace.Forever); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	// Every increment swaps the exact tuple it read, so concurrent ones abort and retry
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				current, err := s.Read(anyAccount("counter"))
+				if err != nil || !current.IsPresent() {
+					t.Errorf("Read: got %v, %v", current, err)
+					return
+				}
+				n := current.Get().GetElements()[1].GetValue().(int)
+				_, err = s.Transact(GetOp(current.Get()), WriteOp(account("counter", n+1), tuplespace.Forever))
+				if err == nil {
+					return
+				}
+				if !errors.Is(err, ErrTxAborted) {
+					t.Errorf("Transact: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if found, _ := s.Read(account("counter", 8)); !found.IsPresent() {
+		current, _ := s.Read(anyAccount("counter"))
+		t.Errorf("got %v, want (\"counter\"|8)", current)
+	}
+}
+
+func TestStampOp(t *testing.T) {
+	s := newTestStore(t)
+	before := time.Now().UnixNano()
+	entry := tuplespace.MakeTuple(tuplespace.S("entry"), tuplespace.I(0))
+	if _, err := s.Transact(StampOp(entry, 1, tuplespace.Forever)); err != nil {
+		t.Fatalf("Transact: %v", err)
+	}
+
+	found, err := s.Read(anyAccount("entry"))
+	if err != nil || !found.IsPresent() {
+		t.Fatalf("Read: got %v, %v", found, err)
+	}
+	if stamp := found.Get().GetElements()[1].GetValue().(int); int64(stamp) < before || int64(stamp) > time.Now().UnixNano() {
+		t.Errorf("got timestamp %d, want the time of the transaction", stamp)
+	}
+
+	if _, err := s.Transact(StampOp(entry, 2, tuplespace.Forever)); !errors.Is(err, ErrTxAborted) {
+		t.Errorf("stamping a missing field: got %v, want the transaction aborted", err)
+	}
+}
@@ -0,0 +1,198 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	tuplespace "tuplespaceCD/pkg/tuplespace"
+
+	opt "github.com/micutio/goptional"
+)
+
+// FieldOp is a declarative change of a single tuple field, evaluated by the FSM itself so that
+// it can be replicated. Build it with `SetField` or `AddField`.
+type FieldOp struct {
+	Index int             `json:"index"`
+	Op    string          `json:"op"`
+	Value tuplespace.Elem `json:"value"`
+}
+
+// SetField replaces the field at `index` with `value`.
+func SetField(index int, value tuplespace.Elem) FieldOp {
+	return FieldOp{Index: index, Op: "set", Value: value}
+}
+
+// SetTime replaces the field at `index` with the leader timestamp of the log entry, as an INT
+// in Unix nanoseconds.
+func SetTime(index int) FieldOp {
+	return FieldOp{Index: index, Op: "time"}
+}
+
+// AddField adds `value` to the INT or FLOAT field at `index`. Both must be of the same type, and
+// a sum of INTs that overflows fails the update.
+func AddField(index int, value tuplespace.Elem) FieldOp {
+	return FieldOp{Index: index, Op: "add", Value: value}
+}
+
+// The FSM response to an update.
+type updateResult struct {
+	tuple opt.Maybe[tuplespace.Tuple]
+	err   error
+}
+
+// How often `Update` tries to swap the tuple before it gives up, and how long it waits after the
+// first conflict. The wait doubles after every further conflict.
+const (
+	maxUpdateAttempts = 8
+	updateBackoff     = 5 * time.Millisecond
+)
+
+// Update replaces a tuple matching the template with the one derived from it by `fn`, which
+// expires after `lease`. The tuple is read first and then swapped in a transaction that only
+// succeeds if it is still in the space, so concurrent updates never overwrite each other. After
+// a conflict it waits a little and starts over, up to `maxUpdateAttempts` times, and then fails
+// with `ErrTxAborted`. Returns the new tuple, or nothing if no tuple matches the template.
+func (s *Store) Update(template tuplespace.Tuple, lease time.Duration, fn func(tuplespace.Tuple) tuplespace.Tuple, opts ...Option) (opt.Maybe[tuplespace.Tuple], error) {
+	return s.With(opts...).Update(template, lease, fn)
+}
+
+// Update is like `Store.Update`, with the options of the scope. A request id is not given to the
+// reads, but to every swap followed by its attempt, e.g. "id/2", so that repeating the update
+// neither swaps twice nor takes the result of one attempt for another.
+func (w Scope) Update(template tuplespace.Tuple, lease time.Duration, fn func(tuplespace.Tuple) tuplespace.Tuple) (opt.Maybe[tuplespace.Tuple], error) {
+	var options command
+	for _, option := range w.opts {
+		option(&options)
+	}
+	readOpts := append(append([]Option(nil), w.opts...), RequestID(""))
+
+	backoff := updateBackoff
+	for attempt := 1; ; attempt++ {
+		current, err := w.store.Read(template, readOpts...)
+		if err != nil || !current.IsPresent() {
+			return current, err
+		}
+
+		updated := fn(current.Get())
+		if !updated.IsDefined() {
+			return opt.NewNothing[tuplespace.Tuple](), fmt.Errorf("update produced undefined tuple %s", updated)
+		}
+
+		swap := w
+		if options.RequestID != "" {
+			swap = w.store.With(append(append([]Option(nil), w.opts...), RequestID(fmt.Sprintf("%s/%d", options.RequestID, attempt)))...)
+		}
+		_, err = swap.Transact(GetOp(current.Get()), WriteOp(updated, lease))
+		if err == nil {
+			return opt.NewJust(updated), nil
+		}
+		if !errors.Is(err, ErrTxAborted) {
+			return opt.NewNothing[tuplespace.Tuple](), err
+		}
+		if attempt == maxUpdateAttempts {
+			return opt.NewNothing[tuplespace.Tuple](), fmt.Errorf("update of %s gave up after %d attempts: %w", template, attempt, err)
+		}
+
+		// Random jitter keeps conflicting updates from retrying in lockstep
+		time.Sleep(backoff + time.Duration(rand.Int63n(int64(backoff))))
+		backoff *= 2
+	}
+}
+
+// UpdateFields applies the field operations to a tuple matching the template in a single log
+// entry, e.g. `AddField(2, tuplespace.I(1))` increments the third field. The new tuple expires
+// after `lease`. Returns the new tuple, or nothing if no tuple matches the template. Use `With`
+// to pass options.
+func (s *Store) UpdateFields(template tuplespace.Tuple, lease time.Duration, ops ...FieldOp) (opt.Maybe[tuplespace.Tuple], error) {
+	return s.With().UpdateFields(template, lease, ops...)
+}
+
+// UpdateFields is like `Store.UpdateFields`, with the options of the scope.
+func (w Scope) UpdateFields(template tuplespace.Tuple, lease time.Duration, ops ...FieldOp) (opt.Maybe[tuplespace.Tuple], error) {
+	response, err := w.store.apply(&command{
+		Op:     "update",
+		Tuple:  template.GetElements(),
+		Lease:  lease,
+		Fields: ops,
+	}, w.opts)
+	if err != nil {
+		return opt.NewNothing[tuplespace.Tuple](), err
+	}
+
+	result, ok := response.(updateResult)
+	if !ok {
+		return opt.NewNothing[tuplespace.Tuple](), fmt.Errorf("unexpected response type")
+	}
+	return result.tuple, result.err
+}
+
+// applyFieldOps returns a copy of the tuple with the field operations applied, `now` being the
+// timestamp of the log entry.
+func applyFieldOps(tuple tuplespace.Tuple, ops []FieldOp, now time.Time) (tuplespace.Tuple, error) {
+	elements := append([]tuplespace.Elem(nil), tuple.GetElements()...)
+
+	for _, op := range ops {
+		if op.Index < 0 || op.Index >= len(elements) {
+			return tuple, fmt.Errorf("field %d out of range for tuple %s", op.Index, tuple)
+		}
+		field := elements[op.Index]
+
+		switch op.Op {
+		case "set":
+			elements[op.Index] = op.Value
+		case "time":
+			elements[op.Index] = tuplespace.I(int(now.UnixNano()))
+		case "add":
+			if field.GetType() != op.Value.GetType() {
+				return tuple, fmt.Errorf("cannot add %s to field %d of tuple %s", op.Value, op.Index, tuple)
+			}
+			switch field.GetType() {
+			case tuplespace.INT:
+				a, b := field.GetValue().(int), op.Value.GetValue().(int)
+				sum := a + b
+				if (b > 0 && sum < a) || (b < 0 && sum > a) {
+					return tuple, fmt.Errorf("adding %s to field %d of tuple %s overflows an int", op.Value, op.Index, tuple)
+				}
+				elements[op.Index] = tuplespace.I(sum)
+			case tuplespace.FLOAT:
+				elements[op.Index] = tuplespace.F(field.GetValue().(float64) + op.Value.GetValue().(float64))
+			default:
+				return tuple, fmt.Errorf("cannot add to non-numeric field %d of tuple %s", op.Index, tuple)
+			}
+		default:
+			return tuple, fmt.Errorf("unrecognized field op %q", op.Op)
+		}
+	}
+
+	updated := tuplespace.MakeTuple(elements...)
+	if !updated.IsDefined() {
+		return tuple, fmt.Errorf("update produced undefined tuple %s", updated)
+	}
+	return updated, nil
+}
+
+func (f *fsm) applyUpdate(name string, template tuplespace.Tuple, lease time.Duration, ops []FieldOp) interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	current := f.spaces[name].Read(template)
+	if !current.IsPresent() {
+		return updateResult{tuple: current}
+	}
+
+	updated, err := applyFieldOps(current.Get(), ops, f.logTime)
+	if err != nil {
+		return updateResult{tuple: opt.NewNothing[tuplespace.Tuple](), err: err}
+	}
+	if err := f.checkSchema(updated); err != nil {
+		return updateResult{tuple: opt.NewNothing[tuplespace.Tuple](), err: err}
+	}
+
+	f.spaces[name].Get(current.Get())
+	f.spaces[name].Write(updated, lease)
+	f.notifier(name).Publish(tuplespace.TAKEN, current.Get())
+	f.notifier(name).Publish(tuplespace.WRITTEN, updated)
+	return updateResult{tuple: opt.NewJust(updated)}
+}
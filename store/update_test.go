@@ -0,0 +1,186 @@
+package store
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	tuplespace "tuplespaceCD/pkg/tuplespace"
+)
+
+func TestApplyFieldOps(t *testing.T) {
+	n := func(value tuplespace.Elem) tuplespace.Tuple {
+		return tuplespace.MakeTuple(tuplespace.S("n"), value)
+	}
+	tests := []struct {
+		tuple tuplespace.Tuple
+		ops   []FieldOp
+		want  string
+		err   bool
+	}{
+		{n(tuplespace.I(1)), []FieldOp{AddField(1, tuplespace.I(2))}, `("n"|3)`, false},
+		{n(tuplespace.I(1)), []FieldOp{AddField(1, tuplespace.I(-2))}, `("n"|-1)`, false},
+		{n(tuplespace.F(1.5)), []FieldOp{AddField(1, tuplespace.F(1))}, `("n"|2.5)`, false},
+		{n(tuplespace.I(1)), []FieldOp{SetField(1, tuplespace.S("x")), SetField(0, tuplespace.I(7))}, `(7|"x")`, false},
+		{n(tuplespace.I(1)), []FieldOp{AddField(1, tuplespace.I(math.MaxInt-1))}, `("n"|9223372036854775807)`, false},
+		{n(tuplespace.I(1)), []FieldOp{AddField(1, tuplespace.I(math.MaxInt))}, "", true},
+		{n(tuplespace.I(-1)), []FieldOp{AddField(1, tuplespace.I(math.MinInt))}, "", true},
+		{n(tuplespace.I(1)), []FieldOp{AddField(1, tuplespace.F(1))}, "", true},
+		{n(tuplespace.I(1)), []FieldOp{AddField(0, tuplespace.S("x"))}, "", true},
+		{n(tuplespace.I(1)), []FieldOp{SetField(2, tuplespace.I(1))}, "", true},
+		{n(tuplespace.I(1)), []FieldOp{SetField(-1, tuplespace.I(1))}, "", true},
+		{n(tuplespace.I(1)), []FieldOp{SetField(1, tuplespace.Any())}, "", true},
+		{n(tuplespace.I(1)), []FieldOp{{Index: 1, Op: "mul"}}, "", true},
+		{n(tuplespace.I(1)), []FieldOp{SetTime(1)}, `("n"|5000000000)`, false},
+		{n(tuplespace.I(1)), []FieldOp{SetTime(2)}, "", true},
+	}
+
+	now := time.Unix(5, 0)
+	for _, test := range tests {
+		got, err := applyFieldOps(test.tuple, test.ops, now)
+		if (err != nil) != test.err {
+			t.Errorf("%s %v: got error %v, want error %v", test.tuple, test.ops, err, test.err)
+			continue
+		}
+		if test.err {
+			if got.String() != test.tuple.String() {
+				t.Errorf("%s %v: got %s on error, want the tuple unchanged", test.tuple, test.ops, got)
+			}
+		} else if got.String() != test.want {
+			t.Errorf("%s %v: got %s, want %s", test.tuple, test.ops, got, test.want)
+		}
+	}
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+	s := newTestStore(t)
+	template := tuplespace.MakeTuple(tuplespace.S("counter"), tuplespace.Any())
+	if err := s.Write(tuplespace.MakeTuple(tuplespace.S("counter"), tuplespace.I(0)), tuplespace.Forever); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	increment := func(current tuplespace.Tuple) tuplespace.Tuple {
+		n := current.GetElements()[1].GetValue().(int)
+		return tuplespace.MakeTuple(tuplespace.S("counter"), tuplespace.I(n+1))
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := s.Update(template, tuplespace.Forever, increment); err != nil {
+				t.Errorf("Update: %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := s.UpdateFields(template, tuplespace.Forever, AddField(1, tuplespace.I(1))); err != nil {
+				t.Errorf("UpdateFields: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	found, err := s.Read(template)
+	if err != nil || !found.IsPresent() || found.Get().String() != `("counter"|10)` {
+		t.Errorf("got %v, %v, want (\"counter\"|10)", found, err)
+	}
+}
+
+func TestUpdateWithoutMatch(t *testing.T) {
+	s := newTestStore(t)
+	template := tuplespace.MakeTuple(tuplespace.S("missing"), tuplespace.Any())
+
+	updated, err := s.Update(template, tuplespace.Forever, func(current tuplespace.Tuple) tuplespace.Tuple {
+		t.Error("fn called without a match")
+		return current
+	})
+	if err != nil || updated.IsPresent() {
+		t.Errorf("Update: got %v, %v, want nothing", updated, err)
+	}
+	if updated, err := s.UpdateFields(template, tuplespace.Forever, AddField(1, tuplespace.I(1))); err != nil || updated.IsPresent() {
+		t.Errorf("UpdateFields: got %v, %v, want nothing", updated, err)
+	}
+
+	// A failing field op leaves the tuple alone
+	if err := s.Write(tuplespace.MakeTuple(tuplespace.S("name"), tuplespace.S("x")), tuplespace.Forever); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if _, err := s.UpdateFields(tuplespace.MakeTuple(tuplespace.S("name"), tuplespace.Any()), tuplespace.Forever, AddField(1, tuplespace.I(1))); err == nil {
+		t.Error("adding to a string succeeded")
+	}
+	if found, _ := s.Read(tuplespace.MakeTuple(tuplespace.S("name"), tuplespace.S("x"))); !found.IsPresent() {
+		t.Error("a failed update removed the tuple")
+	}
+}
+
+func TestUpdateGivesUp(t *testing.T) {
+	s := newTestStore(t)
+	template := tuplespace.MustParse(`("counter"|?int)`)
+	if err := s.Write(tuplespace.MustParse(`("counter"|0)`), tuplespace.Forever); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	// Every attempt conflicts with an update made while the new tuple is computed
+	attempts := 0
+	_, err := s.Update(template, tuplespace.Forever, func(current tuplespace.Tuple) tuplespace.Tuple {
+		attempts++
+		if _, err := s.UpdateFields(template, tuplespace.Forever, AddField(1, tuplespace.I(1))); err != nil {
+			t.Fatalf("UpdateFields: %v", err)
+		}
+		return tuplespace.MakeTuple(tuplespace.S("counter"), tuplespace.I(-1))
+	})
+	if !errors.Is(err, ErrTxAborted) {
+		t.Errorf("got %v, want an aborted update", err)
+	}
+	if attempts != maxUpdateAttempts {
+		t.Errorf("got %d attempts, want %d", attempts, maxUpdateAttempts)
+	}
+
+	updated, err := s.Update(template, tuplespace.Forever, func(current tuplespace.Tuple) tuplespace.Tuple {
+		n := current.GetElements()[1].GetValue().(int)
+		return tuplespace.MakeTuple(tuplespace.S("counter"), tuplespace.I(n*10))
+	})
+	if err != nil || !updated.IsPresent() || updated.Get().String() != `("counter"|80)` {
+		t.Errorf("Update: got %v, %v, want (\"counter\"|80)", updated, err)
+	}
+}
+
+func TestUpdateWithOptions(t *testing.T) {
+	s := newTestStore(t)
+	if _, err := s.CreateSpace("other"); err != nil {
+		t.Fatalf("CreateSpace: %v", err)
+	}
+	if err := s.Write(account("counter", 0), tuplespace.Forever, InSpace("other")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	increment := func(tuple tuplespace.Tuple) tuplespace.Tuple {
+		return account("counter", tuple.GetElements()[1].GetValue().(int)+1)
+	}
+
+	// Repeating an update with the same request id does not apply it twice
+	for i := 0; i < 2; i++ {
+		if _, err := s.Update(anyAccount("counter"), tuplespace.Forever, increment, InSpace("other"), RequestID("r1")); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+	}
+	if _, err := s.With(InSpace("other"), RequestID("r2")).Update(anyAccount("counter"), tuplespace.Forever, increment); err != nil {
+		t.Fatalf("Scope.Update: %v", err)
+	}
+	if n, _ := s.Count(account("counter", 2), InSpace("other")); n != 1 {
+		t.Errorf("got %d counters at 2, want the update with each id applied once", n)
+	}
+	if found, _ := s.Read(anyAccount("counter")); found.IsPresent() {
+		t.Errorf("the default space holds %s", found.Get())
+	}
+
+	// The principal is checked for the read and the swap
+	if err := s.Bootstrap("root", "root-token"); err != nil {
+		t.Fatalf("Bootstrap: %v", err)
+	}
+	if _, err := s.Update(anyAccount("counter"), tuplespace.Forever, increment, InSpace("other"), As(Anonymous)); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("Update by anonymous: got %v, want permission denied", err)
+	}
+}